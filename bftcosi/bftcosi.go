@@ -4,14 +4,27 @@ package bftcosi
 BFTCoSi is a byzantine-fault-tolerant protocol to sign a message given a
 verification-function. It uses two rounds of signing - the first round
 indicates the willingness of the rounds to sign the message, and the second
-round is only started if at least a 'threshold' number of nodes signed off in
-the first round.
+round is only started if enough nodes signed off in the first round, as
+defined by the ThresholdPolicy of the protocol.
+
+Every node waits a limited time for the commitments and responses of its
+children. Children that don't answer in time are left out together with their
+subtree: if they miss the commitment, they are removed from the participation
+mask, if they miss the response, they are added as exceptions. The root
+additionally aborts the protocol if no signature has been found before the
+Deadline.
+
+A node refusing to sign adds an exception with the reason of its refusal,
+signed with its private key. The reason is given by the ReasonFunction, if
+set, so that the root can tell its client why the signature failed.
 */
 
 import (
 	"crypto/sha512"
 	"errors"
+	"fmt"
 	"sync"
+	"time"
 
 	"gopkg.in/dedis/crypto.v0/abstract"
 	"gopkg.in/dedis/crypto.v0/cosi"
@@ -19,12 +32,21 @@ import (
 	"gopkg.in/dedis/onet.v1/log"
 )
 
+// DefaultTimeout is the time the nodes just above the leaves wait for the
+// commitments and responses of their children. Nodes higher up in the tree
+// wait a multiple of it, depending on the height of their subtree.
+const DefaultTimeout = 2 * time.Second
+
+// DefaultDeadline is the time after which the root aborts the protocol if
+// no signature has been found.
+const DefaultDeadline = time.Minute
+
 // VerificationFunction can be passes to each protocol node. It will be called
 // (in a go routine) during the (start/handle) challenge prepare phase of the
 // protocol. The passed message is the same as sent in the challenge phase.
-// The `Data`-part is only to help the VerificationFunction do it's job. In
-// the case of the services, this part should be replaced by the correct
-// passing of the service-configuration-data, which is not done yet.
+// The `Data`-part is only to help the VerificationFunction do it's job. The
+// services use it to pass the context needed for the verification, e.g. the
+// skipchain-service passes the previous block and the new block.
 type VerificationFunction func(Msg []byte, Data []byte) bool
 
 // ProtocolBFTCoSi is the main struct for running the protocol
@@ -44,16 +66,34 @@ type ProtocolBFTCoSi struct {
 	// Challenge of the commit phase and will be used during the response of the
 	// commit phase to put an exception or to sign.
 	signRefusal bool
-	// threshold for how much exception
-	threshold int
+	// refusalReason is why we refuse to sign in the commit phase
+	refusalReason error
+	// Policy decides how many exceptions are accepted. It must be the
+	// same for all nodes, so it should be set when the protocol is
+	// instantiated.
+	Policy ThresholdPolicy
 	// our index in the Roster list
 	index int
+	// Timeout is the time the nodes just above the leaves wait for the
+	// commitments and responses of their children. It is set on the root
+	// and passed down the tree with the announcement.
+	Timeout time.Duration
+	// Deadline is the time after which the root aborts the protocol.
+	Deadline time.Duration
+	// err is the reason the root aborted the protocol
+	err error
+	// deadline gets the error that aborts the protocol once the Deadline
+	// passed. It is only filled on the root and read by Dispatch.
+	deadline chan error
+	// deadlineTimer fills deadline, it is stopped when the root finishes
+	deadlineTimer *time.Timer
+	finishOnce    sync.Once
 
 	// onet-channels used to communicate the protocol
 	// channel for announcement
 	announceChan chan announceChan
 	// channel for commitment
-	commitChan chan []commitChan
+	commitChan chan commitChan
 	// Two channels for the challenge through the 2 rounds: difference is that
 	// during the commit round, we need the previous signature of the "prepare"
 	// round.
@@ -62,11 +102,12 @@ type ProtocolBFTCoSi struct {
 	// channel for challenge during the commit phase
 	challengeCommitChan chan challengeCommitChan
 	// channel for response
-	responseChan chan []responseChan
+	responseChan chan responseChan
 
 	// Internal communication channels
-	// channel used to wait for the verification of the block
-	verifyChan chan bool
+	// channel used to wait for the verification of the block, nil if
+	// the block is accepted, else the reason of the refusal
+	verifyChan chan error
 
 	// handler-functions
 	// onDone is the callback that will be called at the end of the
@@ -79,6 +120,9 @@ type ProtocolBFTCoSi struct {
 	// VerificationFunction will be called
 	// during the (start/handle) challenge prepare phase of the protocol
 	VerificationFunction VerificationFunction
+	// ReasonFunction is called instead of the VerificationFunction if it
+	// is set. The reason of a refusal is signed and passed up to the root.
+	ReasonFunction ReasonFunction
 	// closing is true if the node is being shut down
 	closing bool
 	// mutex for closing down properly
@@ -100,30 +144,83 @@ type collectStructs struct {
 
 	// mutex for all temporary structures
 	tmpMutex sync.Mutex
-	// exceptions given during the rounds that is used in the signature
+	// exceptions given during the "prepare" round that are used in the
+	// signature
 	tempExceptions []Exception
-	// temporary buffer of "prepare" commitments
-	tempPrepareCommit []abstract.Point
-	// temporary buffer of "commit" commitments
-	tempCommitCommit []abstract.Point
+	// exceptions of the "commit" round, for the cosigners that didn't send
+	// their response
+	tempCommitExceptions []Exception
+	// what our children sent during the "prepare" round
+	prepareChildren *childState
+	// what our children sent during the "commit" round
+	commitChildren *childState
 	// temporary buffer of "prepare" responses
 	tempPrepareResponse []abstract.Scalar
 	// temporary buffer of "commit" responses
 	tempCommitResponse []abstract.Scalar
 }
 
-// NewBFTCoSiProtocol returns a new bftcosi struct
+// childState holds what the children sent during one round, so that the
+// children that didn't answer in time can be left out of the signature.
+type childState struct {
+	// commits holds the commitments of the children that answered
+	commits map[onet.TreeNodeID]*Commitment
+	// responded holds the children that sent their response
+	responded map[onet.TreeNodeID]bool
+	// missing holds the roster-indexes of the cosigners of our subtree
+	// that didn't send a commitment
+	missing []int
+	// commitDone is true once the commitment is sent to the parent
+	commitDone bool
+	// responseDone is true once the response is sent to the parent
+	responseDone bool
+}
+
+func newChildState() *childState {
+	return &childState{
+		commits:   map[onet.TreeNodeID]*Commitment{},
+		responded: map[onet.TreeNodeID]bool{},
+	}
+}
+
+// NewBFTCoSiProtocol returns a new bftcosi struct using the DefaultPolicy.
 func NewBFTCoSiProtocol(n *onet.TreeNodeInstance, verify VerificationFunction) (*ProtocolBFTCoSi, error) {
+	return NewBFTCoSiProtocolPolicy(n, verify,
+		DefaultPolicy(len(n.Tree().List())))
+}
+
+// NewBFTCoSiProtocolReason returns a new bftcosi struct that accepts the
+// exceptions allowed by the given policy and uses a ReasonFunction, so that
+// the reasons of the refusals are passed to the root.
+func NewBFTCoSiProtocolReason(n *onet.TreeNodeInstance, reason ReasonFunction,
+	policy ThresholdPolicy) (*ProtocolBFTCoSi, error) {
+	bft, err := NewBFTCoSiProtocolPolicy(n, nil, policy)
+	if err != nil {
+		return nil, err
+	}
+	bft.ReasonFunction = reason
+	return bft, nil
+}
+
+// NewBFTCoSiProtocolPolicy returns a new bftcosi struct that accepts the
+// exceptions allowed by the given policy.
+func NewBFTCoSiProtocolPolicy(n *onet.TreeNodeInstance, verify VerificationFunction,
+	policy ThresholdPolicy) (*ProtocolBFTCoSi, error) {
 	// initialize the bftcosi node/protocol-instance
 	bft := &ProtocolBFTCoSi{
 		TreeNodeInstance: n,
 		collectStructs: collectStructs{
-			prepare: cosi.NewCosi(n.Suite(), n.Private(), n.Roster().Publics()),
-			commit:  cosi.NewCosi(n.Suite(), n.Private(), n.Roster().Publics()),
+			prepare:         cosi.NewCosi(n.Suite(), n.Private(), n.Roster().Publics()),
+			commit:          cosi.NewCosi(n.Suite(), n.Private(), n.Roster().Publics()),
+			prepareChildren: newChildState(),
+			commitChildren:  newChildState(),
 		},
-		verifyChan:           make(chan bool),
+		verifyChan:           make(chan error, 1),
 		VerificationFunction: verify,
-		threshold:            (len(n.Tree().List()) + 1) * 2 / 3,
+		Policy:               policy,
+		Timeout:              DefaultTimeout,
+		Deadline:             DefaultDeadline,
+		deadline:             make(chan error, 1),
 		Msg:                  make([]byte, 0),
 		Data:                 make([]byte, 0),
 	}
@@ -146,8 +243,13 @@ func NewBFTCoSiProtocol(n *onet.TreeNodeInstance, verify VerificationFunction) (
 
 // Start will start both rounds "prepare" and "commit" at same time. The
 // "commit" round will wait till the end of the "prepare" round during its
-// challenge phase.
+// challenge phase. If no signature is found before the Deadline, Dispatch
+// aborts the protocol.
 func (bft *ProtocolBFTCoSi) Start() error {
+	deadline := bft.Deadline
+	bft.deadlineTimer = time.AfterFunc(deadline, func() {
+		bft.deadline <- fmt.Errorf("No signature found within %s", deadline)
+	})
 	if err := bft.startAnnouncement(RoundPrepare); err != nil {
 		return err
 	}
@@ -179,59 +281,107 @@ func (bft *ProtocolBFTCoSi) Dispatch() error {
 	}
 	bft.closingMutex.Unlock()
 
-	// Wait for both prepare and commit round
+	// Wait for the announcements of both prepare and commit round
 	for i := 0; i < 2; i++ {
-		// Wait for announcement message
 		if err := bft.handleAnnouncement(<-bft.announceChan); err != nil {
 			return err
 		}
-		// Wait for commitment messages of all children
-		if err := bft.handleCommitment(<-bft.commitChan); err != nil {
-			return err
-		}
+	}
+	// Wait for commitment messages of all children
+	if err := bft.collectCommitments(); err != nil {
+		return err
 	}
 
 	// Finish the preparation round
-	if err := bft.handleChallengePrepare(<-bft.challengePrepareChan); err != nil {
+	var chPrepare challengePrepareChan
+	select {
+	case chPrepare = <-bft.challengePrepareChan:
+	case err := <-bft.deadline:
+		return bft.abort(err)
+	}
+	if err := bft.handleChallengePrepare(chPrepare); err != nil {
 		return err
 	}
-	if err := bft.handleResponse(<-bft.responseChan); err != nil {
+	if err := bft.collectResponses(RoundPrepare); err != nil {
 		return err
 	}
 
 	// Finish the commit round
-	if err := bft.handleChallengeCommit(<-bft.challengeCommitChan); err != nil {
-		return err
+	var chCommit challengeCommitChan
+	select {
+	case chCommit = <-bft.challengeCommitChan:
+	case err := <-bft.deadline:
+		return bft.abort(err)
 	}
-	if err := bft.handleResponse(<-bft.responseChan); err != nil {
+	if err := bft.handleChallengeCommit(chCommit); err != nil {
 		return err
 	}
-
-	return nil
+	return bft.collectResponses(RoundCommit)
 }
 
 // Signature will generate the final signature, the output of the BFTCoSi
 // protocol.
-// The signature contains the commit round signature, with the message.
+// The signature contains the commit round signature, with the message, and
+// the exceptions for the cosigners that didn't send their response in the
+// commit round.
 // If the prepare phase failed, the signature will be nil and the Exceptions
 // will contain the exception from the prepare phase. It can be useful to see
 // which cosigners refused to sign (each exceptions contains the index of a
-// refusing-to-sign signer).
+// refusing-to-sign signer). If the protocol has been aborted, the signature
+// is nil and Err returns the reason.
 // Expect this function to have an undefined behavior when called from a
 // non-root Node.
 func (bft *ProtocolBFTCoSi) Signature() *BFTSignature {
+	bft.tmpMutex.Lock()
+	defer bft.tmpMutex.Unlock()
 	bftSig := &BFTSignature{
-		Sig:        bft.commit.Signature(),
-		Msg:        bft.Msg,
-		Exceptions: nil,
+		Msg: bft.Msg,
 	}
-	if bft.signRefusal {
-		bftSig.Sig = nil
+	switch {
+	case bft.signRefusal:
 		bftSig.Exceptions = bft.tempExceptions
+	case bft.err == nil:
+		bftSig.Sig = bft.commit.Signature()
+		bftSig.Exceptions = bft.tempCommitExceptions
 	}
 	return bftSig
 }
 
+// Err returns the reason why the root aborted the protocol, or nil if it
+// has not been aborted.
+func (bft *ProtocolBFTCoSi) Err() error {
+	bft.tmpMutex.Lock()
+	defer bft.tmpMutex.Unlock()
+	return bft.err
+}
+
+// Refusals returns the indexes in the roster of the cosigners that refused
+// to sign or didn't answer during the prepare phase, even if the final
+// signature succeeded.
+// Expect this function to have an undefined behavior when called from a
+// non-root Node.
+func (bft *ProtocolBFTCoSi) Refusals() []int {
+	bft.tmpMutex.Lock()
+	defer bft.tmpMutex.Unlock()
+	refusals := make([]int, len(bft.tempExceptions))
+	for i, ex := range bft.tempExceptions {
+		refusals[i] = ex.Index
+	}
+	return refusals
+}
+
+// SignedRefusals returns the correctly signed refusals of the cosigners
+// that refused to sign during the prepare phase, indexed by their position
+// in the roster. Cosigners that didn't answer are left out.
+// Expect this function to have an undefined behavior when called from a
+// non-root Node.
+func (bft *ProtocolBFTCoSi) SignedRefusals() map[int]*Refusal {
+	bft.tmpMutex.Lock()
+	bs := &BFTSignature{Msg: bft.Msg, Exceptions: bft.tempExceptions}
+	bft.tmpMutex.Unlock()
+	return bs.Refusals(bft.Suite(), bft.Roster().Publics())
+}
+
 // RegisterOnDone registers a callback to call when the bftcosi protocols has
 // really finished
 func (bft *ProtocolBFTCoSi) RegisterOnDone(fn func()) {
@@ -267,61 +417,115 @@ func (bft *ProtocolBFTCoSi) handleAnnouncement(msg announceChan) error {
 	if bft.isClosing() {
 		return errors.New("Closing")
 	}
+	if ann.Timeout > 0 {
+		bft.Timeout = time.Duration(ann.Timeout) * time.Millisecond
+	}
 	if bft.IsLeaf() {
 		return bft.startCommitment(ann.TYPE)
 	}
-	return bft.SendToChildrenInParallel(&ann)
+	bft.sendToChildren(&ann)
+	return nil
 }
 
-// handleCommitment collects all commitments from children and passes them
-// to the parent or starts the challenge-round if it's the root.
-func (bft *ProtocolBFTCoSi) handleCommitment(msgs []commitChan) error {
-	bft.tmpMutex.Lock()
-	defer bft.tmpMutex.Unlock()
-	for _, msg := range msgs {
-		comm := msg.Commitment
-		if bft.isClosing() {
-			return nil
-		}
-
-		var commitment abstract.Point
-		// store it and check if we have enough commitments
-		switch comm.TYPE {
-		case RoundPrepare:
-			bft.tempPrepareCommit = append(bft.tempPrepareCommit, comm.Commitment)
-			if len(bft.tempPrepareCommit) < len(bft.Children()) {
-				continue
-			}
-			commitment = bft.prepare.Commit(nil, bft.tempPrepareCommit)
-			if bft.IsRoot() {
-				if err := bft.startChallenge(RoundPrepare); err != nil {
-					return nil
-				}
-				continue
+// collectCommitments waits for the commitments of both rounds from all
+// children. If some children don't answer before the timeout, they and
+// their subtrees are marked as missing.
+func (bft *ProtocolBFTCoSi) collectCommitments() error {
+	if bft.IsLeaf() {
+		return nil
+	}
+	timeout := time.After(bft.waitTime())
+	for !bft.prepareChildren.commitDone || !bft.commitChildren.commitDone {
+		select {
+		case msg, ok := <-bft.commitChan:
+			if !ok {
+				return nil
 			}
-		case RoundCommit:
-			bft.tempCommitCommit = append(bft.tempCommitCommit, comm.Commitment)
-			if len(bft.tempCommitCommit) < len(bft.Children()) {
-				continue
+			if err := bft.handleCommitment(msg); err != nil {
+				return err
 			}
-			commitment = bft.commit.Commit(nil, bft.tempCommitCommit)
-			if bft.IsRoot() {
-				// do nothing:
-				// stop the processing of the round, wait the end of
-				// the "prepare" round: calls startChallengeCommit
-				continue
+		case err := <-bft.deadline:
+			return bft.abort(err)
+		case <-timeout:
+			for _, t := range []RoundType{RoundPrepare, RoundCommit} {
+				if err := bft.commitmentTimeout(t); err != nil {
+					return err
+				}
 			}
 		}
-		// set same RoundType as for the received commitment:
-		typedCommitment := &Commitment{
-			TYPE:       comm.TYPE,
-			Commitment: commitment,
+	}
+	return nil
+}
+
+// handleCommitment stores the commitment of a child and, once all children
+// of that round sent their commitment, finishes the commitment phase.
+func (bft *ProtocolBFTCoSi) handleCommitment(msg commitChan) error {
+	if bft.isClosing() {
+		return nil
+	}
+	comm := msg.Commitment
+	children := bft.getChildren(comm.TYPE)
+	if children.commitDone {
+		log.Lvl2(bft.Name(), "Ignoring late commitment from", msg.ServerIdentity)
+		return nil
+	}
+	if _, exists := children.commits[msg.TreeNode.ID]; exists {
+		return nil
+	}
+	children.commits[msg.TreeNode.ID] = &comm
+	children.missing = append(children.missing, comm.Missing...)
+	if len(children.commits) < len(bft.Children()) {
+		return nil
+	}
+	return bft.finishCommitment(comm.TYPE)
+}
+
+// commitmentTimeout marks all children that didn't send a commitment for
+// this round, together with their subtrees, as missing and finishes the
+// commitment phase.
+func (bft *ProtocolBFTCoSi) commitmentTimeout(t RoundType) error {
+	children := bft.getChildren(t)
+	if children.commitDone {
+		return nil
+	}
+	for _, c := range bft.Children() {
+		if _, ok := children.commits[c.ID]; !ok {
+			log.Lvl2(bft.Name(), "Didn't get commitment from", c.ServerIdentity)
+			children.missing = append(children.missing, subtreeIndexes(c)...)
 		}
-		if err := bft.SendToParent(typedCommitment); err != nil {
-			return err
+	}
+	return bft.finishCommitment(t)
+}
+
+// finishCommitment passes the aggregate commitment to the parent or starts
+// the challenge-round if it's the root. The root leaves the missing
+// cosigners out of the participation mask.
+func (bft *ProtocolBFTCoSi) finishCommitment(t RoundType) error {
+	children := bft.getChildren(t)
+	children.commitDone = true
+	commits := make([]abstract.Point, 0, len(children.commits))
+	for _, c := range children.commits {
+		commits = append(commits, c.Commitment)
+	}
+	commitment := bft.getCosi(t).Commit(nil, commits)
+	if bft.IsRoot() {
+		for _, i := range children.missing {
+			bft.getCosi(t).SetMaskBit(i, false)
+		}
+		if t == RoundPrepare {
+			return bft.startChallenge(RoundPrepare)
 		}
+		// do nothing:
+		// stop the processing of the round, wait the end of
+		// the "prepare" round: calls startChallengeCommit
+		return nil
 	}
-	return nil
+	// set same RoundType as for the received commitment:
+	return bft.SendToParent(&Commitment{
+		TYPE:       t,
+		Commitment: commitment,
+		Missing:    children.missing,
+	})
 }
 
 // handleChallengePrepare collects the challenge-messages
@@ -338,17 +542,19 @@ func (bft *ProtocolBFTCoSi) handleChallengePrepare(msg challengePrepareChan) err
 		bft.prepare.Challenge(ch.Challenge)
 	}
 	go func() {
-		bft.verifyChan <- bft.VerificationFunction(bft.Msg, bft.Data)
+		bft.verifyChan <- verifyMessage(bft.VerificationFunction,
+			bft.ReasonFunction, bft.Msg, bft.Data)
 	}()
 	// go to response if leaf
 	if bft.IsLeaf() {
 		return bft.startResponse(RoundPrepare)
 	}
-	return bft.SendToChildrenInParallel(&ch)
+	bft.sendToChildren(&ch)
+	return nil
 }
 
-// handleChallengeCommit verifies the signature and checks if not more than
-// the threshold of participants refused to sign
+// handleChallengeCommit verifies the signature and checks if the policy
+// accepts the participants that refused to sign
 func (bft *ProtocolBFTCoSi) handleChallengeCommit(msg challengeCommitChan) error {
 	if bft.isClosing() {
 		return nil
@@ -366,16 +572,13 @@ func (bft *ProtocolBFTCoSi) handleChallengeCommit(msg challengeCommitChan) error
 		Msg:        data[:],
 		Exceptions: ch.Signature.Exceptions,
 	}
-	if err := bftPrepareSig.Verify(bft.Suite(), bft.Roster().Publics()); err != nil {
+	if err := bftPrepareSig.VerifyPolicy(bft.Suite(), bft.Roster().Publics(),
+		bft.Policy); err != nil {
 		log.Lvl3(bft.Name(), "Verification of the signature failed:", err)
+		bft.tmpMutex.Lock()
 		bft.signRefusal = true
-	}
-
-	// Check if we have no more than threshold failed nodes
-	if len(ch.Signature.Exceptions) >= int(bft.threshold) {
-		log.Lvlf3("%s: More than threshold (%d/%d) refused to sign - aborting.",
-			bft.Roster(), len(ch.Signature.Exceptions), len(bft.Roster().List))
-		bft.signRefusal = true
+		bft.refusalReason = errors.New("Invalid prepare signature: " + err.Error())
+		bft.tmpMutex.Unlock()
 	}
 
 	// store the exceptions for later usage
@@ -385,36 +588,121 @@ func (bft *ProtocolBFTCoSi) handleChallengeCommit(msg challengeCommitChan) error
 		return bft.handleResponseCommit(nil)
 	}
 
-	return bft.SendToChildrenInParallel(&ch)
+	bft.sendToChildren(&ch)
+	return nil
+}
+
+// collectResponses waits for the responses of all children that sent a
+// commitment in this round. If some children don't answer before the
+// timeout, they and their subtrees are added as exceptions.
+func (bft *ProtocolBFTCoSi) collectResponses(t RoundType) error {
+	if bft.IsLeaf() {
+		return nil
+	}
+	children := bft.getChildren(t)
+	if len(children.commits) == 0 {
+		// nobody to wait for
+		return bft.finishResponse(t)
+	}
+	timeout := time.After(bft.waitTime())
+	for !children.responseDone {
+		select {
+		case msg, ok := <-bft.responseChan:
+			if !ok {
+				return errors.New("Quitting instance")
+			}
+			if err := bft.handleResponse(msg); err != nil {
+				return err
+			}
+		case err := <-bft.deadline:
+			return bft.abort(err)
+		case <-timeout:
+			bft.responseTimeout(t)
+			return bft.finishResponse(t)
+		}
+	}
+	return nil
 }
 
 // handleResponse is called when a response message arrives.
-func (bft *ProtocolBFTCoSi) handleResponse(msgs []responseChan) error {
-	for _, msg := range msgs {
-		if bft.isClosing() {
-			return errors.New("Quitting instance")
+func (bft *ProtocolBFTCoSi) handleResponse(msg responseChan) error {
+	if bft.isClosing() {
+		return errors.New("Quitting instance")
+	}
+	var resp *Response
+	if !bft.IsLeaf() {
+		children := bft.getChildren(msg.Response.TYPE)
+		_, committed := children.commits[msg.TreeNode.ID]
+		if children.responseDone || !committed ||
+			children.responded[msg.TreeNode.ID] {
+			log.Lvl2(bft.Name(), "Ignoring response from", msg.ServerIdentity)
+			return nil
 		}
-		var resp *Response
-		if !bft.IsLeaf() {
-			resp = &msg.Response
+		children.responded[msg.TreeNode.ID] = true
+		resp = &msg.Response
+	}
+	if msg.Response.TYPE == RoundPrepare {
+		return bft.handleResponsePrepare(resp)
+	}
+	return bft.handleResponseCommit(resp)
+}
+
+// responseTimeout adds exceptions for all children that sent a commitment
+// but no response in this round. The exception of the child holds the
+// aggregate commitment of its subtree, the other cosigners of its subtree
+// get an empty commitment, except those already missing from the mask.
+func (bft *ProtocolBFTCoSi) responseTimeout(t RoundType) {
+	children := bft.getChildren(t)
+	var exceptions []Exception
+	for _, c := range bft.Children() {
+		comm, ok := children.commits[c.ID]
+		if !ok || children.responded[c.ID] {
+			continue
 		}
-		if msg.Response.TYPE == RoundPrepare {
-			if err := bft.handleResponsePrepare(resp); err != nil {
-				return err
+		log.Lvl2(bft.Name(), "Didn't get response from", c.ServerIdentity)
+		missing := map[int]bool{}
+		for _, i := range comm.Missing {
+			missing[i] = true
+		}
+		for _, i := range subtreeIndexes(c) {
+			if missing[i] {
+				continue
 			}
-		} else {
-			if err := bft.handleResponseCommit(resp); err != nil {
-				return err
+			commitment := bft.Suite().Point().Null()
+			if i == c.RosterIndex {
+				commitment = comm.Commitment
 			}
+			exceptions = append(exceptions, Exception{
+				Index:      i,
+				Commitment: commitment,
+			})
 		}
 	}
-	return nil
+	bft.tmpMutex.Lock()
+	if t == RoundPrepare {
+		bft.tempExceptions = append(bft.tempExceptions, exceptions...)
+	} else {
+		bft.tempCommitExceptions = append(bft.tempCommitExceptions, exceptions...)
+	}
+	bft.tmpMutex.Unlock()
+}
+
+// finishResponse sends our response without waiting for more responses of
+// the children.
+func (bft *ProtocolBFTCoSi) finishResponse(t RoundType) error {
+	if t == RoundPrepare {
+		return bft.handleResponsePrepare(nil)
+	}
+	return bft.handleResponseCommit(nil)
 }
 
 // startAnnouncementPrepare create its announcement for the prepare round and
 // sends it down the tree.
 func (bft *ProtocolBFTCoSi) startAnnouncement(t RoundType) error {
-	bft.announceChan <- announceChan{Announce: Announce{TYPE: t}}
+	bft.announceChan <- announceChan{Announce: Announce{
+		TYPE:    t,
+		Timeout: uint64(bft.Timeout / time.Millisecond),
+	}}
 	return nil
 }
 
@@ -465,8 +753,7 @@ func (bft *ProtocolBFTCoSi) startChallenge(t RoundType) error {
 
 // startResponse dispatches the response to the correct round-type
 func (bft *ProtocolBFTCoSi) startResponse(t RoundType) error {
-	bft.handleResponse([]responseChan{{Response: Response{TYPE: t}}})
-	return nil
+	return bft.handleResponse(responseChan{Response: Response{TYPE: t}})
 }
 
 // If 'r' is nil, it will starts the response process.
@@ -476,15 +763,18 @@ func (bft *ProtocolBFTCoSi) handleResponsePrepare(r *Response) error {
 		bft.tmpMutex.Lock()
 		bft.tempPrepareResponse = append(bft.tempPrepareResponse, r.Response)
 		bft.tempExceptions = append(bft.tempExceptions, r.Exceptions...)
-		if len(bft.tempPrepareResponse) < len(bft.Children()) {
-			bft.tmpMutex.Unlock()
+		bft.tmpMutex.Unlock()
+		if len(bft.prepareChildren.responded) < len(bft.prepareChildren.commits) {
 			return nil
 		}
-		bft.tmpMutex.Unlock()
 	}
+	bft.prepareChildren.responseDone = true
 
 	// wait for verification
-	bzrReturn, ok := bft.waitResponseVerification()
+	bzrReturn, ok, err := bft.waitResponseVerification()
+	if err != nil {
+		return err
+	}
 	// append response
 	if !ok {
 		log.Lvl2(bft.Roster(), "Refused to sign")
@@ -514,14 +804,13 @@ func (bft *ProtocolBFTCoSi) handleResponsePrepare(r *Response) error {
 		Sig:        cosiSig,
 		Exceptions: bft.tempExceptions,
 	}
-
-	aggCommit := bft.Suite().Point().Null()
-	for _, c := range bft.tempPrepareCommit {
-		aggCommit.Add(aggCommit, c)
-	}
-	if err := sig.Verify(bft.Suite(), bft.Roster().Publics()); err != nil {
+	if err := sig.VerifyPolicy(bft.Suite(), bft.Roster().Publics(),
+		bft.Policy); err != nil {
 		log.Error(bft.Name(), "Verification of the signature failed:", err)
+		bft.tmpMutex.Lock()
 		bft.signRefusal = true
+		bft.refusalReason = errors.New("Invalid prepare signature: " + err.Error())
+		bft.tmpMutex.Unlock()
 	}
 	log.Lvl3(bft.Name(), "Verification of signature successful")
 	// Start the challenge of the 'commit'-round
@@ -539,18 +828,19 @@ func (bft *ProtocolBFTCoSi) handleResponseCommit(r *Response) error {
 		// check if we have enough
 		bft.tmpMutex.Lock()
 		bft.tempCommitResponse = append(bft.tempCommitResponse, r.Response)
-
-		if len(bft.tempCommitResponse) < len(bft.Children()) {
-			bft.tmpMutex.Unlock()
-			return nil
-		}
+		bft.tempCommitExceptions = append(bft.tempCommitExceptions, r.Exceptions...)
 		bft.tmpMutex.Unlock()
-	} else {
-		r = &Response{
-			TYPE:     RoundCommit,
-			Response: bft.Suite().Scalar().Zero(),
+		if len(bft.commitChildren.responded) < len(bft.commitChildren.commits) {
+			return nil
 		}
 	}
+	bft.commitChildren.responseDone = true
+	bft.tmpMutex.Lock()
+	r = &Response{
+		TYPE:       RoundCommit,
+		Exceptions: bft.tempCommitExceptions,
+	}
+	bft.tmpMutex.Unlock()
 
 	var err error
 	if bft.IsLeaf() {
@@ -566,6 +856,8 @@ func (bft *ProtocolBFTCoSi) handleResponseCommit(r *Response) error {
 		r.Exceptions = append(r.Exceptions, Exception{
 			Index:      bft.index,
 			Commitment: bft.commit.GetCommitment(),
+			Refusal: newRefusal(bft.Suite(), bft.Private(), bft.Msg,
+				bft.refusalReason),
 		})
 		// don't include our own!
 		r.Response.Sub(r.Response, bft.commit.GetResponse())
@@ -575,11 +867,16 @@ func (bft *ProtocolBFTCoSi) handleResponseCommit(r *Response) error {
 	log.Lvl3(bft.Name(), "refusal=", bft.signRefusal)
 	// if root we have finished
 	if bft.IsRoot() {
-		sig := bft.Signature()
-		if bft.onSignatureDone != nil {
-			bft.onSignatureDone(sig)
+		if !bft.signRefusal {
+			// make sure the cosigners that didn't answer don't break
+			// the policy
+			err = bft.Signature().VerifyPolicy(bft.Suite(),
+				bft.Roster().Publics(), bft.Policy)
+			if err != nil {
+				err = errors.New("Final signature refused: " + err.Error())
+			}
 		}
-		bft.Done()
+		bft.finish(err)
 		return nil
 	}
 
@@ -593,21 +890,30 @@ func (bft *ProtocolBFTCoSi) handleResponseCommit(r *Response) error {
 // the BFTCoSiResponse along with the flag:
 // true => no exception, the verification is correct
 // false => exception, the verification failed
-func (bft *ProtocolBFTCoSi) waitResponseVerification() (*Response, bool) {
+// If the Deadline passes during the verification, the protocol is aborted
+// and the error is returned.
+func (bft *ProtocolBFTCoSi) waitResponseVerification() (*Response, bool, error) {
 	log.Lvl3(bft.Name(), "Waiting for response verification:")
 	// wait the verification
-	verified := <-bft.verifyChan
+	var reason error
+	select {
+	case reason = <-bft.verifyChan:
+	case err := <-bft.deadline:
+		return nil, false, bft.abort(err)
+	}
+	verified := reason == nil
 
 	resp, err := bft.prepare.Response(bft.tempPrepareResponse)
 	if err != nil {
-		return nil, false
+		return nil, false, err
 	}
 
 	if !verified {
-		// Add our exception
+		// Add our exception with the signed reason of the refusal
 		bft.tempExceptions = append(bft.tempExceptions, Exception{
 			Index:      bft.index,
 			Commitment: bft.prepare.GetCommitment(),
+			Refusal:    newRefusal(bft.Suite(), bft.Private(), bft.Msg, reason),
 		})
 		// Don't include our response!
 		resp = bft.Suite().Scalar().Set(resp).Sub(resp, bft.prepare.GetResponse())
@@ -621,7 +927,34 @@ func (bft *ProtocolBFTCoSi) waitResponseVerification() (*Response, bool) {
 	}
 
 	log.Lvl3(bft.Name(), "Response verification:", verified)
-	return r, verified
+	return r, verified, nil
+}
+
+// finish ends the protocol on the root, either with a signature or with the
+// error that made the root abort. Only the first call has an effect.
+func (bft *ProtocolBFTCoSi) finish(err error) {
+	bft.finishOnce.Do(func() {
+		if err != nil {
+			log.Lvl2(bft.Name(), "Aborting:", err)
+		}
+		if bft.deadlineTimer != nil {
+			bft.deadlineTimer.Stop()
+		}
+		bft.tmpMutex.Lock()
+		bft.err = err
+		bft.tmpMutex.Unlock()
+		if err == nil && bft.onSignatureDone != nil {
+			bft.onSignatureDone(bft.Signature())
+		}
+		bft.Done()
+	})
+}
+
+// abort finishes the protocol on the root with the error of the deadline
+// and returns it, so that Dispatch stops waiting for the other messages.
+func (bft *ProtocolBFTCoSi) abort(err error) error {
+	bft.finish(err)
+	return err
 }
 
 // nodeDone is either called by the end of EndProtocol or by the end of the
@@ -642,6 +975,49 @@ func (bft *ProtocolBFTCoSi) getCosi(t RoundType) *cosi.CoSi {
 	return bft.commit
 }
 
+func (bft *ProtocolBFTCoSi) getChildren(t RoundType) *childState {
+	if t == RoundPrepare {
+		return bft.prepareChildren
+	}
+	return bft.commitChildren
+}
+
+// sendToChildren sends the message to all children. Children that can't be
+// reached are left out later by the timeouts.
+func (bft *ProtocolBFTCoSi) sendToChildren(msg interface{}) {
+	if err := bft.SendToChildrenInParallel(msg); err != nil {
+		log.Lvl2(bft.Name(), "Couldn't send to all children:", err)
+	}
+}
+
+// waitTime returns how long we wait for the commitments or responses of
+// our children: the deeper our subtree, the longer we wait, so that our
+// children can time out first.
+func (bft *ProtocolBFTCoSi) waitTime() time.Duration {
+	return bft.Timeout * time.Duration(subtreeHeight(bft.TreeNode()))
+}
+
+// subtreeIndexes returns the roster-indexes of the node and all nodes
+// below it.
+func subtreeIndexes(tn *onet.TreeNode) []int {
+	indexes := []int{tn.RosterIndex}
+	for _, c := range tn.Children {
+		indexes = append(indexes, subtreeIndexes(c)...)
+	}
+	return indexes
+}
+
+// subtreeHeight returns the number of levels below the node.
+func subtreeHeight(tn *onet.TreeNode) int {
+	height := 0
+	for _, c := range tn.Children {
+		if h := subtreeHeight(c) + 1; h > height {
+			height = h
+		}
+	}
+	return height
+}
+
 func (bft *ProtocolBFTCoSi) isClosing() bool {
 	bft.closingMutex.Lock()
 	defer bft.closingMutex.Unlock()
@@ -14,6 +14,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/dedis/onet.v1"
 	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
 )
 
 type Counter struct {
@@ -92,7 +93,7 @@ func TestThreshold(t *testing.T) {
 		node, err := local.CreateProtocol(TestProtocolName, tree)
 		log.ErrFatal(err)
 		bc := node.(*ProtocolBFTCoSi)
-		assert.Equal(t, thr, bc.threshold, "hosts was %d", hosts)
+		assert.Equal(t, ExceptionThreshold(thr), bc.Policy, "hosts was %d", hosts)
 		local.CloseAll()
 	}
 }
@@ -176,6 +177,208 @@ func TestCheckRefuseParallel(t *testing.T) {
 	wg.Wait()
 }
 
+func TestCheckRefuseAllSign(t *testing.T) {
+	const TestProtocolName = "DummyBFTCoSiRefuseAllSign"
+
+	// Register test protocol using BFTCoSi
+	onet.GlobalProtocolRegister(TestProtocolName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return NewBFTCoSiProtocolPolicy(n, verifyRefuseMore, AllSign())
+	})
+
+	for refuseCount := 0; refuseCount <= 1; refuseCount++ {
+		log.Lvl2("AllSign with refuse at", refuseCount)
+		runProtocolOnce(t, 4, TestProtocolName, refuseCount, refuseCount == 0)
+	}
+}
+
+func TestThresholdPolicy(t *testing.T) {
+	assert.Nil(t, DefaultPolicy(4).Check(4, []int{0, 1}))
+	assert.NotNil(t, DefaultPolicy(4).Check(4, []int{0, 1, 2}))
+	assert.Nil(t, TwoThirds(4).Check(4, []int{0}))
+	assert.NotNil(t, TwoThirds(4).Check(4, []int{0, 1}))
+	assert.Nil(t, TwoThirds(7).Check(7, []int{0, 1}))
+	assert.NotNil(t, TwoThirds(7).Check(7, []int{0, 1, 2}))
+	assert.Nil(t, AllSign().Check(4, nil))
+	assert.NotNil(t, AllSign().Check(4, []int{3}))
+
+	weighted := &WeightedThreshold{Weights: []int{3, 1, 1, 1}, Min: 4}
+	assert.Nil(t, weighted.Check(4, []int{1, 2}))
+	assert.NotNil(t, weighted.Check(4, []int{0}))
+	assert.NotNil(t, weighted.Check(4, []int{4}))
+	assert.NotNil(t, weighted.Check(3, nil))
+}
+
+func TestTimeoutDeadSubtree(t *testing.T) {
+	const TestProtocolName = "DummyBFTCoSiDeadSubtree"
+
+	// Register test protocol using BFTCoSi
+	onet.GlobalProtocolRegister(TestProtocolName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return NewBFTCoSiProtocol(n, verifyAll)
+	})
+
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, _, tree := local.GenBigTree(7, 7, 2, true)
+	// kill a node in the middle of the tree before the protocol starts, so
+	// it's subtree is missing in the commitment phase
+	dead := tree.Root.Children[0]
+	killServer(local, dead.ServerIdentity)
+
+	root := runTimeout(t, local, tree, TestProtocolName, 200*time.Millisecond,
+		DefaultDeadline)
+	sig := root.Signature()
+	publics := root.Roster().Publics()
+	assert.Nil(t, sig.Verify(root.Suite(), publics))
+	assert.Equal(t, 0, len(sig.Exceptions))
+	assert.Nil(t, sig.VerifyPolicy(root.Suite(), publics, ExceptionThreshold(4)))
+	assert.NotNil(t, sig.VerifyPolicy(root.Suite(), publics, ExceptionThreshold(3)))
+}
+
+func TestTimeoutMidRound(t *testing.T) {
+	const TestProtocolName = "DummyBFTCoSiMidRound"
+
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	var dead *onet.TreeNode
+	// Register test protocol using BFTCoSi where the dead node dies during
+	// the verification, after it sent its commitments
+	onet.GlobalProtocolRegister(TestProtocolName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return NewBFTCoSiProtocol(n, func(m []byte, d []byte) bool {
+			if n.TreeNode().ID.Equal(dead.ID) {
+				killServer(local, n.ServerIdentity())
+			}
+			return true
+		})
+	})
+
+	_, _, tree := local.GenBigTree(7, 7, 2, true)
+	dead = tree.Root.Children[1].Children[0]
+
+	root := runTimeout(t, local, tree, TestProtocolName, 200*time.Millisecond,
+		DefaultDeadline)
+	sig := root.Signature()
+	assert.Nil(t, sig.Verify(root.Suite(), root.Roster().Publics()))
+	assert.Equal(t, 1, len(sig.Exceptions))
+	assert.Equal(t, dead.RosterIndex, sig.Exceptions[0].Index)
+	assert.Equal(t, []int{dead.RosterIndex}, root.Refusals())
+}
+
+func TestTimeoutDeadline(t *testing.T) {
+	const TestProtocolName = "DummyBFTCoSiDeadline"
+
+	// Register test protocol using BFTCoSi
+	onet.GlobalProtocolRegister(TestProtocolName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return NewBFTCoSiProtocol(n, verifyAll)
+	})
+
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, _, tree := local.GenBigTree(4, 4, 2, true)
+	killServer(local, tree.Root.Children[0].ServerIdentity)
+
+	// the timeout of the children is longer than the deadline of the root
+	root := runTimeout(t, local, tree, TestProtocolName, 10*time.Second,
+		200*time.Millisecond)
+	assert.Nil(t, root.Signature().Sig)
+	assert.NotNil(t, root.Err())
+}
+
+func TestRefusalReason(t *testing.T) {
+	const TestProtocolName = "DummyBFTCoSiReason"
+
+	var refuser *onet.TreeNode
+	// Register test protocol using BFTCoSi where one node refuses with a
+	// reason
+	onet.GlobalProtocolRegister(TestProtocolName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return NewBFTCoSiProtocolReason(n, func(m []byte, d []byte) error {
+			if n.TreeNode().ID.Equal(refuser.ID) {
+				return &Reason{Code: 1, Message: "parent block unknown"}
+			}
+			return nil
+		}, AllSign())
+	})
+
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, _, tree := local.GenBigTree(7, 7, 2, true)
+	refuser = tree.Root.Children[1].Children[0]
+
+	root := runTimeout(t, local, tree, TestProtocolName, DefaultTimeout,
+		DefaultDeadline)
+	sig := root.Signature()
+	assert.Nil(t, sig.Sig)
+	publics := root.Roster().Publics()
+	refusals := sig.Refusals(root.Suite(), publics)
+	assert.Equal(t, 1, len(refusals))
+	ref := refusals[refuser.RosterIndex]
+	if assert.NotNil(t, ref) {
+		assert.Equal(t, 1, ref.Reason.Code)
+		assert.Equal(t, "parent block unknown", ref.Reason.Message)
+	}
+	err := RefusalError(root.Suite(), root.Roster(), sig)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "refused: parent block unknown")
+	}
+
+	// a refusal changed by another node is not accepted
+	ref.Reason.Message = "something else"
+	assert.NotNil(t, ref.Verify(root.Suite(), publics[refuser.RosterIndex], sig.Msg))
+	assert.Equal(t, 0, len(sig.Refusals(root.Suite(), publics)))
+}
+
+func TestCompact(t *testing.T) {
+	const TestProtocolName = "DummyBFTCoSiCompact"
+
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	var dead *onet.TreeNode
+	// Register test protocol using BFTCoSi where the dead node dies during
+	// the verification, so that it becomes an exception
+	onet.GlobalProtocolRegister(TestProtocolName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return NewBFTCoSiProtocol(n, func(m []byte, d []byte) bool {
+			if dead != nil && n.TreeNode().ID.Equal(dead.ID) {
+				killServer(local, n.ServerIdentity())
+			}
+			return true
+		})
+	})
+
+	_, _, tree := local.GenBigTree(7, 7, 2, true)
+	publics := tree.Roster.Publics()
+
+	// without exceptions the compact signature is the cosi-signature
+	root := runTimeout(t, local, tree, TestProtocolName, 200*time.Millisecond,
+		DefaultDeadline)
+	sig := root.Signature()
+	compact, err := sig.Compact(root.Suite(), len(publics))
+	log.ErrFatal(err)
+	assert.Equal(t, sig.Sig, compact)
+	assert.Nil(t, VerifyCompact(root.Suite(), publics, sig.Msg, compact))
+	absent, err := CompactAbsent(root.Suite(), len(publics), compact)
+	log.ErrFatal(err)
+	assert.Equal(t, 0, len(absent))
+
+	dead = tree.Root.Children[1].Children[0]
+	root = runTimeout(t, local, tree, TestProtocolName, 200*time.Millisecond,
+		DefaultDeadline)
+	sig = root.Signature()
+	assert.Equal(t, 1, len(sig.Exceptions))
+	compact, err = sig.Compact(root.Suite(), len(publics))
+	log.ErrFatal(err)
+	assert.Nil(t, VerifyCompact(root.Suite(), publics, sig.Msg, compact))
+	assert.NotNil(t, VerifyCompactPolicy(root.Suite(), publics, sig.Msg,
+		compact, AllSign()))
+	assert.NotNil(t, VerifyCompact(root.Suite(), publics, []byte("wrong"), compact))
+	absent, err = CompactAbsent(root.Suite(), len(publics), compact)
+	log.ErrFatal(err)
+	assert.Equal(t, []int{dead.RosterIndex}, absent)
+
+	// the exception can't be hidden
+	compact[len(compact)-1] = 0
+	assert.NotNil(t, VerifyCompact(root.Suite(), publics, sig.Msg, compact))
+	assert.NotNil(t, VerifyCompact(root.Suite(), publics, sig.Msg, compact[1:]))
+}
+
 func runProtocol(t *testing.T, name string, refuseCount int) {
 	for _, nbrHosts := range []int{3, 4, 13} {
 		runProtocolOnce(t, nbrHosts, name, refuseCount, true)
@@ -333,3 +536,38 @@ func verifyRefuseBit(m []byte, d []byte) bool {
 	log.Lvl3("Verification called", counter.veriCount, "times")
 	return true
 }
+
+// Verify-function that accepts everything.
+func verifyAll(m []byte, d []byte) bool {
+	return true
+}
+
+// runTimeout runs the protocol with the given timeout and deadline and
+// returns the root once it's done.
+func runTimeout(t *testing.T, local *onet.LocalTest, tree *onet.Tree, name string,
+	timeout, deadline time.Duration) *ProtocolBFTCoSi {
+	node, err := local.CreateProtocol(name, tree)
+	log.ErrFatal(err)
+	root := node.(*ProtocolBFTCoSi)
+	root.Msg = []byte("Hello BFTCoSi")
+	root.Timeout = timeout
+	root.Deadline = deadline
+	done := make(chan bool, 1)
+	root.RegisterOnDone(func() {
+		done <- true
+	})
+	go node.Start()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 60):
+		t.Fatal("BFTCoSi didn't finish")
+	}
+	return root
+}
+
+// killServer closes the conode, so that it stops answering to the other
+// nodes of the tree.
+func killServer(local *onet.LocalTest, si *network.ServerIdentity) {
+	log.ErrFatal(local.Servers[si.ID].Close())
+	delete(local.Servers, si.ID)
+}
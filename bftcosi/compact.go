@@ -0,0 +1,131 @@
+package bftcosi
+
+/*
+The compact encoding of a BFTSignature follows the signature of the cosi
+specification: V || r || mask, where V is the aggregate commitment, r the
+aggregate response and mask the participation bitmask, a set bit marking a
+cosigner that didn't take part. If some cosigners refused to sign after the
+challenge has been created, the aggregate commitment X of these exceptions
+and the bitmask E of their indexes are appended: V || r || mask || X || E.
+
+So the size of the compact encoding only depends on the size of the roster,
+and a signature without exceptions is a plain cosi-signature.
+*/
+
+import (
+	"errors"
+
+	"gopkg.in/dedis/crypto.v0/abstract"
+)
+
+// Compact returns the compact encoding of the signature for a roster of n
+// cosigners.
+func (bs *BFTSignature) Compact(s abstract.Suite, n int) ([]byte, error) {
+	if bs == nil || bs.Sig == nil {
+		return nil, errors.New("Invalid signature")
+	}
+	sigLen := s.PointLen() + s.ScalarLen()
+	maskLen := (n + 7) / 8
+	if len(bs.Sig) < sigLen {
+		return nil, errors.New("Signature too short")
+	}
+	// a signature without mask has all cosigners participating
+	sig := make([]byte, sigLen+maskLen)
+	copy(sig, bs.Sig)
+	if len(bs.Exceptions) == 0 {
+		return sig, nil
+	}
+	aggExCommit := s.Point().Null()
+	exMask := make([]byte, maskLen)
+	for _, ex := range bs.Exceptions {
+		if ex.Index < 0 || ex.Index >= n {
+			return nil, errors.New("Exception for unknown cosigner")
+		}
+		if exMask[ex.Index/8]&(1<<uint(ex.Index%8)) != 0 {
+			return nil, errors.New("Double exception for cosigner")
+		}
+		exMask[ex.Index/8] |= 1 << uint(ex.Index%8)
+		aggExCommit.Add(aggExCommit, ex.Commitment)
+	}
+	buf, err := aggExCommit.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	sig = append(sig, buf...)
+	return append(sig, exMask...), nil
+}
+
+// VerifyCompact verifies the signature of msg in the compact encoding. Like
+// BFTSignature.Verify, it refuses signatures with more exceptions than
+// allowed by the DefaultPolicy.
+func VerifyCompact(s abstract.Suite, publics []abstract.Point, msg, sig []byte) error {
+	return VerifyCompactPolicy(s, publics, msg, sig, DefaultPolicy(len(publics)))
+}
+
+// VerifyCompactPolicy is like VerifyCompact, but refuses signatures whose
+// exceptions are not accepted by the given policy.
+func VerifyCompactPolicy(s abstract.Suite, publics []abstract.Point, msg, sig []byte,
+	policy ThresholdPolicy) error {
+	if msg == nil {
+		return errors.New("Invalid signature")
+	}
+	sigLen := s.PointLen() + s.ScalarLen() + (len(publics)+7)/8
+	exceptions, aggExCommit, err := compactExceptions(s, len(publics), sig)
+	if err != nil {
+		return err
+	}
+	return verifyAggregate(s, publics, msg, sig[:sigLen], exceptions,
+		aggExCommit, policy)
+}
+
+// CompactAbsent returns the indexes of the cosigners of a roster of n
+// cosigners that didn't sign, either because they are missing from the
+// participation mask or because they are exceptions.
+func CompactAbsent(s abstract.Suite, n int, sig []byte) ([]int, error) {
+	exceptions, _, err := compactExceptions(s, n, sig)
+	if err != nil {
+		return nil, err
+	}
+	mask := sig[s.PointLen()+s.ScalarLen():]
+	absent := make([]bool, n)
+	for i := 0; i < n; i++ {
+		absent[i] = mask[i/8]&(1<<uint(i%8)) != 0
+	}
+	for _, ex := range exceptions {
+		absent[ex] = true
+	}
+	var indexes []int
+	for i, a := range absent {
+		if a {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes, nil
+}
+
+// compactExceptions returns the indexes and the aggregate commitment of the
+// exceptions of a compact signature for a roster of n cosigners.
+func compactExceptions(s abstract.Suite, n int, sig []byte) ([]int, abstract.Point, error) {
+	pointLen := s.PointLen()
+	maskLen := (n + 7) / 8
+	sigLen := pointLen + s.ScalarLen() + maskLen
+	aggExCommit := s.Point().Null()
+	switch len(sig) {
+	case sigLen:
+		return nil, aggExCommit, nil
+	case sigLen + pointLen + maskLen:
+	default:
+		return nil, nil, errors.New("Wrong length of compact signature")
+	}
+	if err := aggExCommit.UnmarshalBinary(sig[sigLen : sigLen+pointLen]); err != nil {
+		return nil, nil, err
+	}
+	exMask := sig[sigLen+pointLen:]
+	var exceptions []int
+	for i := 0; i < n; i++ {
+		if exMask[i/8]&(1<<uint(i%8)) != 0 {
+			exceptions = append(exceptions, i)
+		}
+	}
+	return exceptions, aggExCommit, nil
+}
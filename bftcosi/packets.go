@@ -17,6 +17,10 @@ func init() {
 		ChallengePrepare{},
 		ChallengeCommit{},
 		Response{},
+		PipelineAnnounce{},
+		PipelineCommitment{},
+		PipelineChallenge{},
+		PipelineResponse{},
 	} {
 		network.RegisterMessage(i)
 	}
@@ -43,34 +47,136 @@ type BFTSignature struct {
 	Exceptions []Exception
 }
 
+// RosterIndexes returns the index in the roster to of every cosigner of
+// the roster from. Trees created with GenerateNaryTreeWithRoot reorder the
+// roster, so the indexes of a signature by such a tree need to be changed
+// with Remap before it can be verified with the original roster.
+func RosterIndexes(from, to *onet.Roster) ([]int, error) {
+	if len(from.List) != len(to.List) {
+		return nil, errors.New("Rosters of different size")
+	}
+	indexes := make([]int, len(from.List))
+	for i, si := range from.List {
+		j, _ := to.Search(si.ID)
+		if j < 0 {
+			return nil, errors.New("Cosigner missing from roster")
+		}
+		indexes[i] = j
+	}
+	return indexes, nil
+}
+
+// Remap returns a copy of the signature where the cosigner at index i in
+// the participation mask and the exceptions is moved to indexes[i].
+func (bs *BFTSignature) Remap(s abstract.Suite, indexes []int) *BFTSignature {
+	re := &BFTSignature{Msg: bs.Msg}
+	if bs.Sig != nil {
+		re.Sig = make([]byte, len(bs.Sig))
+		copy(re.Sig, bs.Sig)
+		sigLen := s.PointLen() + s.ScalarLen()
+		if len(bs.Sig) >= sigLen+(len(indexes)+7)/8 {
+			mask := re.Sig[sigLen : sigLen+(len(indexes)+7)/8]
+			for i := range mask {
+				mask[i] = 0
+			}
+			for i, j := range indexes {
+				if bs.Sig[sigLen+i/8]&(1<<uint(i%8)) != 0 {
+					mask[j/8] |= 1 << uint(j%8)
+				}
+			}
+		}
+	}
+	for _, ex := range bs.Exceptions {
+		// unknown cosigners are kept, so that the verification fails
+		if ex.Index >= 0 && ex.Index < len(indexes) {
+			ex.Index = indexes[ex.Index]
+		}
+		re.Exceptions = append(re.Exceptions, ex)
+	}
+	return re
+}
+
 // Verify returns whether the verification of the signature succeeds or not.
 // Specifically, it adjusts the signature according to the exception in the
 // signature, so it can be verified by dedis/crypto/cosi.
 // publics is a slice of all public signatures, and the msg is the msg
-// being signed.
+// being signed. Signatures with more exceptions than allowed by the
+// DefaultPolicy are refused.
 func (bs *BFTSignature) Verify(s abstract.Suite, publics []abstract.Point) error {
+	return bs.VerifyPolicy(s, publics, DefaultPolicy(len(publics)))
+}
+
+// VerifyPolicy is like Verify, but refuses signatures whose exceptions are
+// not accepted by the given policy. The cosigners missing from the
+// participation mask of the signature count as refusals, too.
+func (bs *BFTSignature) VerifyPolicy(s abstract.Suite, publics []abstract.Point,
+	policy ThresholdPolicy) error {
 	if bs == nil || bs.Sig == nil || bs.Msg == nil {
 		return errors.New("Invalid signature")
 	}
-	// compute the aggregate key of all the signers
+	// compute the aggregate commit of exception
+	exceptions := make([]int, len(bs.Exceptions))
+	aggExCommit := s.Point().Null()
+	for i, ex := range bs.Exceptions {
+		exceptions[i] = ex.Index
+		aggExCommit = aggExCommit.Add(aggExCommit, ex.Commitment)
+	}
+	return verifyAggregate(s, publics, bs.Msg, bs.Sig, exceptions,
+		aggExCommit, policy)
+}
+
+// verifyAggregate verifies the cosi-signature sig of msg, where the
+// cosigners with the given indexes refused to sign after the challenge has
+// been created. aggExCommit is the aggregate commitment of these exceptions.
+func verifyAggregate(s abstract.Suite, publics []abstract.Point, msg, sig []byte,
+	exceptions []int, aggExCommit abstract.Point, policy ThresholdPolicy) error {
+	pointLen := s.PointLen()
+	sigLen := pointLen + s.ScalarLen()
+	if len(sig) < sigLen {
+		return errors.New("Signature too short")
+	}
+	var mask []byte
+	if len(sig) >= sigLen+(len(publics)+7)/8 {
+		mask = sig[sigLen:]
+	}
+	masked := func(i int) bool {
+		return mask != nil && mask[i/8]&(1<<uint(i%8)) != 0
+	}
+	var refused []int
+	for i := range publics {
+		if masked(i) {
+			refused = append(refused, i)
+		}
+	}
+	seen := make([]bool, len(publics))
+	for _, ex := range exceptions {
+		if ex < 0 || ex >= len(publics) {
+			return errors.New("Exception for unknown cosigner")
+		}
+		if seen[ex] || masked(ex) {
+			return errors.New("Exception for absent cosigner")
+		}
+		seen[ex] = true
+		refused = append(refused, ex)
+	}
+	if err := policy.Check(len(publics), refused); err != nil {
+		return err
+	}
+	// compute the aggregate key of all the signers in the mask
 	aggPublic := s.Point().Null()
 	for i := range publics {
-		aggPublic.Add(aggPublic, publics[i])
+		if !masked(i) {
+			aggPublic.Add(aggPublic, publics[i])
+		}
 	}
 	// compute the reduced public aggregate key (all - exception)
 	aggReducedPublic := s.Point().Null().Add(s.Point().Null(), aggPublic)
-
-	// compute the aggregate commit of exception
-	aggExCommit := s.Point().Null()
-	for _, ex := range bs.Exceptions {
-		aggExCommit = aggExCommit.Add(aggExCommit, ex.Commitment)
-		aggReducedPublic.Sub(aggReducedPublic, publics[ex.Index])
+	for _, ex := range exceptions {
+		aggReducedPublic.Sub(aggReducedPublic, publics[ex])
 	}
 	// get back the commit to recreate  the challenge
 	origCommit := s.Point()
-	pointLen := s.PointLen()
-	sigLen := pointLen + s.ScalarLen()
-	if err := origCommit.UnmarshalBinary(bs.Sig[0:pointLen]); err != nil {
+	if err := origCommit.UnmarshalBinary(sig[0:pointLen]); err != nil {
 		return err
 	}
 
@@ -82,7 +188,7 @@ func (bs *BFTSignature) Verify(s abstract.Suite, publics []abstract.Point) error
 	if _, err := aggPublic.MarshalTo(h); err != nil {
 		return err
 	}
-	if _, err := h.Write(bs.Msg); err != nil {
+	if _, err := h.Write(msg); err != nil {
 		return err
 	}
 
@@ -91,7 +197,7 @@ func (bs *BFTSignature) Verify(s abstract.Suite, publics []abstract.Point) error
 	k := s.Scalar().SetBytes(h.Sum(nil))
 	minusPublic := s.Point().Neg(aggReducedPublic)
 	ka := s.Point().Mul(minusPublic, k)
-	r := s.Scalar().SetBytes(bs.Sig[pointLen:sigLen])
+	r := s.Scalar().SetBytes(sig[pointLen:sigLen])
 	rb := s.Point().Mul(nil, r)
 	left := s.Point().Add(rb, ka)
 
@@ -106,7 +212,9 @@ func (bs *BFTSignature) Verify(s abstract.Suite, publics []abstract.Point) error
 // Announce is the struct used during the announcement phase (of both
 // rounds)
 type Announce struct {
-	TYPE    RoundType
+	TYPE RoundType
+	// Timeout in milliseconds the nodes just above the leaves wait for
+	// their children.
 	Timeout uint64
 }
 
@@ -121,6 +229,9 @@ type announceChan struct {
 type Commitment struct {
 	TYPE       RoundType
 	Commitment abstract.Point
+	// Missing holds the indexes of the cosigners of the subtree that didn't
+	// send their commitment in time.
+	Missing []int
 }
 
 // commitChan is the type of the channel that will be used to catch commitment
@@ -190,4 +301,76 @@ type responseChan struct {
 type Exception struct {
 	Index      int
 	Commitment abstract.Point
+	// Refusal is the signed reason of the cosigner, nil if it didn't
+	// answer in time
+	Refusal *Refusal
+}
+
+// PipelineAnnounce starts a step of the pipelined BFTCoSi.
+type PipelineAnnounce struct {
+	Step int
+	// Prepare is true if a new message is signed in the prepare round
+	Prepare bool
+	// Commit is true if the message of the previous step is signed in the
+	// commit round
+	Commit bool
+	// Stop is true if the pipeline is stopped
+	Stop bool
+}
+
+// pipelineAnnounceChan is the type of the channel used to catch the
+// announcements of the pipeline.
+type pipelineAnnounceChan struct {
+	*onet.TreeNode
+	PipelineAnnounce
+}
+
+// PipelineCommitment holds the commitments of both rounds of a step.
+type PipelineCommitment struct {
+	Step    int
+	Prepare abstract.Point
+	Commit  abstract.Point
+}
+
+// pipelineCommitChan is the type of the channel used to catch the
+// commitments of the pipeline.
+type pipelineCommitChan struct {
+	*onet.TreeNode
+	PipelineCommitment
+}
+
+// PipelineChallenge holds the challenges of both rounds of a step. For the
+// prepare round it holds the new message, for the commit round the
+// signature of the prepare round of the previous message.
+type PipelineChallenge struct {
+	Step      int
+	Msg       []byte
+	Data      []byte
+	Prepare   abstract.Scalar
+	Commit    abstract.Scalar
+	Signature *BFTSignature
+}
+
+// pipelineChallengeChan is the type of the channel used to catch the
+// challenges of the pipeline.
+type pipelineChallengeChan struct {
+	*onet.TreeNode
+	PipelineChallenge
+}
+
+// PipelineResponse holds the responses and the exceptions of both rounds
+// of a step.
+type PipelineResponse struct {
+	Step              int
+	Prepare           abstract.Scalar
+	PrepareExceptions []Exception
+	Commit            abstract.Scalar
+	CommitExceptions  []Exception
+}
+
+// pipelineResponseChan is the type of the channel used to catch the
+// responses of the pipeline.
+type pipelineResponseChan struct {
+	*onet.TreeNode
+	PipelineResponse
 }
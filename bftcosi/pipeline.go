@@ -0,0 +1,480 @@
+package bftcosi
+
+/*
+The pipelined BFTCoSi signs a stream of messages with one long-lived
+protocol-instance, instead of creating a new tree and protocol-instance for
+every message. Every step of the pipeline goes once down and up the tree and
+runs the prepare round of a new message together with the commit round of the
+message of the previous step, so that one signature is finished per step.
+
+The pipeline has no timeouts, so all nodes need to stay online.
+*/
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"errors"
+	"sync"
+
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/cosi"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// pipelineQueue is the number of messages that can wait at the root before
+// Sign blocks.
+const pipelineQueue = 100
+
+// pipelineMessage is a message to be signed, together with the data for
+// the verification.
+type pipelineMessage struct {
+	msg  []byte
+	data []byte
+}
+
+// ProtocolPipeline is the pipelined version of ProtocolBFTCoSi.
+type ProtocolPipeline struct {
+	// the node we are represented-in
+	*onet.TreeNodeInstance
+	// VerificationFunction is called for every new message during the
+	// challenge of the prepare round.
+	VerificationFunction VerificationFunction
+	// ReasonFunction is called instead of the VerificationFunction if it
+	// is set. The reason of a refusal is signed and passed up to the root.
+	ReasonFunction ReasonFunction
+	// Policy decides how many exceptions are accepted. It must be the
+	// same for all nodes.
+	Policy ThresholdPolicy
+	// our index in the Roster list
+	index int
+
+	// queue holds the messages waiting to be signed, only used by the root
+	queue      chan pipelineMessage
+	queueMutex sync.Mutex
+	stopped    bool
+	// sending counts the calls to Sign waiting for room in the queue
+	sending     sync.WaitGroup
+	onSignature func(*BFTSignature)
+
+	// the current step of the pipeline
+	step int
+	// cosi of the prepare round of the current message
+	prepare *cosi.CoSi
+	// cosi of the commit round of the previous message
+	commit *cosi.CoSi
+	// current is the message in the prepare round
+	current *pipelineMessage
+	// previous is the message in the commit round
+	previous *pipelineMessage
+	// prepareSignature is the signature of the prepare round of the
+	// previous message, only used by the root
+	prepareSignature *BFTSignature
+	// signRefusal is true if we refuse to sign the previous message in
+	// the commit round
+	signRefusal bool
+	// commitRefusal is our signed refusal of the previous message
+	commitRefusal *Refusal
+	// verifyChan gets the reason of a refusal of the current message, or
+	// nil if it is accepted
+	verifyChan chan error
+
+	announceChan  chan pipelineAnnounceChan
+	commitChan    chan []pipelineCommitChan
+	challengeChan chan pipelineChallengeChan
+	responseChan  chan []pipelineResponseChan
+}
+
+// NewBFTCoSiPipeline returns a new pipeline using the DefaultPolicy.
+func NewBFTCoSiPipeline(n *onet.TreeNodeInstance, verify VerificationFunction) (*ProtocolPipeline, error) {
+	return NewBFTCoSiPipelinePolicy(n, verify,
+		DefaultPolicy(len(n.Tree().List())))
+}
+
+// NewBFTCoSiPipelinePolicy returns a new pipeline that accepts the
+// exceptions allowed by the given policy.
+func NewBFTCoSiPipelinePolicy(n *onet.TreeNodeInstance, verify VerificationFunction,
+	policy ThresholdPolicy) (*ProtocolPipeline, error) {
+	p := &ProtocolPipeline{
+		TreeNodeInstance:     n,
+		VerificationFunction: verify,
+		Policy:               policy,
+		queue:                make(chan pipelineMessage, pipelineQueue),
+		verifyChan:           make(chan error, 1),
+	}
+	p.index, _ = n.Roster().Search(n.ServerIdentity().ID)
+	err := p.RegisterChannels(&p.announceChan, &p.commitChan,
+		&p.challengeChan, &p.responseChan)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Start does nothing, as the root starts a step of the pipeline for every
+// message given to Sign.
+func (p *ProtocolPipeline) Start() error {
+	return nil
+}
+
+// Sign queues the message to be signed by the pipeline. The data is passed
+// to the VerificationFunction. The signatures are given to the function
+// registered with RegisterOnSignature in the order the messages have been
+// queued. Only to be called on the root.
+func (p *ProtocolPipeline) Sign(msg, data []byte) error {
+	p.queueMutex.Lock()
+	if p.stopped {
+		p.queueMutex.Unlock()
+		return errors.New("Pipeline is stopped")
+	}
+	// don't block Stop while waiting for room in the queue
+	p.sending.Add(1)
+	p.queueMutex.Unlock()
+	p.queue <- pipelineMessage{msg, data}
+	p.sending.Done()
+	return nil
+}
+
+// Stop finishes the signatures of the queued messages and then stops the
+// pipeline on all nodes.
+func (p *ProtocolPipeline) Stop() {
+	p.queueMutex.Lock()
+	if p.stopped {
+		p.queueMutex.Unlock()
+		return
+	}
+	p.stopped = true
+	p.queueMutex.Unlock()
+	// the messages of the calls to Sign that are still waiting are
+	// signed, too
+	p.sending.Wait()
+	close(p.queue)
+}
+
+// RegisterOnSignature registers the function that is called with the
+// signature of every message. If the prepare round failed, the signature
+// is nil and the Exceptions hold the cosigners that refused to sign.
+func (p *ProtocolPipeline) RegisterOnSignature(fn func(*BFTSignature)) {
+	p.onSignature = fn
+}
+
+// Dispatch runs the steps of the pipeline till it is stopped.
+func (p *ProtocolPipeline) Dispatch() error {
+	defer p.Done()
+	for {
+		ann, ok := p.nextAnnouncement()
+		if !ok {
+			return nil
+		}
+		if !p.IsLeaf() {
+			if err := p.SendToChildrenInParallel(&ann); err != nil {
+				return err
+			}
+		}
+		if ann.Stop {
+			log.Lvl3(p.Name(), "Stopping pipeline")
+			return nil
+		}
+		if err := p.runStep(&ann); err != nil {
+			log.Error(p.Name(), "Step", ann.Step, "failed:", err)
+			return err
+		}
+	}
+}
+
+// nextAnnouncement returns the announcement of the next step. The root
+// starts a step if there is a new message or if the previous message still
+// needs its commit round.
+func (p *ProtocolPipeline) nextAnnouncement() (PipelineAnnounce, bool) {
+	if !p.IsRoot() {
+		msg, ok := <-p.announceChan
+		return msg.PipelineAnnounce, ok
+	}
+	ann := PipelineAnnounce{
+		Step:   p.step + 1,
+		Commit: p.previous != nil,
+	}
+	var m pipelineMessage
+	var ok bool
+	if ann.Commit {
+		// don't wait for a new message if the previous one needs to
+		// be finished
+		select {
+		case m, ok = <-p.queue:
+		default:
+		}
+	} else {
+		m, ok = <-p.queue
+	}
+	if ok {
+		ann.Prepare = true
+		p.current = &m
+	}
+	ann.Stop = !ann.Prepare && !ann.Commit
+	return ann, true
+}
+
+// runStep runs the commitment, challenge and response of one step.
+func (p *ProtocolPipeline) runStep(ann *PipelineAnnounce) error {
+	p.step = ann.Step
+	p.prepare = cosi.NewCosi(p.Suite(), p.Private(), p.Roster().Publics())
+	p.commit = cosi.NewCosi(p.Suite(), p.Private(), p.Roster().Publics())
+
+	// Commitment
+	var prepares, commits []abstract.Point
+	if !p.IsLeaf() {
+		msgs, ok := <-p.commitChan
+		if !ok {
+			return errors.New("Closing")
+		}
+		for _, msg := range msgs {
+			prepares = append(prepares, msg.Prepare)
+			commits = append(commits, msg.Commit)
+		}
+	}
+	comm := &PipelineCommitment{
+		Step:    p.step,
+		Prepare: p.prepare.Commit(nil, prepares),
+		Commit:  p.commit.Commit(nil, commits),
+	}
+
+	// Challenge
+	var ch *PipelineChallenge
+	if p.IsRoot() {
+		var err error
+		if ch, err = p.createChallenge(ann); err != nil {
+			return err
+		}
+	} else {
+		if err := p.SendToParent(comm); err != nil {
+			return err
+		}
+		msg, ok := <-p.challengeChan
+		if !ok {
+			return errors.New("Closing")
+		}
+		ch = &msg.PipelineChallenge
+		if ch.Step != p.step {
+			return errors.New("Got challenge for wrong step")
+		}
+	}
+	if err := p.handleChallenge(ann, ch); err != nil {
+		return err
+	}
+
+	// Response
+	return p.handleResponses(ann)
+}
+
+// createChallenge creates the challenges of both rounds on the root.
+func (p *ProtocolPipeline) createChallenge(ann *PipelineAnnounce) (*PipelineChallenge, error) {
+	ch := &PipelineChallenge{
+		Step:      p.step,
+		Prepare:   p.Suite().Scalar().Zero(),
+		Commit:    p.Suite().Scalar().Zero(),
+		Signature: &BFTSignature{},
+	}
+	var err error
+	if ann.Prepare {
+		// need to hash the message before so challenge in both phases
+		// are not the same
+		data := sha512.Sum512(p.current.msg)
+		if ch.Prepare, err = p.prepare.CreateChallenge(data[:]); err != nil {
+			return nil, err
+		}
+		ch.Msg = p.current.msg
+		ch.Data = p.current.data
+	}
+	if ann.Commit {
+		if ch.Commit, err = p.commit.CreateChallenge(p.previous.msg); err != nil {
+			return nil, err
+		}
+		ch.Signature = p.prepareSignature
+	}
+	return ch, nil
+}
+
+// handleChallenge starts the verification of the new message, checks the
+// signature of the prepare round of the previous message and passes the
+// challenge to the children.
+func (p *ProtocolPipeline) handleChallenge(ann *PipelineAnnounce, ch *PipelineChallenge) error {
+	if !p.IsRoot() {
+		p.prepare.Challenge(ch.Prepare)
+		p.commit.Challenge(ch.Commit)
+		if ann.Prepare {
+			p.current = &pipelineMessage{ch.Msg, ch.Data}
+		}
+	}
+	if ann.Prepare {
+		go func(m *pipelineMessage) {
+			p.verifyChan <- verifyMessage(p.VerificationFunction,
+				p.ReasonFunction, m.msg, m.data)
+		}(p.current)
+	}
+	p.signRefusal = false
+	p.commitRefusal = nil
+	if ann.Commit {
+		var reason error
+		if p.previous == nil || !bytes.Equal(p.previous.msg, ch.Signature.Msg) {
+			log.Lvl2(p.Name(), "Commit round for unknown message")
+			reason = errors.New("Unknown message in commit round")
+		} else {
+			data := sha512.Sum512(ch.Signature.Msg)
+			prepareSig := &BFTSignature{
+				Sig:        ch.Signature.Sig,
+				Msg:        data[:],
+				Exceptions: ch.Signature.Exceptions,
+			}
+			if err := prepareSig.VerifyPolicy(p.Suite(), p.Roster().Publics(),
+				p.Policy); err != nil {
+				log.Lvl3(p.Name(), "Verification of the signature failed:", err)
+				reason = errors.New("Invalid prepare signature: " + err.Error())
+			}
+		}
+		if reason != nil {
+			p.signRefusal = true
+			p.commitRefusal = newRefusal(p.Suite(), p.Private(),
+				ch.Signature.Msg, reason)
+		}
+	}
+	if p.IsLeaf() {
+		return nil
+	}
+	return p.SendToChildrenInParallel(ch)
+}
+
+// handleResponses collects the responses of the children, adds our own
+// and passes them to the parent. The root finishes the signatures.
+func (p *ProtocolPipeline) handleResponses(ann *PipelineAnnounce) error {
+	resp := &PipelineResponse{Step: p.step}
+	var prepares, commits []abstract.Scalar
+	if !p.IsLeaf() {
+		msgs, ok := <-p.responseChan
+		if !ok {
+			return errors.New("Closing")
+		}
+		for _, msg := range msgs {
+			prepares = append(prepares, msg.Prepare)
+			commits = append(commits, msg.Commit)
+			resp.PrepareExceptions = append(resp.PrepareExceptions,
+				msg.PrepareExceptions...)
+			resp.CommitExceptions = append(resp.CommitExceptions,
+				msg.CommitExceptions...)
+		}
+	}
+	var err error
+	if resp.Prepare, err = p.prepare.Response(prepares); err != nil {
+		return err
+	}
+	if resp.Commit, err = p.commit.Response(commits); err != nil {
+		return err
+	}
+	if ann.Prepare {
+		if reason := <-p.verifyChan; reason != nil {
+			log.Lvl2(p.Name(), "Refused to sign:", reason)
+			resp.PrepareExceptions = append(resp.PrepareExceptions, Exception{
+				Index:      p.index,
+				Commitment: p.prepare.GetCommitment(),
+				Refusal: newRefusal(p.Suite(), p.Private(), p.current.msg,
+					reason),
+			})
+			// Don't include our response!
+			resp.Prepare = p.Suite().Scalar().Sub(resp.Prepare, p.prepare.GetResponse())
+		}
+	}
+	if ann.Commit && p.signRefusal {
+		resp.CommitExceptions = append(resp.CommitExceptions, Exception{
+			Index:      p.index,
+			Commitment: p.commit.GetCommitment(),
+			Refusal:    p.commitRefusal,
+		})
+		resp.Commit = p.Suite().Scalar().Sub(resp.Commit, p.commit.GetResponse())
+	}
+
+	if p.IsRoot() {
+		err = p.finishStep(ann, resp)
+	} else {
+		err = p.SendToParent(resp)
+	}
+	// the message of the prepare round goes to the commit round
+	p.previous = p.current
+	p.current = nil
+	return err
+}
+
+// finishStep returns the final signature of the previous message and keeps
+// the signature of the prepare round of the current message.
+func (p *ProtocolPipeline) finishStep(ann *PipelineAnnounce, resp *PipelineResponse) error {
+	if ann.Commit {
+		final := &BFTSignature{
+			Msg:        p.previous.msg,
+			Exceptions: resp.CommitExceptions,
+		}
+		if !p.signRefusal {
+			sig, err := responseSignature(p.Suite(), p.commit, resp.Commit)
+			if err != nil {
+				return err
+			}
+			final.Sig = sig
+			// make sure the cosigners that didn't answer don't
+			// break the policy
+			if err := final.VerifyPolicy(p.Suite(), p.Roster().Publics(),
+				p.Policy); err != nil {
+				log.Lvl2(p.Name(), "Final signature refused:", err)
+				final.Sig = nil
+			}
+		}
+		p.signatureDone(final)
+	}
+	if !ann.Prepare {
+		return nil
+	}
+	sig, err := responseSignature(p.Suite(), p.prepare, resp.Prepare)
+	if err != nil {
+		return err
+	}
+	data := sha512.Sum512(p.current.msg)
+	prepareSig := &BFTSignature{
+		Sig:        sig,
+		Msg:        data[:],
+		Exceptions: resp.PrepareExceptions,
+	}
+	if err := prepareSig.VerifyPolicy(p.Suite(), p.Roster().Publics(),
+		p.Policy); err != nil {
+		log.Lvl2(p.Name(), "Prepare round failed:", err)
+		// no commit round for this message
+		p.signatureDone(&BFTSignature{
+			Msg:        p.current.msg,
+			Exceptions: resp.PrepareExceptions,
+		})
+		p.current = nil
+		return nil
+	}
+	p.prepareSignature = &BFTSignature{
+		Sig:        sig,
+		Msg:        p.current.msg,
+		Exceptions: resp.PrepareExceptions,
+	}
+	return nil
+}
+
+// signatureDone passes the signature to the registered function.
+func (p *ProtocolPipeline) signatureDone(sig *BFTSignature) {
+	if p.onSignature != nil {
+		p.onSignature(sig)
+	}
+}
+
+// responseSignature returns the cosi-signature where the aggregate
+// response is replaced by the given one, which doesn't include the
+// responses of the exceptions.
+func responseSignature(s abstract.Suite, c *cosi.CoSi, response abstract.Scalar) ([]byte, error) {
+	sig := c.Signature()
+	buf, err := response.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	pointLen := s.PointLen()
+	copy(sig[pointLen:pointLen+s.ScalarLen()], buf)
+	return sig, nil
+}
@@ -0,0 +1,65 @@
+package bftcosi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+func TestPipeline(t *testing.T) {
+	const TestProtocolName = "DummyBFTCoSiPipeline"
+
+	// Register test protocol using the pipeline
+	onet.GlobalProtocolRegister(TestProtocolName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return NewBFTCoSiPipeline(n, verifyPipeline)
+	})
+
+	for _, nbrHosts := range []int{3, 4, 13} {
+		log.Lvl2("Running pipeline with", nbrHosts, "hosts")
+		local := onet.NewLocalTest()
+		_, _, tree := local.GenBigTree(nbrHosts, nbrHosts, 2, true)
+		node, err := local.CreateProtocol(TestProtocolName, tree)
+		log.ErrFatal(err)
+		root := node.(*ProtocolPipeline)
+		sigs := make(chan *BFTSignature, 10)
+		root.RegisterOnSignature(func(sig *BFTSignature) {
+			sigs <- sig
+		})
+		log.ErrFatal(root.Start())
+
+		// the third message is refused by all nodes
+		msgs := []string{"one", "two", "three", "four", "five"}
+		for i, m := range msgs {
+			data := []byte("accept")
+			if i == 2 {
+				data = []byte("refuse")
+			}
+			log.ErrFatal(root.Sign([]byte(m), data))
+		}
+		for i, m := range msgs {
+			select {
+			case sig := <-sigs:
+				assert.Equal(t, m, string(sig.Msg))
+				if i == 2 {
+					assert.Nil(t, sig.Sig)
+					assert.Equal(t, nbrHosts, len(sig.Exceptions))
+				} else {
+					assert.Nil(t, sig.Verify(root.Suite(), root.Roster().Publics()))
+				}
+			case <-time.After(time.Second * 60):
+				t.Fatal("Didn't get signature for", m)
+			}
+		}
+		root.Stop()
+		assert.NotNil(t, root.Sign([]byte("six"), nil))
+		local.CloseAll()
+	}
+}
+
+// Verify-function that refuses if the data is "refuse".
+func verifyPipeline(m []byte, d []byte) bool {
+	return string(d) != "refuse"
+}
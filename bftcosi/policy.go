@@ -0,0 +1,79 @@
+package bftcosi
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ThresholdPolicy decides whether enough cosigners took part in a signature.
+// All nodes of a protocol-instance must use the same policy.
+type ThresholdPolicy interface {
+	// Check returns nil if a signature of n cosigners, where the cosigners
+	// with the indexes in refused didn't sign, is acceptable.
+	Check(n int, refused []int) error
+}
+
+// DefaultPolicy returns the policy used if no other policy is given: less
+// than (n+1)*2/3 of the n cosigners may refuse to sign.
+func DefaultPolicy(n int) ThresholdPolicy {
+	return ExceptionThreshold((n + 1) * 2 / 3)
+}
+
+// TwoThirds returns a policy where at least 2/3 of the n cosigners must
+// sign. It is stricter than the DefaultPolicy, which accepts up to about
+// 2/3 of exceptions.
+func TwoThirds(n int) ThresholdPolicy {
+	return ExceptionThreshold(n - (2*n+2)/3 + 1)
+}
+
+// AllSign returns a policy that only accepts signatures where all cosigners
+// signed.
+func AllSign() ThresholdPolicy {
+	return ExceptionThreshold(1)
+}
+
+// ExceptionThreshold is a policy that refuses signatures with at least this
+// number of exceptions.
+type ExceptionThreshold int
+
+// Check returns an error if too many cosigners refused to sign.
+func (et ExceptionThreshold) Check(n int, refused []int) error {
+	if len(refused) >= int(et) {
+		return fmt.Errorf("%d out of %d cosigners refused to sign", len(refused), n)
+	}
+	return nil
+}
+
+// WeightedThreshold is a policy where every cosigner has a weight, and the
+// sum of the weights of the cosigners that signed must be at least Min.
+type WeightedThreshold struct {
+	// Weights holds the weight of every cosigner, in the order of the
+	// roster.
+	Weights []int
+	// Min is the minimal sum of the weights of the signers.
+	Min int
+}
+
+// Check returns an error if the signers don't have enough weight.
+func (wt *WeightedThreshold) Check(n int, refused []int) error {
+	if len(wt.Weights) != n {
+		return errors.New("need one weight per cosigner")
+	}
+	missing := make([]bool, n)
+	for _, i := range refused {
+		if i < 0 || i >= n {
+			return errors.New("exception for unknown cosigner")
+		}
+		missing[i] = true
+	}
+	weight := 0
+	for i, w := range wt.Weights {
+		if !missing[i] {
+			weight += w
+		}
+	}
+	if weight < wt.Min {
+		return fmt.Errorf("signers have a weight of %d, but need %d", weight, wt.Min)
+	}
+	return nil
+}
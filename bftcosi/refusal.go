@@ -0,0 +1,123 @@
+package bftcosi
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/crypto"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// ReasonFunction can be used instead of a VerificationFunction. It returns
+// nil if the message is accepted, or the reason why it is refused. If the
+// returned error is a *Reason, its code is passed up the tree, too.
+type ReasonFunction func(Msg []byte, Data []byte) error
+
+// Reason is a short explanation why a cosigner refuses to sign. The codes
+// are defined by the service using bftcosi, 0 being an unspecified reason.
+type Reason struct {
+	Code    int
+	Message string
+}
+
+// Error returns the message of the reason.
+func (r *Reason) Error() string {
+	return r.Message
+}
+
+// Refusal is the reason a cosigner gives for refusing to sign. It is signed
+// by the cosigner, so that the nodes above it in the tree can't change it.
+type Refusal struct {
+	Reason    Reason
+	Signature crypto.SchnorrSig
+}
+
+// newRefusal returns the refusal for msg with the reason given in err,
+// signed with the private key. It returns nil if the signature fails.
+func newRefusal(s abstract.Suite, private abstract.Scalar, msg []byte, err error) *Refusal {
+	ref := &Refusal{Reason: Reason{Message: err.Error()}}
+	if reason, ok := err.(*Reason); ok {
+		ref.Reason = *reason
+	}
+	sig, err := crypto.SignSchnorr(s, private, ref.hash(msg))
+	if err != nil {
+		log.Error("Couldn't sign refusal:", err)
+		return nil
+	}
+	ref.Signature = sig
+	return ref
+}
+
+// Verify returns an error if the refusal of msg hasn't been signed by the
+// cosigner with the given public key.
+func (ref *Refusal) Verify(s abstract.Suite, public abstract.Point, msg []byte) error {
+	return crypto.VerifySchnorr(s, public, ref.hash(msg), ref.Signature)
+}
+
+// String returns the code and the message of the refusal.
+func (ref *Refusal) String() string {
+	return fmt.Sprintf("%d: %s", ref.Reason.Code, ref.Reason.Message)
+}
+
+// hash returns the hash of the refusal of msg that is signed.
+func (ref *Refusal) hash(msg []byte) []byte {
+	h := sha256.New()
+	h.Write(msg)
+	binary.Write(h, binary.LittleEndian, int64(ref.Reason.Code))
+	h.Write([]byte(ref.Reason.Message))
+	return h.Sum(nil)
+}
+
+// Refusals returns the correctly signed refusals of the exceptions of the
+// signature, indexed by the position of the cosigners in publics.
+// Exceptions without a refusal, e.g. because the cosigner didn't answer in
+// time, or with a wrong signature are left out.
+func (bs *BFTSignature) Refusals(s abstract.Suite, publics []abstract.Point) map[int]*Refusal {
+	refusals := map[int]*Refusal{}
+	for _, ex := range bs.Exceptions {
+		if ex.Refusal == nil || ex.Index < 0 || ex.Index >= len(publics) {
+			continue
+		}
+		if err := ex.Refusal.Verify(s, publics[ex.Index], bs.Msg); err != nil {
+			log.Lvl2("Wrong signature on refusal of", ex.Index, ":", err)
+			continue
+		}
+		refusals[ex.Index] = ex.Refusal
+	}
+	return refusals
+}
+
+// RefusalError returns an error listing the conodes of the roster that
+// refused to sign together with their reason, or nil if no conode gave a
+// correctly signed reason.
+func RefusalError(s abstract.Suite, roster *onet.Roster, bs *BFTSignature) error {
+	refusals := bs.Refusals(s, roster.Publics())
+	if len(refusals) == 0 {
+		return nil
+	}
+	var reasons []string
+	for i, si := range roster.List {
+		if ref, ok := refusals[i]; ok {
+			reasons = append(reasons, fmt.Sprintf("conode %s refused: %s",
+				si.Address, ref.Reason.Message))
+		}
+	}
+	return errors.New(strings.Join(reasons, ", "))
+}
+
+// verifyMessage calls the ReasonFunction if it is given, else the
+// VerificationFunction, and returns why the message has been refused.
+func verifyMessage(vf VerificationFunction, rf ReasonFunction, msg, data []byte) error {
+	if rf != nil {
+		return rf(msg, data)
+	}
+	if vf == nil || !vf(msg, data) {
+		return errors.New("Verification failed")
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+package main
+
+import (
+	"gopkg.in/dedis/onet.v1/simul"
+)
+
+func main() {
+	simul.Start()
+}
@@ -0,0 +1,151 @@
+package main
+
+/*
+The simulation signs Rounds messages either with one ProtocolBFTCoSi per
+message, or with one ProtocolPipeline for all messages. The "messages"-
+measure holds the time needed to sign all messages, so that the throughput
+of both versions can be compared.
+*/
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+	"github.com/dedis/cothority/bftcosi"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+	"gopkg.in/dedis/onet.v1/simul/monitor"
+)
+
+// ProtocolOneShot is the name of the one-shot protocol in the simulation.
+const ProtocolOneShot = "BFTCoSiSimulOneShot"
+
+// ProtocolPipeline is the name of the pipelined protocol in the simulation.
+const ProtocolPipeline = "BFTCoSiSimulPipeline"
+
+func init() {
+	onet.SimulationRegister("BFTCoSi", NewSimulation)
+	onet.GlobalProtocolRegister(ProtocolOneShot, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return bftcosi.NewBFTCoSiProtocol(n, verify)
+	})
+	onet.GlobalProtocolRegister(ProtocolPipeline, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return bftcosi.NewBFTCoSiPipeline(n, verify)
+	})
+}
+
+// Simulation implements the onet.Simulation of BFTCoSi.
+type Simulation struct {
+	onet.SimulationBFTree
+	// Pipeline uses the pipelined protocol if true
+	Pipeline bool
+}
+
+// NewSimulation returns an onet.Simulation or an error if sth. is wrong.
+func NewSimulation(config string) (onet.Simulation, error) {
+	bs := &Simulation{}
+	_, err := toml.Decode(config, bs)
+	if err != nil {
+		return nil, err
+	}
+	return bs, nil
+}
+
+// Setup implements onet.Simulation.
+func (bs *Simulation) Setup(dir string, hosts []string) (*onet.SimulationConfig, error) {
+	sim := new(onet.SimulationConfig)
+	bs.CreateRoster(sim, hosts, 2000)
+	err := bs.CreateTree(sim)
+	return sim, err
+}
+
+// Run implements onet.Simulation.
+func (bs *Simulation) Run(config *onet.SimulationConfig) error {
+	size := config.Tree.Size()
+	log.Lvl1("Size:", size, "rounds:", bs.Rounds, "pipeline:", bs.Pipeline)
+	messages := monitor.NewTimeMeasure("messages")
+	var err error
+	if bs.Pipeline {
+		err = bs.runPipeline(config)
+	} else {
+		err = bs.runOneShot(config)
+	}
+	if err != nil {
+		return err
+	}
+	messages.Record()
+	log.Lvl1("Simulation finished")
+	return nil
+}
+
+// runOneShot signs every message with a new protocol-instance.
+func (bs *Simulation) runOneShot(config *onet.SimulationConfig) error {
+	for round := 0; round < bs.Rounds; round++ {
+		log.Lvl2("Starting round", round)
+		roundM := monitor.NewTimeMeasure("round")
+		node, err := config.Overlay.CreateProtocol(ProtocolOneShot, config.Tree,
+			onet.NilServiceID)
+		if err != nil {
+			return err
+		}
+		root := node.(*bftcosi.ProtocolBFTCoSi)
+		root.Msg = message(round)
+		done := make(chan bool, 1)
+		root.RegisterOnDone(func() {
+			done <- true
+		})
+		if err := node.Start(); err != nil {
+			return err
+		}
+		<-done
+		roundM.Record()
+		if err := root.Signature().Verify(network.Suite, config.Roster.Publics()); err != nil {
+			return fmt.Errorf("Round %d failed: %s", round, err)
+		}
+	}
+	return nil
+}
+
+// runPipeline signs all messages with the same protocol-instance.
+func (bs *Simulation) runPipeline(config *onet.SimulationConfig) error {
+	node, err := config.Overlay.CreateProtocol(ProtocolPipeline, config.Tree,
+		onet.NilServiceID)
+	if err != nil {
+		return err
+	}
+	root := node.(*bftcosi.ProtocolPipeline)
+	sigs := make(chan *bftcosi.BFTSignature, bs.Rounds)
+	root.RegisterOnSignature(func(sig *bftcosi.BFTSignature) {
+		sigs <- sig
+	})
+	if err := root.Start(); err != nil {
+		return err
+	}
+	defer root.Stop()
+	for round := 0; round < bs.Rounds; round++ {
+		if err := root.Sign(message(round), nil); err != nil {
+			return err
+		}
+	}
+	for round := 0; round < bs.Rounds; round++ {
+		sig := <-sigs
+		if sig.Sig == nil {
+			return errors.New("Pipeline didn't sign " + string(sig.Msg))
+		}
+		if err := sig.Verify(network.Suite, config.Roster.Publics()); err != nil {
+			return fmt.Errorf("Round %d failed: %s", round, err)
+		}
+	}
+	return nil
+}
+
+// message returns the message to sign in the given round.
+func message(round int) []byte {
+	return []byte(fmt.Sprintf("BFTCoSi simulation round %d", round))
+}
+
+// verify accepts all messages.
+func verify(m []byte, d []byte) bool {
+	return true
+}
@@ -14,6 +14,8 @@ package main
 
 import (
 	"os"
+	"os/signal"
+	"syscall"
 
 	"gopkg.in/dedis/onet.v1/log"
 	"gopkg.in/urfave/cli.v1"
@@ -22,8 +24,9 @@ import (
 	_ "github.com/dedis/cothority/cosi/service"
 	_ "github.com/dedis/cothority/guard/service"
 	_ "github.com/dedis/cothority/identity"
-	_ "github.com/dedis/cothority/skipchain"
+	"github.com/dedis/cothority/skipchain"
 	_ "github.com/dedis/cothority/status/service"
+	_ "github.com/dedis/cothority/timestamp/service"
 	"gopkg.in/dedis/onet.v1/app"
 )
 
@@ -53,6 +56,10 @@ func main() {
 			Value: 0,
 			Usage: "debug-level: 1 for terse, 5 for maximal",
 		},
+		cli.StringFlag{
+			Name:  "http",
+			Usage: "address of the read-only HTTP-gateway of the skipchains, e.g. localhost:7003",
+		},
 	}
 
 	cliApp.Commands = []cli.Command{
@@ -115,8 +122,21 @@ func main() {
 func runServer(ctx *cli.Context) {
 	// first check the options
 	config := ctx.String("config")
+	if address := ctx.String("http"); address != "" {
+		skipchain.EnableHTTP(address)
+	}
 
+	// close the databases of the services before exiting
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		log.Lvl1("Shutting down")
+		log.ErrFatal(skipchain.CloseServices())
+		os.Exit(0)
+	}()
 	app.RunServer(config)
+	log.ErrFatal(skipchain.CloseServices())
 }
 
 // checkConfig contacts all servers and verifies if it receives a valid
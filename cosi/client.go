@@ -12,6 +12,7 @@ import (
 
 	"github.com/dedis/cothority/cosi/check"
 	s "github.com/dedis/cothority/cosi/service"
+	verifier "github.com/dedis/cothority/cosi/verify"
 	"gopkg.in/dedis/crypto.v0/abstract"
 	"gopkg.in/dedis/crypto.v0/cosi"
 	"gopkg.in/dedis/onet.v1"
@@ -206,6 +207,20 @@ func verifySignatureHash(b []byte, sig *s.SignatureResponse, el *onet.Roster) er
 	if err := cosi.VerifySignature(network.Suite, publics, fHash, sig.Signature); err != nil {
 		return errors.New("Invalid sig:" + err.Error())
 	}
+	if sig.Exported != nil {
+		// the participation has already been checked with the
+		// cosi-signature, so any number of signers is accepted here
+		keys := make([][]byte, len(publics))
+		for i, p := range publics {
+			var err error
+			if keys[i], err = p.MarshalBinary(); err != nil {
+				return err
+			}
+		}
+		if err := verifier.Verify(keys, fHash, sig.Exported, 0); err != nil {
+			return errors.New("Invalid exported sig: " + err.Error())
+		}
+	}
 	return nil
 }
 func entityListToPublics(r *onet.Roster) []abstract.Point {
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/dedis/cothority/cosi/protocol"
+	"github.com/dedis/cothority/cosi/verify"
 	"github.com/satori/go.uuid"
 	"gopkg.in/dedis/onet.v1"
 	"gopkg.in/dedis/onet.v1/crypto"
@@ -41,6 +42,9 @@ type SignatureRequest struct {
 type SignatureResponse struct {
 	Hash      []byte
 	Signature []byte
+	// Exported is the signature in the format of the verify-package,
+	// which can be checked without onet or as an Ed25519-signature.
+	Exported *verify.Signature
 }
 
 // SignatureRequest treats external request to this service.
@@ -74,9 +78,21 @@ func (cs *CoSi) SignatureRequest(req *SignatureRequest) (network.Message, onet.C
 	if log.DebugVisible() > 1 {
 		fmt.Printf("%s: Signed a message.\n", time.Now().Format("Mon Jan 2 15:04:05 -0700 MST 2006"))
 	}
+	publics := make([][]byte, len(req.Roster.List))
+	for i, si := range req.Roster.List {
+		publics[i], err = si.Public.MarshalBinary()
+		if err != nil {
+			return nil, onet.NewClientErrorCode(4102, "Couldn't marshal public key: "+err.Error())
+		}
+	}
+	exported, err := verify.FromCoSi(publics, sig)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(4102, "Couldn't export signature: "+err.Error())
+	}
 	return &SignatureResponse{
 		Hash:      h,
 		Signature: sig,
+		Exported:  exported,
 	}, nil
 }
 
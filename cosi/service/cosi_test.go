@@ -3,7 +3,9 @@ package service
 import (
 	"testing"
 
+	"github.com/dedis/cothority/cosi/verify"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ed25519"
 	"gopkg.in/dedis/crypto.v0/cosi"
 	"gopkg.in/dedis/onet.v1"
 	"gopkg.in/dedis/onet.v1/log"
@@ -36,6 +38,7 @@ func TestServiceCosi(t *testing.T) {
 		// verify the response still
 		assert.Nil(t, cosi.VerifySignature(hosts[0].Suite(), el.Publics(),
 			msg, reply.Signature))
+		verifyExported(t, el, msg, reply)
 	}
 }
 
@@ -59,4 +62,21 @@ func TestCreateAggregate(t *testing.T) {
 	// verify the response still
 	assert.Nil(t, cosi.VerifySignature(hosts[0].Suite(), el.Publics(),
 		msg, res.Signature))
+	verifyExported(t, el, msg, res)
+}
+
+// verifyExported checks that the exported signature verifies with the
+// verify-package and as an Ed25519-signature.
+func verifyExported(t *testing.T, el *onet.Roster, msg []byte, reply *SignatureResponse) {
+	publics := make([][]byte, len(el.List))
+	for i, si := range el.List {
+		var err error
+		publics[i], err = si.Public.MarshalBinary()
+		log.ErrFatal(err)
+	}
+	if assert.NotNil(t, reply.Exported) {
+		assert.Nil(t, verify.Verify(publics, msg, reply.Exported, len(publics)))
+		assert.True(t, ed25519.Verify(reply.Exported.AggregateKey, msg,
+			reply.Exported.Ed25519()))
+	}
 }
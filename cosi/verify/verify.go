@@ -0,0 +1,163 @@
+/*
+Package verify checks collective signatures of the CoSi service without
+depending on onet, so that third parties can verify them with a minimal set
+of dependencies.
+
+A collective signature is exported as a Signature, holding:
+
+	AggregateKey - the sum of the public keys of the cosigners that took part
+	Commitment   - the aggregate commitment V
+	Response     - the aggregate response r
+	Mask         - the participation bitmask of the roster
+
+Points are encoded as 32-byte compressed Ed25519 points and the response as
+a 32-byte little-endian scalar, as in RFC 8032. Bit i%8 of byte i/8 of the
+mask is set if the i-th cosigner of the roster did NOT take part in the
+signature. In JSON, all fields are base64-encoded byte-strings.
+
+The challenge is c = SHA-512(V || AggregateKey || msg), read as a
+little-endian integer modulo the order of the group, and the signature is
+valid if r*B == V + c*AggregateKey. So Commitment || Response is a standard
+Ed25519 signature of msg under AggregateKey, which can be checked by any
+Ed25519 implementation. Verify additionally makes sure that AggregateKey is
+the sum of the keys of the roster given by the mask.
+*/
+package verify
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/ed25519"
+)
+
+// suite is the Ed25519-group used by the cothority.
+var suite = ed25519.NewAES128SHA256Ed25519(false)
+
+// Signature is a collective signature in the exported format.
+type Signature struct {
+	AggregateKey []byte `json:"aggregate_key"`
+	Commitment   []byte `json:"commitment"`
+	Response     []byte `json:"response"`
+	Mask         []byte `json:"mask"`
+}
+
+// FromCoSi returns the exported format of sig, a signature of the CoSi
+// protocol in the form V || r || mask, for the roster with the given
+// public keys.
+func FromCoSi(publics [][]byte, sig []byte) (*Signature, error) {
+	pointLen := suite.PointLen()
+	sigLen := pointLen + suite.ScalarLen()
+	if len(sig) != sigLen+maskLen(len(publics)) {
+		return nil, errors.New("Wrong length of signature")
+	}
+	s := &Signature{
+		Commitment: append([]byte{}, sig[:pointLen]...),
+		Response:   append([]byte{}, sig[pointLen:sigLen]...),
+		Mask:       append([]byte{}, sig[sigLen:]...),
+	}
+	agg, err := aggregate(publics, s.Mask)
+	if err != nil {
+		return nil, err
+	}
+	if s.AggregateKey, err = agg.MarshalBinary(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Ed25519 returns Commitment || Response, which is the Ed25519 signature
+// of the message under the AggregateKey.
+func (s *Signature) Ed25519() []byte {
+	return append(append([]byte{}, s.Commitment...), s.Response...)
+}
+
+// Signers returns the number of cosigners of the roster of n members that
+// took part in the signature.
+func (s *Signature) Signers(n int) int {
+	signers := 0
+	for i := 0; i < n && i/8 < len(s.Mask); i++ {
+		if !masked(s.Mask, i) {
+			signers++
+		}
+	}
+	return signers
+}
+
+// Verify returns an error if sig is not a valid signature of msg by at
+// least threshold cosigners of the roster with the given public keys. To
+// require all cosigners, threshold must be len(publics).
+func Verify(publics [][]byte, msg []byte, sig *Signature, threshold int) error {
+	if sig == nil {
+		return errors.New("No signature given")
+	}
+	if len(sig.Mask) != maskLen(len(publics)) {
+		return errors.New("Wrong length of mask")
+	}
+	if signers := sig.Signers(len(publics)); signers < threshold {
+		return fmt.Errorf("Only %d out of %d cosigners signed", signers,
+			len(publics))
+	}
+	agg, err := aggregate(publics, sig.Mask)
+	if err != nil {
+		return err
+	}
+	aggBuf, err := agg.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(aggBuf, sig.AggregateKey) {
+		return errors.New("Aggregate key doesn't match the roster")
+	}
+	V := suite.Point()
+	if err := V.UnmarshalBinary(sig.Commitment); err != nil {
+		return errors.New("Invalid commitment: " + err.Error())
+	}
+	if len(sig.Response) != suite.ScalarLen() {
+		return errors.New("Invalid response")
+	}
+	r := suite.Scalar().SetBytes(sig.Response)
+
+	h := sha512.New()
+	h.Write(sig.Commitment)
+	h.Write(aggBuf)
+	h.Write(msg)
+	c := suite.Scalar().SetBytes(h.Sum(nil))
+
+	// r*B == V + c*A
+	left := suite.Point().Mul(nil, r)
+	right := suite.Point().Add(V, suite.Point().Mul(agg, c))
+	if !left.Equal(right) {
+		return errors.New("Invalid signature")
+	}
+	return nil
+}
+
+// aggregate returns the sum of the public keys that are not masked.
+func aggregate(publics [][]byte, mask []byte) (abstract.Point, error) {
+	agg := suite.Point().Null()
+	for i, buf := range publics {
+		if masked(mask, i) {
+			continue
+		}
+		pub := suite.Point()
+		if err := pub.UnmarshalBinary(buf); err != nil {
+			return nil, fmt.Errorf("Invalid public key %d: %s", i, err)
+		}
+		agg.Add(agg, pub)
+	}
+	return agg, nil
+}
+
+// masked returns true if the i-th bit of the mask is set.
+func masked(mask []byte, i int) bool {
+	return mask[i/8]&(1<<uint(i%8)) != 0
+}
+
+// maskLen returns the length of the mask for n cosigners.
+func maskLen(n int) int {
+	return (n + 7) / 8
+}
@@ -0,0 +1,104 @@
+package verify
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/config"
+	"gopkg.in/dedis/crypto.v0/cosi"
+)
+
+func TestVerify(t *testing.T) {
+	msg := []byte("collective statement")
+	for _, absent := range [][]int{nil, {2}, {1, 4}} {
+		publics, sig := cosiSign(t, 5, absent, msg)
+		exp, err := FromCoSi(publics, sig)
+		require.Nil(t, err)
+		assert.Equal(t, 5-len(absent), exp.Signers(5))
+		assert.Nil(t, Verify(publics, msg, exp, 5-len(absent)))
+		assert.NotNil(t, Verify(publics, msg, exp, 6-len(absent)))
+		assert.NotNil(t, Verify(publics, []byte("other statement"), exp, 0))
+
+		// the reference implementation accepts the signature
+		assert.True(t, ed25519.Verify(exp.AggregateKey, msg, exp.Ed25519()))
+		assert.False(t, ed25519.Verify(exp.AggregateKey, []byte("other"),
+			exp.Ed25519()))
+
+		// hiding an absent cosigner changes the aggregate key
+		wrong := *exp
+		wrong.Mask = make([]byte, len(exp.Mask))
+		assert.NotNil(t, Verify(publics, msg, &wrong, 0))
+	}
+}
+
+func TestVerifyEd25519(t *testing.T) {
+	// a signature of the reference implementation by a single signer is a
+	// collective signature of a roster with one member
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.Nil(t, err)
+	msg := []byte("single statement")
+	sig := ed25519.Sign(priv, msg)
+	exp := &Signature{
+		AggregateKey: pub,
+		Commitment:   sig[:32],
+		Response:     sig[32:],
+		Mask:         []byte{0},
+	}
+	assert.Nil(t, Verify([][]byte{pub}, msg, exp, 1))
+	exp.Response[0] ^= 1
+	assert.NotNil(t, Verify([][]byte{pub}, msg, exp, 1))
+}
+
+// cosiSign returns the public keys of n cosigners and their signature of
+// msg, created by the CoSi-implementation of dedis/crypto. The cosigners
+// with the given indexes don't take part.
+func cosiSign(t *testing.T, n int, absent []int, msg []byte) ([][]byte, []byte) {
+	var publics []abstract.Point
+	var privates []abstract.Scalar
+	for i := 0; i < n; i++ {
+		kp := config.NewKeyPair(suite)
+		publics = append(publics, kp.Public)
+		privates = append(privates, kp.Secret)
+	}
+	missing := make([]bool, n)
+	for _, i := range absent {
+		missing[i] = true
+	}
+	// cosigner 0 is the root, all other cosigners are its children
+	var cosis []*cosi.CoSi
+	var commits []abstract.Point
+	for i := 1; i < n; i++ {
+		if missing[i] {
+			continue
+		}
+		c := cosi.NewCosi(suite, privates[i], publics)
+		cosis = append(cosis, c)
+		commits = append(commits, c.CreateCommitment(nil))
+	}
+	root := cosi.NewCosi(suite, privates[0], publics)
+	root.Commit(nil, commits)
+	for _, i := range absent {
+		root.SetMaskBit(i, false)
+	}
+	challenge, err := root.CreateChallenge(msg)
+	require.Nil(t, err)
+	var responses []abstract.Scalar
+	for _, c := range cosis {
+		c.Challenge(challenge)
+		resp, err := c.CreateResponse()
+		require.Nil(t, err)
+		responses = append(responses, resp)
+	}
+	_, err = root.Response(responses)
+	require.Nil(t, err)
+
+	bufs := make([][]byte, n)
+	for i, p := range publics {
+		bufs[i], err = p.MarshalBinary()
+		require.Nil(t, err)
+	}
+	return bufs, root.Signature()
+}
@@ -495,37 +495,38 @@ func (s *Service) CheckConfigReply(req *network.Envelope) {
 
 /* -------------Verification functions------------- */
 
-// Verification function for signing during Finalization
-func (s *Service) bftVerifyFinal(Msg []byte, Data []byte) bool {
+// Verification function for signing during Finalization. The returned
+// error is the reason of the refusal, which is passed to the leader.
+func (s *Service) bftVerifyFinal(Msg []byte, Data []byte) error {
 	final, err := NewFinalStatementFromToml(Data)
 	if err != nil {
 		log.Error(err.Error())
-		return false
+		return errors.New("couldn't decode final statement")
 	}
 	hash, err := final.Hash()
 	if err != nil {
 		log.Error(err.Error())
-		return false
+		return errors.New("couldn't hash final statement")
 	}
 	if !bytes.Equal(hash, Msg) {
 		log.Error("hash of received Final stmt and msg are not equal")
-		return false
+		return errors.New("hash of final statement is not the message")
 	}
 	var fs *FinalStatement
 	var ok bool
 
 	if fs, ok = s.data.Finals[string(final.Desc.Hash())]; !ok {
 		log.Error("final Statement not found")
-		return false
+		return errors.New("final statement not found")
 	}
 
 	hash, err = fs.Hash()
 
 	if !bytes.Equal(hash, Msg) {
 		log.Error("hash of lccocal Final stmt and msg are not equal")
-		return false
+		return errors.New("local final statement is different")
 	}
-	return true
+	return nil
 }
 
 // Verification function for sighning during Merging
@@ -705,13 +706,16 @@ func (s *Service) signAndPropagate(final *FinalStatement, protoName string,
 	final.Signature = []byte{}
 	go node.Start()
 
+	var refused error
 	select {
 	case <-done:
 		sig := root.Signature()
-		if len(sig.Sig) >= SIGSIZE {
+		if len(sig.Sig) >= SIGSIZE && len(sig.Exceptions) == 0 {
 			final.Signature = sig.Sig[:SIGSIZE]
 		} else {
 			final.Signature = []byte{}
+			refused = bftcosi.RefusalError(network.Suite,
+				final.Desc.Roster, sig)
 		}
 	case <-time.After(TIMEOUT):
 		log.Error("signing failed on timeout")
@@ -719,6 +723,11 @@ func (s *Service) signAndPropagate(final *FinalStatement, protoName string,
 			"signing timeout")
 	}
 	if len(final.Signature) <= 0 {
+		if refused != nil {
+			log.Error("Signing refused:", refused)
+			return onet.NewClientErrorCode(ErrorOtherFinals,
+				"Signing refused: "+refused.Error())
+		}
 		log.Error("Signing failed")
 		return onet.NewClientErrorCode(ErrorTimeout,
 			"Signing failed")
@@ -977,7 +986,9 @@ func newService(c *onet.Context) onet.Service {
 	s.RegisterProcessorFunc(mergeConfigID, s.MergeConfig)
 	s.RegisterProcessorFunc(mergeConfigReplyID, s.MergeConfigReply)
 	s.ProtocolRegister(bftSignFinal, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
-		return bftcosi.NewBFTCoSiProtocol(n, s.bftVerifyFinal)
+		// All organizers must agree on the final statement.
+		return bftcosi.NewBFTCoSiProtocolReason(n, s.bftVerifyFinal,
+			bftcosi.AllSign())
 	})
 	s.ProtocolRegister(bftSignMerge, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
 		return bftcosi.NewBFTCoSiProtocol(n, s.bftVerifyMerge)
@@ -99,6 +99,20 @@ func main() {
 			ArgsUsage: "skipchain-id",
 			Action:    update,
 		},
+		{
+			Name:      "export",
+			Usage:     "write all blocks of a skipchain to a file",
+			Aliases:   []string{"e"},
+			ArgsUsage: "skipchain-id file",
+			Action:    export,
+		},
+		{
+			Name:      "import",
+			Usage:     "verify and store the blocks of an exported skipchain",
+			Aliases:   []string{"i"},
+			ArgsUsage: "file",
+			Action:    importChain,
+		},
 		{
 			Name:  "list",
 			Usage: "handle list of skipblocks",
@@ -295,6 +309,64 @@ func update(c *cli.Context) error {
 	return nil
 }
 
+// Exports all blocks of a skipchain to a file
+func export(c *cli.Context) error {
+	log.Info("Exporting skipchain")
+	if c.NArg() < 2 {
+		return errors.New("please give skipchain-id and file to export to")
+	}
+	cfg := getConfigOrFail(c)
+	sb := cfg.Sbm.GetFuzzy(c.Args().First())
+	if sb == nil {
+		return errors.New("didn't find latest block in local store")
+	}
+	client := skipchain.NewClient()
+	guc, cerr := client.GetUpdateChain(sb.Roster, sb.Hash)
+	if cerr != nil {
+		return errors.New("while updating chain: " + cerr.Error())
+	}
+	latest := guc.Update[len(guc.Update)-1]
+	genesis := sb.SkipChainID()
+	blocks, cerr := client.ExportSkipChain(latest.Roster, genesis)
+	if cerr != nil {
+		return errors.New("while exporting chain: " + cerr.Error())
+	}
+	for i, b := range blocks {
+		if b.Index != i {
+			return fmt.Errorf("conode misses block %d of the chain", i)
+		}
+	}
+	if len(blocks) <= latest.Index {
+		return fmt.Errorf("conode misses blocks after %d", len(blocks)-1)
+	}
+	f, err := os.Create(c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+	if err := skipchain.WriteExport(f, blocks); err != nil {
+		f.Close()
+		return err
+	}
+	log.Infof("Exported %d blocks of chain %x", len(blocks), genesis)
+	return f.Close()
+}
+
+// Imports an exported skipchain into the local store
+func importChain(c *cli.Context) error {
+	log.Info("Importing skipchain")
+	if c.NArg() < 1 {
+		return errors.New("please give file to import")
+	}
+	cfg := getConfigOrFail(c)
+	blocks, err := cfg.Sbm.ImportFile(c.Args().First())
+	if err != nil {
+		return errors.New("while importing chain: " + err.Error())
+	}
+	log.Infof("Imported %d blocks of chain %x", len(blocks), blocks[0].Hash)
+	log.ErrFatal(cfg.save(c))
+	return nil
+}
+
 // lsKnown shows all known skipblocks
 func lsKnown(c *cli.Context) error {
 	cfg, err := loadConfig(c)
@@ -19,9 +19,24 @@ main(){
 	test Index
 	test Html
 	test Fetch
+	test Export
 	stopTest
 }
 
+testExport(){
+	startCl
+	setupGenesis
+	testOK runSc add $ID public.toml
+	testFail runSc export $ID
+	testOK runSc export $ID export.bin
+	rm $CFG
+	testGrep "Didn't find any" runSc list known
+	testFail runSc import public.toml
+	testOK runSc import export.bin
+	testGrep $ID runSc list known -l
+	rm export.bin
+}
+
 testFetch(){
 	startCl
 	setupGenesis
@@ -1,8 +1,12 @@
 package skipchain
 
 import (
+	"sync"
+	"time"
+
 	"gopkg.in/dedis/crypto.v0/abstract"
 	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/crypto"
 	"gopkg.in/dedis/onet.v1/log"
 	"gopkg.in/dedis/onet.v1/network"
 )
@@ -26,6 +30,12 @@ const (
 	// ErrorBlockInProgress indicates that currently a block is being formed
 	// and propagated
 	ErrorBlockInProgress
+	// ErrorKeyNotFound indicates that a key is not set in the key/value-state
+	// of a skipchain.
+	ErrorKeyNotFound
+	// ErrorRejected indicates that a verifier of the skipchain refused the
+	// new block. The message holds the reason of the rejection.
+	ErrorRejected
 )
 
 // Client is a structure to communicate with the Skipchain
@@ -48,6 +58,16 @@ func NewClient() *Client {
 //  - d is the data for the new block. It can be nil. If it is not of type
 //   []byte, it will be marshalled using `network.Marshal`.
 func (c *Client) StoreSkipBlock(latest *SkipBlock, el *onet.Roster, d network.Message) (reply *StoreSkipBlockReply, cerr onet.ClientError) {
+	return c.StoreSkipBlockSignature(latest, el, d, nil)
+}
+
+// StoreSkipBlockSignature works like StoreSkipBlock, but signs the new block
+// with the private key priv. This is needed for skipchains that have
+// ClientKeys in their genesis-block. If priv is nil, the block is not signed.
+// If the leader of the roster of the new block fails, the next members of the
+// roster are asked to append the block.
+func (c *Client) StoreSkipBlockSignature(latest *SkipBlock, el *onet.Roster, d network.Message,
+	priv abstract.Scalar) (reply *StoreSkipBlockReply, cerr onet.ClientError) {
 	log.Lvlf3("%#v", latest)
 	var newBlock *SkipBlock
 	var latestID SkipBlockID
@@ -72,20 +92,49 @@ func (c *Client) StoreSkipBlock(latest *SkipBlock, el *onet.Roster, d network.Me
 		}
 		latestID = latest.Hash
 	}
-	host := latest.Roster.Get(0)
-	reply = &StoreSkipBlockReply{}
-	cerr = c.SendProtobuf(host, &StoreSkipBlock{latestID, newBlock}, reply)
-	if cerr != nil {
-		return nil, cerr
+	var sig *crypto.SchnorrSig
+	if priv != nil && !latestID.IsNull() {
+		s, err := crypto.SignSchnorr(network.Suite, priv,
+			newBlock.ClientHash(latestID))
+		if err != nil {
+			return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+				"Couldn't sign block: "+err.Error())
+		}
+		sig = &s
 	}
-	return reply, nil
+	// If the leader fails, the next member of the roster of the latest
+	// block is asked, which waits for its view before appending the block.
+	leaders := newBlock.Roster.List[:1]
+	if !latestID.IsNull() {
+		leaders = latest.Roster.List
+	}
+	for _, host := range leaders {
+		reply = &StoreSkipBlockReply{}
+		cerr = c.SendProtobuf(host, &StoreSkipBlock{latestID, newBlock, sig}, reply)
+		if cerr == nil {
+			return reply, nil
+		}
+		if isServiceError(cerr) {
+			break
+		}
+		log.Lvl2("Leader", host, "failed, trying next member:", cerr)
+	}
+	return nil, cerr
+}
+
+// isServiceError returns true if the error has been returned by the
+// skipchain-service, in contrast to errors of the network or of onet.
+func isServiceError(cerr onet.ClientError) bool {
+	return cerr.ErrorCode() >= ErrorBlockNotFound &&
+		cerr.ErrorCode() <= ErrorRejected
 }
 
 // CreateGenesis is a convenience function to create a new SkipChain with the
 // given parameters.
 //  - el is the responsible roster
 //  - baseH is the base-height - the distance between two non-height-1 skipblocks
-//  - maxH is the maximum height, which must be <= baseH
+//  - maxH is the maximum height, which must be <= baseH, unless baseH is 0
+//    for a random skipchain
 //  - ver is a slice of verifications to apply to that block
 //  - data can be nil or any data that will be network.Marshaled to the skipblock
 //  - parent is the responsible parent-block, can be 'nil'
@@ -122,12 +171,19 @@ func (c *Client) CreateGenesis(el *onet.Roster, baseH, maxH int, ver []VerifierI
 //
 // A slice of verification-functions is given for the root and the control
 // skipchain.
+//
+// The keys are stored as RootData in the root-chain and are needed to sign
+// the next block of the root-chain.
 func (c *Client) CreateRootControl(elRoot, elControl *onet.Roster,
 	keys []abstract.Point, baseHeight,
 	maxHRoot, maxHControl int) (root, control *SkipBlock, cerr onet.ClientError) {
 	log.Lvl2("Creating root roster", elRoot)
+	var data interface{}
+	if len(keys) > 0 {
+		data = &RootData{Keys: keys}
+	}
 	root, cerr = c.CreateGenesis(elRoot, baseHeight, maxHRoot,
-		VerificationRoot, nil, nil)
+		VerificationRoot, data, nil)
 	if cerr != nil {
 		return
 	}
@@ -177,3 +233,167 @@ func (c *Client) GetSingleBlockByIndex(roster *onet.Roster, genesis SkipBlockID,
 		&GetSingleBlockByIndex{genesis, index}, reply)
 	return
 }
+
+// GetProof asks the roster for a proof of the latest block of the skipchain
+// with the given genesis-id. The proof is verified before it is returned, so
+// the latest block of the proof can be trusted.
+func (c *Client) GetProof(roster *onet.Roster, genesis SkipBlockID) (Proof, onet.ClientError) {
+	reply := &GetProofReply{}
+	cerr := c.SendProtobuf(roster.RandomServerIdentity(),
+		&GetProof{genesis}, reply)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if err := reply.Proof.Verify(genesis); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorVerification, err.Error())
+	}
+	return reply.Proof, nil
+}
+
+// StoreKeyValues appends a new block to the skipchain holding the changes
+// to the key/value-state of the skipchain. Every entry of data sets or
+// deletes one key.
+func (c *Client) StoreKeyValues(latest *SkipBlock, data *SkipBlockData) (*StoreSkipBlockReply, onet.ClientError) {
+	return c.StoreSkipBlock(latest, nil, data)
+}
+
+// StoreTransaction asks the leader of the latest block to store the data in
+// the next batch of transactions. It returns once the batch is stored, with
+// the id of the block and the position of the transaction in the block.
+func (c *Client) StoreTransaction(latest *SkipBlock, data []byte) (*StoreTransactionReply, onet.ClientError) {
+	reply := &StoreTransactionReply{}
+	cerr := c.SendProtobuf(latest.Roster.Get(0),
+		&StoreTransaction{latest.SkipChainID(), data}, reply)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return reply, nil
+}
+
+// GetValue returns the value of the key after the block with the given
+// index of the skipchain was applied, or the latest value if index is
+// negative. The block that wrote the value is returned, too, but is nil if
+// it has been pruned.
+func (c *Client) GetValue(roster *onet.Roster, genesis SkipBlockID, key string,
+	index int) (*GetValueReply, onet.ClientError) {
+	reply := &GetValueReply{}
+	cerr := c.SendProtobuf(roster.RandomServerIdentity(),
+		&GetValue{genesis, key, index}, reply)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return reply, nil
+}
+
+// SearchSkipChain returns the blocks of the skipchain that set the given key
+// in their SkipBlockData and that have been created between start and end.
+// An empty key matches all blocks, and a zero start or end doesn't limit the
+// time range.
+func (c *Client) SearchSkipChain(roster *onet.Roster, genesis SkipBlockID, key string,
+	start, end time.Time) ([]*SkipBlock, onet.ClientError) {
+	req := &SearchSkipChain{Genesis: genesis, Key: key}
+	if !start.IsZero() {
+		req.Start = start.UnixNano()
+	}
+	if !end.IsZero() {
+		req.End = end.UnixNano()
+	}
+	reply := &SearchSkipChainReply{}
+	cerr := c.SendProtobuf(roster.RandomServerIdentity(), req, reply)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return reply.Blocks, nil
+}
+
+// GetEquivocations returns the evidence of forks a conode found in the given
+// skipchain, or in all skipchains if genesis is nil. All evidence is
+// verified before it is returned.
+func (c *Client) GetEquivocations(si *network.ServerIdentity, genesis SkipBlockID) ([]*Equivocation, onet.ClientError) {
+	reply := &GetEquivocationsReply{}
+	cerr := c.SendProtobuf(si, &GetEquivocations{genesis}, reply)
+	if cerr != nil {
+		return nil, cerr
+	}
+	for _, e := range reply.Evidence {
+		if err := e.Verify(); err != nil {
+			return nil, onet.NewClientErrorCode(ErrorVerification, err.Error())
+		}
+	}
+	return reply.Evidence, nil
+}
+
+// GetParticipation returns how often every conode signed, missed or refused
+// to sign the forward-links of the skipchain, so that failing conodes can be
+// replaced before the roster can't sign new blocks anymore.
+func (c *Client) GetParticipation(roster *onet.Roster, genesis SkipBlockID) ([]*Participation, onet.ClientError) {
+	reply := &GetParticipationReply{}
+	cerr := c.SendProtobuf(roster.RandomServerIdentity(),
+		&GetParticipation{genesis}, reply)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return reply.Participation, nil
+}
+
+// ExportSkipChain asks the roster for all blocks of the skipchain and
+// returns them once the chain is verified. Blocks that have been pruned by
+// the conode are missing.
+func (c *Client) ExportSkipChain(roster *onet.Roster, genesis SkipBlockID) ([]*SkipBlock, onet.ClientError) {
+	reply := &ExportSkipChainReply{}
+	cerr := c.SendProtobuf(roster.RandomServerIdentity(),
+		&ExportSkipChain{genesis}, reply)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if err := verifyChain(genesis, nil, reply.Blocks); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorVerification, err.Error())
+	}
+	return reply.Blocks, nil
+}
+
+// Subscribe sends all blocks of the skipchain following the block 'from' to
+// the returned channel. It asks the conodes of the roster for new blocks
+// again and again, following the roster of the latest block. If a conode
+// fails, the next one is asked for the blocks following the last received
+// block. The returned function stops the subscription and closes the
+// channel.
+func (c *Client) Subscribe(roster *onet.Roster, genesis, from SkipBlockID) (<-chan *SkipBlock, func()) {
+	blocks := make(chan *SkipBlock, subscribeBuffer)
+	stop := make(chan bool)
+	go func() {
+		defer close(blocks)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			reply := &SubscribeSkipChainReply{}
+			cerr := c.SendProtobuf(roster.RandomServerIdentity(),
+				&SubscribeSkipChain{genesis, from, subscribeTimeout}, reply)
+			if cerr != nil {
+				log.Lvl2("Couldn't get new blocks:", cerr)
+				select {
+				case <-stop:
+					return
+				case <-time.After(time.Second):
+				}
+				continue
+			}
+			for _, sb := range reply.Blocks {
+				select {
+				case blocks <- sb:
+					from = sb.Hash
+					roster = sb.Roster
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return blocks, func() {
+		once.Do(func() { close(stop) })
+	}
+}
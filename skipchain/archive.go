@@ -0,0 +1,89 @@
+package skipchain
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+/*
+This file holds the functions to write skipblocks to and read them from a
+stream. Every block is marshalled using network.Marshal and prefixed by its
+length as a big-endian uint32.
+*/
+
+// maxBlockSize is the biggest marshalled block accepted by ReadBlocks, so
+// that a corrupted or crafted stream can't make us allocate gigabytes.
+const maxBlockSize = 32 * 1024 * 1024
+
+// WriteBlocks writes the blocks to w, each one prefixed by its length.
+func WriteBlocks(w io.Writer, sbs []*SkipBlock) error {
+	for _, sb := range sbs {
+		buf, err := network.Marshal(sb)
+		if err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, uint32(len(buf))); err != nil {
+			return err
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadBlocks reads length-prefixed blocks from r until the end of the
+// stream is reached. Blocks bigger than maxBlockSize are refused.
+func ReadBlocks(r io.Reader) ([]*SkipBlock, error) {
+	var sbs []*SkipBlock
+	for {
+		var l uint32
+		if err := binary.Read(r, binary.BigEndian, &l); err != nil {
+			if err == io.EOF {
+				return sbs, nil
+			}
+			return nil, err
+		}
+		if l > maxBlockSize {
+			return nil, fmt.Errorf("block of %d bytes is bigger than %d bytes",
+				l, maxBlockSize)
+		}
+		buf := make([]byte, l)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		sb, err := unmarshalSkipBlock(buf)
+		if err != nil {
+			return nil, err
+		}
+		sbs = append(sbs, sb)
+	}
+}
+
+// ReadArchive returns all blocks stored in the archive-file.
+func ReadArchive(filename string) ([]*SkipBlock, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadBlocks(f)
+}
+
+// appendArchive appends the blocks to the archive-file, creating it if it
+// doesn't exist.
+func appendArchive(filename string, sbs []*SkipBlock) error {
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err := WriteBlocks(f, sbs); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,190 @@
+package skipchain
+
+import (
+	"sync"
+	"time"
+
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+/*
+This file holds the batching of transactions. Instead of running a BFT-round
+for every call to StoreSkipBlock, clients can send single transactions with
+StoreTransaction. The leader collects the transactions of every skipchain
+until the interval of the BatchPolicy passed or enough transactions are
+pending, and then stores all of them in one block. Every client gets the id
+of the block and the position of its transaction in the block.
+*/
+
+// The default policy for batching transactions.
+const defaultBatchInterval = 100
+const defaultBatchSize = 100
+
+// How often a batch is retried if another block is being added to the
+// skipchain.
+const batchRetries = 10
+
+// BatchPolicy defines how long the leader collects transactions and how many
+// transactions are stored in one block.
+type BatchPolicy struct {
+	// Interval is the number of msecs the leader waits after the first
+	// transaction before it stores the batch.
+	Interval int
+	// Size is the number of transactions that will be stored immediately.
+	// If Size is 0, only the Interval is used.
+	Size int
+}
+
+// SkipBlockBatch is stored in the data of a block holding a batch of
+// transactions.
+type SkipBlockBatch struct {
+	Transactions [][]byte
+}
+
+// batch holds the pending transactions of a skipchain and the channels
+// that get the results.
+type batch struct {
+	transactions [][]byte
+	replies      []chan *StoreTransactionReply
+	errors       []chan onet.ClientError
+	committed    bool
+}
+
+// SetBatchPolicy sets the batch policy for transactions of the given
+// skipchain on this conode. If genesis is nil, the policy is used for all
+// skipchains without their own policy.
+func (s *Service) SetBatchPolicy(genesis SkipBlockID, bp BatchPolicy) {
+	s.batchesMutex.Lock()
+	defer s.batchesMutex.Unlock()
+	if genesis.IsNull() {
+		s.batchDefault = bp
+		return
+	}
+	s.batchPolicies[string(genesis)] = bp
+}
+
+// StoreTransaction adds the transaction to the pending batch of the
+// skipchain and returns once the batch is stored in a new block.
+func (s *Service) StoreTransaction(req *StoreTransaction) (*StoreTransactionReply, onet.ClientError) {
+	genesis := s.Sbm.GetByID(req.Genesis)
+	if genesis == nil || genesis.Index != 0 {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			"Didn't find genesis-block")
+	}
+	if len(genesis.ClientKeys) > 0 {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			"Transactions of skipchains with client-keys can't be batched")
+	}
+	latest, err := s.Sbm.GetLatest(genesis)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound, err.Error())
+	}
+	if i, _ := latest.Roster.Search(s.ServerIdentity().ID); i < 0 {
+		return nil, onet.NewClientErrorCode(ErrorBlockContent,
+			"We're not responsible for latest block")
+	}
+
+	reply := make(chan *StoreTransactionReply, 1)
+	cerr := make(chan onet.ClientError, 1)
+	s.batchesMutex.Lock()
+	bp, ok := s.batchPolicies[string(req.Genesis)]
+	if !ok {
+		bp = s.batchDefault
+	}
+	b, ok := s.batches[string(req.Genesis)]
+	if !ok {
+		b = &batch{}
+		s.batches[string(req.Genesis)] = b
+		time.AfterFunc(time.Duration(bp.Interval)*time.Millisecond, func() {
+			s.commitBatch(req.Genesis, b)
+		})
+	}
+	b.transactions = append(b.transactions, req.Data)
+	b.replies = append(b.replies, reply)
+	b.errors = append(b.errors, cerr)
+	if bp.Size > 0 && len(b.transactions) >= bp.Size {
+		delete(s.batches, string(req.Genesis))
+		go s.commitBatch(req.Genesis, b)
+	}
+	s.batchesMutex.Unlock()
+
+	select {
+	case r := <-reply:
+		return r, nil
+	case e := <-cerr:
+		return nil, e
+	}
+}
+
+// commitBatch stores the transactions of the batch in a new block and sends
+// the result to all waiting clients. The batches of one skipchain are
+// committed one after the other.
+func (s *Service) commitBatch(genesis SkipBlockID, b *batch) {
+	s.batchesMutex.Lock()
+	if b.committed {
+		s.batchesMutex.Unlock()
+		return
+	}
+	b.committed = true
+	if s.batches[string(genesis)] == b {
+		delete(s.batches, string(genesis))
+	}
+	commit, ok := s.batchCommits[string(genesis)]
+	if !ok {
+		commit = &sync.Mutex{}
+		s.batchCommits[string(genesis)] = commit
+	}
+	s.batchesMutex.Unlock()
+
+	commit.Lock()
+	defer commit.Unlock()
+	log.Lvl3(s.ServerIdentity(), "stores batch of", len(b.transactions),
+		"transactions")
+	sb, cerr := s.storeBatch(genesis, b.transactions)
+	for i := range b.transactions {
+		if cerr != nil {
+			b.errors[i] <- cerr
+			continue
+		}
+		b.replies[i] <- &StoreTransactionReply{
+			Block:    sb.Hash,
+			Index:    sb.Index,
+			Position: i,
+		}
+	}
+}
+
+// storeBatch appends a block holding the transactions to the skipchain. If
+// another block is being added, it retries up to batchRetries times.
+func (s *Service) storeBatch(genesisID SkipBlockID, transactions [][]byte) (*SkipBlock, onet.ClientError) {
+	buf, err := network.Marshal(&SkipBlockBatch{transactions})
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong, err.Error())
+	}
+	for i := 0; ; i++ {
+		genesis := s.Sbm.GetByID(genesisID)
+		if genesis == nil {
+			return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+				"Didn't find genesis-block")
+		}
+		latest, err := s.Sbm.GetLatest(genesis)
+		if err != nil {
+			return nil, onet.NewClientErrorCode(ErrorBlockNotFound, err.Error())
+		}
+		sb := NewSkipBlock()
+		sb.Roster = latest.Roster
+		sb.Data = buf
+		reply, cerr := s.StoreSkipBlock(&StoreSkipBlock{latest.Hash, sb, nil})
+		if cerr == nil {
+			return reply.Latest, nil
+		}
+		if i >= batchRetries || (cerr.ErrorCode() != ErrorBlockInProgress &&
+			cerr.ErrorCode() != ErrorBlockContent) {
+			return nil, cerr
+		}
+		log.Lvl2("Retrying to store batch:", cerr)
+		time.Sleep(defaultBatchInterval * time.Millisecond)
+	}
+}
@@ -0,0 +1,51 @@
+package skipchain
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func TestService_StoreTransaction(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, roster, s := makeHELS(local, 3)
+	genesis, err := makeGenesisRosterArgs(s, roster, nil, VerificationNone, 2, 2)
+	log.ErrFatal(err)
+	s.SetBatchPolicy(genesis.Hash, BatchPolicy{Interval: 500, Size: 5})
+
+	nbrTransactions := 10
+	replies := make([]*StoreTransactionReply, nbrTransactions)
+	var wg sync.WaitGroup
+	for i := 0; i < nbrTransactions; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			reply, cerr := s.StoreTransaction(&StoreTransaction{genesis.Hash, []byte{byte(i)}})
+			log.ErrFatal(cerr)
+			replies[i] = reply
+		}(i)
+	}
+	wg.Wait()
+	waitPropagationFinished(local)
+
+	blocks := map[string]bool{}
+	for i, reply := range replies {
+		blocks[string(reply.Block)] = true
+		sb := s.Sbm.GetByID(reply.Block)
+		require.NotNil(t, sb)
+		require.Equal(t, reply.Index, sb.Index)
+		_, msg, err := network.Unmarshal(sb.Data)
+		log.ErrFatal(err)
+		batch := msg.(*SkipBlockBatch)
+		require.Equal(t, []byte{byte(i)}, batch.Transactions[reply.Position])
+	}
+	require.True(t, len(blocks) < nbrTransactions, "Transactions should be batched")
+
+	_, cerr := s.StoreTransaction(&StoreTransaction{SkipBlockID{}, []byte{}})
+	require.NotNil(t, cerr)
+}
@@ -0,0 +1,133 @@
+package skipchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/dedis/onet.v1"
+)
+
+/*
+This file holds the export and import of whole skipchains. An export starts
+with a header made of the exportMagic and the version of the format as a
+big-endian uint32, followed by the blocks of the skipchain in the format of
+WriteBlocks, starting with the genesis-block. Every block is exported with
+its forward-links, so the whole chain can be verified when importing it.
+*/
+
+// exportMagic starts every export.
+var exportMagic = []byte("SCEX")
+
+// ExportVersion is the version of the export-format written by Export.
+const ExportVersion = 1
+
+// Export writes the skipchain starting at genesis to w.
+func (sbm *SkipBlockMap) Export(w io.Writer, genesis SkipBlockID) error {
+	sb := sbm.GetByID(genesis)
+	if sb == nil || sb.Index != 0 {
+		return errors.New("Didn't find genesis-block")
+	}
+	return WriteExport(w, append([]*SkipBlock{sb}, sbm.blocksAfter(sb)...))
+}
+
+// Import reads an export from r, verifies all blocks and their
+// forward-links, and stores them. Nothing is stored if one of the blocks
+// fails to verify. It returns the imported blocks.
+func (sbm *SkipBlockMap) Import(r io.Reader) ([]*SkipBlock, error) {
+	sbs, err := ReadExport(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, sb := range sbs {
+		sbm.Store(sb)
+	}
+	return sbs, nil
+}
+
+// WriteExport writes the header of an export followed by the blocks, which
+// must start with the genesis-block.
+func WriteExport(w io.Writer, sbs []*SkipBlock) error {
+	if _, err := w.Write(exportMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(ExportVersion)); err != nil {
+		return err
+	}
+	return WriteBlocks(w, sbs)
+}
+
+// ReadExport reads an export from r and returns its blocks once the chain
+// and all signatures of the forward-links are verified.
+func ReadExport(r io.Reader) ([]*SkipBlock, error) {
+	magic := make([]byte, len(exportMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(magic, exportMagic) {
+		return nil, errors.New("Not a skipchain export")
+	}
+	var version uint32
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return nil, err
+	}
+	if version != ExportVersion {
+		return nil, fmt.Errorf("Unsupported export version %d", version)
+	}
+	sbs, err := ReadBlocks(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(sbs) == 0 {
+		return nil, errors.New("Export holds no blocks")
+	}
+	if err := verifyChain(sbs[0].Hash, nil, sbs); err != nil {
+		return nil, err
+	}
+	for _, sb := range sbs {
+		if err := sb.VerifyForwardSignatures(); err != nil {
+			return nil, err
+		}
+	}
+	return sbs, nil
+}
+
+// ExportSkipChain returns all blocks of the skipchain this conode knows,
+// starting with the genesis-block, so that a client can export the chain
+// with one request.
+func (s *Service) ExportSkipChain(req *ExportSkipChain) (*ExportSkipChainReply, onet.ClientError) {
+	genesis := s.Sbm.GetByID(req.Genesis)
+	if genesis == nil || genesis.Index != 0 {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			"No such genesis-block")
+	}
+	return &ExportSkipChainReply{
+		Blocks: append([]*SkipBlock{genesis}, s.Sbm.blocksAfter(genesis)...),
+	}, nil
+}
+
+// ExportFile writes the skipchain starting at genesis to the file.
+func (sbm *SkipBlockMap) ExportFile(filename string, genesis SkipBlockID) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := sbm.Export(f, genesis); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ImportFile imports the skipchain stored in the file.
+func (sbm *SkipBlockMap) ImportFile(filename string) ([]*SkipBlock, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return sbm.Import(f)
+}
@@ -0,0 +1,69 @@
+package skipchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+func TestSkipBlockMap_ExportImport(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, roster, s := makeHELS(local, 3)
+	genesis, err := makeGenesisRosterArgs(s, roster, nil, VerificationNone, 2, 2)
+	log.ErrFatal(err)
+	latest := genesis
+	for i := 1; i < 5; i++ {
+		sb := NewSkipBlock()
+		sb.Roster = roster
+		sb.Data = []byte{byte(i)}
+		ssbr, cerr := s.StoreSkipBlock(&StoreSkipBlock{latest.Hash, sb, nil})
+		log.ErrFatal(cerr)
+		latest = ssbr.Latest
+	}
+	waitPropagationFinished(local)
+
+	reply, cerr := s.ExportSkipChain(&ExportSkipChain{genesis.Hash})
+	log.ErrFatal(cerr)
+	require.Equal(t, 5, len(reply.Blocks))
+	log.ErrFatal(verifyChain(genesis.Hash, nil, reply.Blocks))
+	_, cerr = s.ExportSkipChain(&ExportSkipChain{latest.Hash})
+	require.NotNil(t, cerr)
+
+	buf := &bytes.Buffer{}
+	log.ErrFatal(s.Sbm.Export(buf, genesis.Hash))
+	export := buf.Bytes()
+
+	sbm := NewSkipBlockMap()
+	sbs, err := sbm.Import(bytes.NewReader(export))
+	log.ErrFatal(err)
+	require.Equal(t, 5, len(sbs))
+	require.Equal(t, 5, sbm.Length())
+	imported, err := sbm.GetLatest(sbm.GetByID(genesis.Hash))
+	log.ErrFatal(err)
+	require.True(t, imported.Hash.Equal(latest.Hash))
+
+	log.Lvl1("Refusing tampered blocks")
+	tampered := s.Sbm.GetByID(latest.Hash)
+	tampered.Data = []byte{0xff}
+	buf = &bytes.Buffer{}
+	log.ErrFatal(WriteExport(buf, append([]*SkipBlock{s.Sbm.GetByID(genesis.Hash)},
+		s.Sbm.blocksAfter(s.Sbm.GetByID(genesis.Hash))[:3]...)))
+	log.ErrFatal(WriteBlocks(buf, []*SkipBlock{tampered}))
+	sbm = NewSkipBlockMap()
+	_, err = sbm.Import(buf)
+	require.NotNil(t, err)
+	require.Equal(t, 0, sbm.Length(), "Nothing should be stored")
+
+	log.Lvl1("Refusing unknown versions")
+	wrong := append([]byte{}, export...)
+	binary.BigEndian.PutUint32(wrong[len(exportMagic):], ExportVersion+1)
+	_, err = NewSkipBlockMap().Import(bytes.NewReader(wrong))
+	require.NotNil(t, err)
+	_, err = NewSkipBlockMap().Import(bytes.NewReader(export[len(exportMagic):]))
+	require.NotNil(t, err)
+}
@@ -0,0 +1,233 @@
+package skipchain
+
+import (
+	"errors"
+
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+/*
+This file holds the detection of forks in skipchains. A roster must never
+sign two different blocks as successor of the same block. Whenever a conode
+receives a block it already knows, it compares the forward-links, and if a
+link at the same height points to another block that also follows the
+block, the two links and their target-blocks are kept as evidence of the
+equivocation of the roster.
+*/
+
+// Name of the saved evidence.
+const evidenceID = "skipchain-evidence"
+
+func init() {
+	network.RegisterMessage(&equivocationStore{})
+}
+
+// Equivocation is the proof that the roster of a block signed two different
+// successors of that block at the same height.
+type Equivocation struct {
+	// Block is the block whose roster signed both links, without its
+	// forward-links.
+	Block *SkipBlock
+	// Height is the index of the two links in the forward-links.
+	Height int
+	// First and Second are the conflicting forward-links.
+	First  *BlockLink
+	Second *BlockLink
+	// FirstTarget and SecondTarget are the blocks the links point to,
+	// without their forward-links. Both have a back-link to Block at
+	// Height, else the signatures wouldn't prove anything, as a
+	// forward-link only signs the hash of its target.
+	FirstTarget  *SkipBlock
+	SecondTarget *SkipBlock
+}
+
+// equivocationStore is used to save the evidence of a conode.
+type equivocationStore struct {
+	Evidence []*Equivocation
+}
+
+// newEquivocation returns the evidence that the links first and second of
+// sb at the given height point to the different blocks firstTarget and
+// secondTarget.
+func newEquivocation(sb *SkipBlock, height int, first, second *BlockLink,
+	firstTarget, secondTarget *SkipBlock) *Equivocation {
+	return &Equivocation{
+		Block:        withoutLinks(sb),
+		Height:       height,
+		First:        first.Copy(),
+		Second:       second.Copy(),
+		FirstTarget:  withoutLinks(firstTarget),
+		SecondTarget: withoutLinks(secondTarget),
+	}
+}
+
+// withoutLinks returns a copy of sb without forward-links and children.
+func withoutLinks(sb *SkipBlock) *SkipBlock {
+	block := sb.Copy()
+	block.ForwardLink = nil
+	block.ChildSL = nil
+	return block
+}
+
+// Verify returns nil if both links point to different blocks that follow
+// the block at the height of the links, and if both links are collectively
+// signed by the roster of the block.
+func (e *Equivocation) Verify() error {
+	if e.Block == nil || e.Block.SkipBlockFix == nil || e.Block.Roster == nil ||
+		e.First == nil || e.Second == nil {
+		return errors.New("incomplete evidence")
+	}
+	if !e.Block.calculateHash().Equal(e.Block.Hash) {
+		return errors.New("wrong hash of block")
+	}
+	if e.First.Hash.Equal(e.Second.Hash) {
+		return errors.New("both links point to the same block")
+	}
+	if err := e.verifyTarget(e.First, e.FirstTarget); err != nil {
+		return errors.New("wrong first target: " + err.Error())
+	}
+	if err := e.verifyTarget(e.Second, e.SecondTarget); err != nil {
+		return errors.New("wrong second target: " + err.Error())
+	}
+	publics := e.Block.Roster.Publics()
+	if err := e.First.VerifySignature(publics); err != nil {
+		return errors.New("wrong signature of first link: " + err.Error())
+	}
+	if err := e.Second.VerifySignature(publics); err != nil {
+		return errors.New("wrong signature of second link: " + err.Error())
+	}
+	return nil
+}
+
+// verifyTarget makes sure that target is the block the link points to and
+// that it follows the block of the evidence at the height of the link.
+func (e *Equivocation) verifyTarget(link *BlockLink, target *SkipBlock) error {
+	if target == nil || target.SkipBlockFix == nil {
+		return errors.New("missing block")
+	}
+	if !target.calculateHash().Equal(target.Hash) ||
+		!target.Hash.Equal(link.Hash) {
+		return errors.New("link doesn't point to block")
+	}
+	if e.Height < 0 || e.Height >= len(target.BackLinkIDs) ||
+		!target.BackLinkIDs[e.Height].Equal(e.Block.Hash) {
+		return errors.New("block doesn't follow the block of the evidence")
+	}
+	return nil
+}
+
+// GetEquivocations returns all evidence of equivocations this conode found
+// in the given skipchain, or in all skipchains if Genesis is nil.
+func (s *Service) GetEquivocations(req *GetEquivocations) (*GetEquivocationsReply, onet.ClientError) {
+	s.evidenceMutex.Lock()
+	defer s.evidenceMutex.Unlock()
+	reply := &GetEquivocationsReply{}
+	for _, e := range s.evidence {
+		if req.Genesis.IsNull() || e.Block.SkipChainID().Equal(req.Genesis) {
+			reply.Evidence = append(reply.Evidence, e)
+		}
+	}
+	return reply, nil
+}
+
+// checkFork compares the forward-links of sb with the forward-links of the
+// known copy of sb. For every height where they point to different blocks
+// that both follow sb and are correctly signed, the evidence is recorded.
+// The blocks the links point to are searched in the known blocks and in
+// others. If sb is not known yet, it makes sure that the previous block
+// doesn't link to another block. It returns true if a fork has been found.
+func (s *Service) checkFork(sb *SkipBlock, others []*SkipBlock) bool {
+	known := s.Sbm.GetByID(sb.Hash)
+	if known == nil {
+		// A new block must be the one the previous block links to.
+		if sb.Index == 0 {
+			return false
+		}
+		prev := s.Sbm.GetByID(sb.BackLinkIDs[0])
+		if prev == nil || prev.GetForwardLen() == 0 ||
+			prev.ForwardLink[0].Hash.Equal(sb.Hash) {
+			return false
+		}
+		log.Warn("Block", sb.Index, "of skipchain", sb.SkipChainID().Short(),
+			"conflicts with the known block")
+		return true
+	}
+	found := false
+	for h := 0; h < len(sb.ForwardLink) && h < len(known.ForwardLink); h++ {
+		first, second := known.ForwardLink[h], sb.ForwardLink[h]
+		if first.Hash.Equal(second.Hash) {
+			continue
+		}
+		firstTarget := s.findBlock(first.Hash, others)
+		secondTarget := s.findBlock(second.Hash, others)
+		if firstTarget == nil || secondTarget == nil {
+			log.Lvl2("Ignoring conflicting forward-link to unknown block")
+			continue
+		}
+		e := newEquivocation(known, h, first, second, firstTarget, secondTarget)
+		if err := e.Verify(); err != nil {
+			log.Lvl2("Ignoring conflicting forward-link:", err)
+			continue
+		}
+		log.Warn("Found equivocation in block", known.Index,
+			"of skipchain", known.SkipChainID().Short())
+		s.addEquivocation(e)
+		found = true
+	}
+	return found
+}
+
+// findBlock returns the block with the given ID from others or from the
+// known blocks, or nil if it is not found.
+func (s *Service) findBlock(id SkipBlockID, others []*SkipBlock) *SkipBlock {
+	for _, sb := range others {
+		if sb.Hash.Equal(id) {
+			return sb
+		}
+	}
+	return s.Sbm.GetByID(id)
+}
+
+// addEquivocation records and saves the evidence, unless the same evidence
+// is already known.
+func (s *Service) addEquivocation(e *Equivocation) {
+	s.evidenceMutex.Lock()
+	defer s.evidenceMutex.Unlock()
+	for _, other := range s.evidence {
+		if other.Block.Hash.Equal(e.Block.Hash) && other.Height == e.Height &&
+			other.Second.Hash.Equal(e.Second.Hash) {
+			return
+		}
+	}
+	s.evidence = append(s.evidence, e)
+	if err := s.Save(evidenceID, &equivocationStore{s.evidence}); err != nil {
+		log.Error("Couldn't save evidence:", err)
+	}
+}
+
+// loadEvidence loads the saved evidence, if any. Evidence that doesn't
+// verify is dropped.
+func (s *Service) loadEvidence() error {
+	if !s.DataAvailable(evidenceID) {
+		return nil
+	}
+	msg, err := s.Load(evidenceID)
+	if err != nil {
+		return err
+	}
+	store, ok := msg.(*equivocationStore)
+	if !ok {
+		return errors.New("Data of wrong type")
+	}
+	s.evidence = nil
+	for _, e := range store.Evidence {
+		if err := e.Verify(); err != nil {
+			log.Lvl2("Dropping invalid evidence:", err)
+			continue
+		}
+		s.evidence = append(s.evidence, e)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package skipchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+func TestService_CheckFork(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	servers, roster, s := makeHELS(local, 3)
+	services := make([]*Service, len(servers))
+	for i, srv := range local.GetServices(servers, skipchainSID) {
+		services[i] = srv.(*Service)
+	}
+	genesis, err := makeGenesisRosterArgs(s, roster, nil, VerificationNone, 1, 1)
+	log.ErrFatal(err)
+	sb := NewSkipBlock()
+	sb.Roster = roster
+	ssbr, cerr := s.StoreSkipBlock(&StoreSkipBlock{genesis.Hash, sb, nil})
+	log.ErrFatal(cerr)
+	waitPropagationFinished(local)
+	first := ssbr.Previous
+
+	log.Lvl1("Making the roster sign a second successor of the genesis-block")
+	for _, srv := range services {
+		gen := srv.Sbm.GetByID(genesis.Hash)
+		gen.ForwardLink = nil
+		log.ErrFatal(srv.Sbm.Remove(gen.Hash))
+		srv.Sbm.Store(gen)
+	}
+	sb = NewSkipBlock()
+	sb.Roster = roster
+	sb.Data = []byte{1}
+	ssbr, cerr = s.StoreSkipBlock(&StoreSkipBlock{genesis.Hash, sb, nil})
+	log.ErrFatal(cerr)
+	waitPropagationFinished(local)
+	second := ssbr.Previous
+	require.False(t, first.ForwardLink[0].Hash.Equal(second.ForwardLink[0].Hash))
+
+	other := services[1]
+	reply, cerr := other.GetEquivocations(&GetEquivocations{})
+	log.ErrFatal(cerr)
+	require.Equal(t, 0, len(reply.Evidence))
+
+	log.Lvl1("Receiving the first version of the genesis-block")
+	other.propagateSkipBlock(&PropagateSkipBlocks{[]*SkipBlock{first}})
+	gen := other.Sbm.GetByID(genesis.Hash)
+	require.True(t, gen.ForwardLink[0].Hash.Equal(second.ForwardLink[0].Hash))
+
+	reply, cerr = other.GetEquivocations(&GetEquivocations{genesis.Hash})
+	log.ErrFatal(cerr)
+	require.Equal(t, 1, len(reply.Evidence))
+	e := reply.Evidence[0]
+	log.ErrFatal(e.Verify())
+	require.Equal(t, 0, e.Height)
+
+	log.Lvl1("Refusing wrong evidence")
+	wrong := *e
+	wrong.Second = wrong.First
+	require.NotNil(t, wrong.Verify())
+	wrong = *e
+	wrong.SecondTarget = nil
+	require.NotNil(t, wrong.Verify())
+
+	log.Lvl1("Refusing a link of the same roster to a block not following")
+	sb = NewSkipBlock()
+	sb.Roster = roster
+	ssbr, cerr = s.StoreSkipBlock(&StoreSkipBlock{second.ForwardLink[0].Hash, sb, nil})
+	log.ErrFatal(cerr)
+	waitPropagationFinished(local)
+	wrong = *e
+	wrong.Second = ssbr.Previous.ForwardLink[0]
+	wrong.SecondTarget = ssbr.Latest
+	require.Nil(t, wrong.Second.VerifySignature(roster.Publics()))
+	require.NotNil(t, wrong.Verify())
+
+	log.Lvl1("Evidence is recorded only once")
+	other.propagateSkipBlock(&PropagateSkipBlocks{[]*SkipBlock{first}})
+	reply, cerr = other.GetEquivocations(&GetEquivocations{})
+	log.ErrFatal(cerr)
+	require.Equal(t, 1, len(reply.Evidence))
+}
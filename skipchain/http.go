@@ -0,0 +1,360 @@
+package skipchain
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+/*
+This file holds a read-only HTTP-gateway to the skipchain-service, so that
+web-frontends can read skipchains without speaking the onet-protocol. All
+answers are JSON, and all IDs are hex-encoded. The following endpoints are
+available:
+
+	GET /skipchains                 - the genesis- and latest block of all chains
+	GET /block/<id>                 - the block with the given id
+	GET /skipchain/<genesis>/<index> - the block with the given index
+	GET /update/<id>                - the update-chain from the given block
+	GET /proof/<genesis>            - the proof from the genesis- to the latest block
+*/
+
+// httpAddress is the address the HTTP-gateway of new services listens on.
+var httpAddress string
+
+// EnableHTTP makes all skipchain-services created afterwards start their
+// HTTP-gateway on the given address, e.g. "localhost:7003". It must be
+// called before the conode is started.
+func EnableHTTP(address string) {
+	httpAddress = address
+}
+
+// JSONBlock is the JSON-representation of a SkipBlock.
+type JSONBlock struct {
+	Hash          string
+	Index         int
+	Height        int
+	MaximumHeight int
+	BaseHeight    int
+	GenesisID     string
+	ParentBlockID string
+	Timestamp     int64
+	BackLinks     []string
+	ForwardLinks  []JSONLink
+	Children      []string
+	Roster        []JSONServer
+	Data          string
+}
+
+// JSONLink is the JSON-representation of a BlockLink.
+type JSONLink struct {
+	Hash      string
+	Signature string
+	Refused   []int
+}
+
+// JSONServer is the JSON-representation of a member of a roster.
+type JSONServer struct {
+	Address     string
+	Public      string
+	Description string
+}
+
+// JSONChain holds the genesis- and the latest known block of a skipchain.
+type JSONChain struct {
+	Genesis *JSONBlock
+	Latest  *JSONBlock
+}
+
+// newJSONBlock converts the block to its JSON-representation.
+func newJSONBlock(sb *SkipBlock) *JSONBlock {
+	jb := &JSONBlock{
+		Hash:          hex.EncodeToString(sb.Hash),
+		Index:         sb.Index,
+		Height:        sb.Height,
+		MaximumHeight: sb.MaximumHeight,
+		BaseHeight:    sb.BaseHeight,
+		GenesisID:     hex.EncodeToString(sb.SkipChainID()),
+		ParentBlockID: hex.EncodeToString(sb.ParentBlockID),
+		Timestamp:     sb.Timestamp,
+		BackLinks:     hexIDs(sb.BackLinkIDs),
+		ForwardLinks:  []JSONLink{},
+		Children:      hexIDs(sb.ChildSL),
+		Roster:        []JSONServer{},
+		Data:          hex.EncodeToString(sb.Data),
+	}
+	for _, fl := range sb.ForwardLink {
+		jl := JSONLink{
+			Hash:      hex.EncodeToString(fl.Hash),
+			Signature: hex.EncodeToString(fl.Signature),
+		}
+		if sb.Roster != nil {
+			jl.Refused = fl.Refused(sb.Roster.Publics())
+		}
+		jb.ForwardLinks = append(jb.ForwardLinks, jl)
+	}
+	if sb.Roster != nil {
+		for _, si := range sb.Roster.List {
+			pub, err := si.Public.MarshalBinary()
+			if err != nil {
+				log.Error(err)
+			}
+			jb.Roster = append(jb.Roster, JSONServer{
+				Address:     string(si.Address),
+				Public:      hex.EncodeToString(pub),
+				Description: si.Description,
+			})
+		}
+	}
+	return jb
+}
+
+// newJSONBlocks converts all blocks to their JSON-representation.
+func newJSONBlocks(sbs []*SkipBlock) []*JSONBlock {
+	jbs := make([]*JSONBlock, len(sbs))
+	for i, sb := range sbs {
+		jbs[i] = newJSONBlock(sb)
+	}
+	return jbs
+}
+
+// hexIDs returns the hex-encoded IDs.
+func hexIDs(ids []SkipBlockID) []string {
+	strs := make([]string, len(ids))
+	for i, id := range ids {
+		strs[i] = hex.EncodeToString(id)
+	}
+	return strs
+}
+
+// StartHTTP starts the HTTP-gateway on the given address. It returns once
+// the gateway listens for requests.
+func (s *Service) StartHTTP(address string) error {
+	s.httpMutex.Lock()
+	defer s.httpMutex.Unlock()
+	if s.httpListener != nil {
+		return errors.New("HTTP-gateway is already running")
+	}
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	s.httpListener = l
+	log.Lvl2(s.ServerIdentity(), "serves HTTP on", l.Addr())
+	go func() {
+		err := http.Serve(l, s.HTTPHandler())
+		log.Lvl3("HTTP-gateway stopped:", err)
+	}()
+	return nil
+}
+
+// StopHTTP stops the HTTP-gateway, if it is running.
+func (s *Service) StopHTTP() error {
+	s.httpMutex.Lock()
+	defer s.httpMutex.Unlock()
+	if s.httpListener == nil {
+		return nil
+	}
+	err := s.httpListener.Close()
+	s.httpListener = nil
+	return err
+}
+
+// HTTPAddress returns the address the HTTP-gateway listens on, or an empty
+// string if it is not running.
+func (s *Service) HTTPAddress() string {
+	s.httpMutex.Lock()
+	defer s.httpMutex.Unlock()
+	if s.httpListener == nil {
+		return ""
+	}
+	return s.httpListener.Addr().String()
+}
+
+// HTTPHandler returns the handler of the read-only HTTP-gateway.
+func (s *Service) HTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/skipchains", s.httpSkipchains)
+	mux.HandleFunc("/block/", s.httpBlock)
+	mux.HandleFunc("/skipchain/", s.httpBlockByIndex)
+	mux.HandleFunc("/update/", s.httpUpdate)
+	mux.HandleFunc("/proof/", s.httpProof)
+	return mux
+}
+
+// httpSkipchains returns the genesis- and latest block of all skipchains.
+func (s *Service) httpSkipchains(w http.ResponseWriter, r *http.Request) {
+	if !httpGet(w, r) {
+		return
+	}
+	reply, cerr := s.GetAllSkipchains(&GetAllSkipchains{})
+	if cerr != nil {
+		httpError(w, cerr)
+		return
+	}
+	chains := []*JSONChain{}
+	for _, sb := range reply.SkipChains {
+		genesis := s.Sbm.GetByID(sb.SkipChainID())
+		if genesis == nil {
+			continue
+		}
+		latest, err := s.Sbm.GetLatest(genesis)
+		if err != nil {
+			latest = genesis
+		}
+		chains = append(chains, &JSONChain{newJSONBlock(genesis),
+			newJSONBlock(latest)})
+	}
+	httpReply(w, chains)
+}
+
+// httpBlock returns the block with the id given in the path.
+func (s *Service) httpBlock(w http.ResponseWriter, r *http.Request) {
+	if !httpGet(w, r) {
+		return
+	}
+	args, ok := httpArgs(w, r, "/block/", 1)
+	if !ok {
+		return
+	}
+	id, ok := httpID(w, args[0])
+	if !ok {
+		return
+	}
+	sb, cerr := s.GetSingleBlock(&GetSingleBlock{id})
+	if cerr != nil {
+		httpError(w, cerr)
+		return
+	}
+	httpReply(w, newJSONBlock(sb))
+}
+
+// httpBlockByIndex returns the block of the skipchain with the index given
+// in the path.
+func (s *Service) httpBlockByIndex(w http.ResponseWriter, r *http.Request) {
+	if !httpGet(w, r) {
+		return
+	}
+	args, ok := httpArgs(w, r, "/skipchain/", 2)
+	if !ok {
+		return
+	}
+	genesis, ok := httpID(w, args[0])
+	if !ok {
+		return
+	}
+	index, err := strconv.Atoi(args[1])
+	if err != nil || index < 0 {
+		http.Error(w, "Invalid index", http.StatusBadRequest)
+		return
+	}
+	sb, cerr := s.GetSingleBlockByIndex(&GetSingleBlockByIndex{genesis, index})
+	if cerr != nil {
+		httpError(w, cerr)
+		return
+	}
+	httpReply(w, newJSONBlock(sb))
+}
+
+// httpUpdate returns the update-chain from the block given in the path to
+// the latest block.
+func (s *Service) httpUpdate(w http.ResponseWriter, r *http.Request) {
+	if !httpGet(w, r) {
+		return
+	}
+	args, ok := httpArgs(w, r, "/update/", 1)
+	if !ok {
+		return
+	}
+	id, ok := httpID(w, args[0])
+	if !ok {
+		return
+	}
+	reply, cerr := s.GetUpdateChain(&GetUpdateChain{id})
+	if cerr != nil {
+		httpError(w, cerr)
+		return
+	}
+	httpReply(w, newJSONBlocks(reply.(*GetUpdateChainReply).Update))
+}
+
+// httpProof returns the proof from the genesis-block given in the path to
+// the latest block.
+func (s *Service) httpProof(w http.ResponseWriter, r *http.Request) {
+	if !httpGet(w, r) {
+		return
+	}
+	args, ok := httpArgs(w, r, "/proof/", 1)
+	if !ok {
+		return
+	}
+	id, ok := httpID(w, args[0])
+	if !ok {
+		return
+	}
+	reply, cerr := s.GetProof(&GetProof{id})
+	if cerr != nil {
+		httpError(w, cerr)
+		return
+	}
+	httpReply(w, newJSONBlocks(reply.Proof))
+}
+
+// httpGet makes sure the request is a GET-request.
+func httpGet(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET is supported", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+// httpArgs returns the n arguments of the path following the prefix.
+func httpArgs(w http.ResponseWriter, r *http.Request, prefix string, n int) ([]string, bool) {
+	args := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/"), "/")
+	if len(args) != n || args[0] == "" {
+		http.Error(w, "Wrong number of arguments", http.StatusBadRequest)
+		return nil, false
+	}
+	return args, true
+}
+
+// httpID decodes the hex-encoded id.
+func httpID(w http.ResponseWriter, str string) (SkipBlockID, bool) {
+	id, err := hex.DecodeString(str)
+	if err != nil || len(id) == 0 {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return nil, false
+	}
+	return SkipBlockID(id), true
+}
+
+// httpError writes the error of the service with a matching status.
+func httpError(w http.ResponseWriter, cerr onet.ClientError) {
+	status := http.StatusInternalServerError
+	switch cerr.ErrorCode() {
+	case ErrorBlockNotFound, ErrorBlockNoParent:
+		status = http.StatusNotFound
+	case ErrorParameterWrong:
+		status = http.StatusBadRequest
+	}
+	http.Error(w, cerr.Error(), status)
+}
+
+// httpReply writes the reply as JSON.
+func httpReply(w http.ResponseWriter, reply interface{}) {
+	buf, err := json.Marshal(reply)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf)
+}
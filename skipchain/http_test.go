@@ -0,0 +1,83 @@
+package skipchain
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+func TestService_HTTP(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, roster, s := makeHELS(local, 3)
+	genesis, err := makeGenesisRosterArgs(s, roster, nil, VerificationNone, 2, 2)
+	log.ErrFatal(err)
+	latest := genesis
+	for i := 1; i < 5; i++ {
+		sb := NewSkipBlock()
+		sb.Roster = roster
+		sb.Data = []byte{byte(i)}
+		ssbr, cerr := s.StoreSkipBlock(&StoreSkipBlock{latest.Hash, sb, nil})
+		log.ErrFatal(cerr)
+		latest = ssbr.Latest
+	}
+	waitPropagationFinished(local)
+
+	log.ErrFatal(s.StartHTTP("localhost:0"))
+	defer s.StopHTTP()
+	require.NotNil(t, s.StartHTTP("localhost:0"))
+	url := "http://" + s.HTTPAddress()
+	get := func(path string, reply interface{}) int {
+		resp, err := http.Get(url + path)
+		log.ErrFatal(err)
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			log.ErrFatal(json.NewDecoder(resp.Body).Decode(reply))
+		}
+		return resp.StatusCode
+	}
+	genesisHex := hex.EncodeToString(genesis.Hash)
+	latestHex := hex.EncodeToString(latest.Hash)
+
+	var chains []*JSONChain
+	require.Equal(t, http.StatusOK, get("/skipchains", &chains))
+	require.Equal(t, 1, len(chains))
+	require.Equal(t, genesisHex, chains[0].Genesis.Hash)
+	require.Equal(t, latestHex, chains[0].Latest.Hash)
+
+	var block JSONBlock
+	require.Equal(t, http.StatusOK, get("/block/"+latestHex, &block))
+	require.Equal(t, latest.Index, block.Index)
+	require.Equal(t, genesisHex, block.GenesisID)
+	require.Equal(t, hex.EncodeToString(latest.Data), block.Data)
+	require.Equal(t, len(roster.List), len(block.Roster))
+
+	require.Equal(t, http.StatusOK, get("/skipchain/"+genesisHex+"/2", &block))
+	require.Equal(t, 2, block.Index)
+	require.Equal(t, 1, len(block.ForwardLinks))
+
+	var blocks []*JSONBlock
+	require.Equal(t, http.StatusOK, get("/update/"+genesisHex, &blocks))
+	require.Equal(t, latestHex, blocks[len(blocks)-1].Hash)
+	require.Equal(t, http.StatusOK, get("/proof/"+genesisHex, &blocks))
+	require.Equal(t, genesisHex, blocks[0].Hash)
+	require.Equal(t, latestHex, blocks[len(blocks)-1].Hash)
+
+	log.Lvl1("Refusing wrong requests")
+	require.Equal(t, http.StatusNotFound, get("/block/1234", &block))
+	require.Equal(t, http.StatusBadRequest, get("/block/xyz", &block))
+	require.Equal(t, http.StatusBadRequest, get("/skipchain/"+genesisHex, &block))
+	require.Equal(t, http.StatusBadRequest, get("/skipchain/"+genesisHex+"/-1", &block))
+	resp, err := http.Post(url+"/skipchains", "application/json", nil)
+	log.ErrFatal(err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	log.ErrFatal(s.StopHTTP())
+	require.Equal(t, "", s.HTTPAddress())
+}
@@ -0,0 +1,121 @@
+package skipchain
+
+import (
+	"errors"
+	"sort"
+
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+/*
+This file holds the key/value-state of skipchains whose blocks hold
+SkipBlockData. Every block sets or deletes some keys, and the service keeps
+all versions of all keys, so that the value of a key can be returned for any
+block of the skipchain. The state is kept in memory and updated whenever a
+new block is stored. Blocks that are pruned before the state has been built,
+for example after a restart, are missing from the state.
+*/
+
+// kvVersion is the value of a key written by one block.
+type kvVersion struct {
+	index   int
+	block   SkipBlockID
+	data    []byte
+	deleted bool
+}
+
+// kvState holds all versions of all keys of one skipchain, each slice
+// ordered by the index of the blocks.
+type kvState struct {
+	keys map[string][]kvVersion
+	// last is the id of the last block applied to the state.
+	last SkipBlockID
+}
+
+// GetValue returns the value of the key as it was after the block with the
+// given index, together with the block that wrote this value. If the index
+// is negative, the latest value is returned. The block is nil if it has
+// been pruned.
+func (s *Service) GetValue(req *GetValue) (*GetValueReply, onet.ClientError) {
+	if err := s.updateState(req.Genesis); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound, err.Error())
+	}
+	s.stateMutex.Lock()
+	v := s.state[string(req.Genesis)].get(req.Key, req.Index)
+	s.stateMutex.Unlock()
+	if v == nil || v.deleted {
+		return nil, onet.NewClientErrorCode(ErrorKeyNotFound,
+			"Key not set at this index")
+	}
+	return &GetValueReply{
+		Value: v.data,
+		Block: s.Sbm.GetByID(v.block),
+	}, nil
+}
+
+// updateState applies all blocks of the skipchain following the last
+// applied block to the state of the skipchain.
+func (s *Service) updateState(genesisID SkipBlockID) error {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	st, ok := s.state[string(genesisID)]
+	if !ok {
+		genesis := s.Sbm.GetByID(genesisID)
+		if genesis == nil || genesis.Index != 0 {
+			return errors.New("Didn't find genesis-block")
+		}
+		st = &kvState{keys: map[string][]kvVersion{}}
+		st.apply(genesis)
+		s.state[string(genesisID)] = st
+	}
+	last := s.Sbm.GetByID(st.last)
+	if last == nil {
+		return errors.New("Lost last block of state")
+	}
+	for _, sb := range s.Sbm.blocksAfter(last) {
+		st.apply(sb)
+	}
+	return nil
+}
+
+// apply adds the changes of the block to the state.
+func (st *kvState) apply(sb *SkipBlock) {
+	st.last = sb.Hash
+	_, msg, err := network.Unmarshal(sb.Data)
+	if err != nil {
+		return
+	}
+	data, ok := msg.(*SkipBlockData)
+	if !ok {
+		return
+	}
+	log.Lvl4("Applying", len(data.Entries), "entries of block", sb.Index)
+	for _, e := range data.Entries {
+		st.keys[e.Key] = append(st.keys[e.Key], kvVersion{
+			index:   sb.Index,
+			block:   sb.Hash,
+			data:    e.Data,
+			deleted: e.Deleted,
+		})
+	}
+}
+
+// get returns the last version of the key written by a block with an index
+// smaller or equal to the given index, or the latest version if the index
+// is negative. It returns nil if the key has never been written up to that
+// index.
+func (st *kvState) get(key string, index int) *kvVersion {
+	versions := st.keys[key]
+	n := len(versions)
+	if index >= 0 {
+		n = sort.Search(len(versions), func(i int) bool {
+			return versions[i].index > index
+		})
+	}
+	if n == 0 {
+		return nil
+	}
+	return &versions[n-1]
+}
@@ -0,0 +1,69 @@
+package skipchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func TestService_GetValue(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, roster, s := makeHELS(local, 3)
+	genesis, err := makeGenesisRosterArgs(s, roster, nil, VerificationNone, 2, 2)
+	log.ErrFatal(err)
+	blocks := []*SkipBlock{genesis}
+	store := func(data *SkipBlockData) {
+		buf, err := network.Marshal(data)
+		log.ErrFatal(err)
+		sb := NewSkipBlock()
+		sb.Roster = roster
+		sb.Data = buf
+		ssbr, cerr := s.StoreSkipBlock(&StoreSkipBlock{blocks[len(blocks)-1].Hash, sb, nil})
+		log.ErrFatal(cerr)
+		blocks = append(blocks, ssbr.Latest)
+	}
+	data := &SkipBlockData{}
+	data.Set("a", []byte("a1"))
+	data.Set("b", []byte("b1"))
+	store(data)
+	data = &SkipBlockData{}
+	data.Set("a", []byte("a2"))
+	store(data)
+	data = &SkipBlockData{}
+	data.Delete("b")
+	store(data)
+
+	get := func(key string, index int) (*GetValueReply, error) {
+		reply, cerr := s.GetValue(&GetValue{genesis.Hash, key, index})
+		if cerr != nil {
+			return nil, cerr
+		}
+		return reply, nil
+	}
+	reply, err := get("a", -1)
+	log.ErrFatal(err)
+	require.Equal(t, []byte("a2"), reply.Value)
+	require.True(t, reply.Block.Equal(blocks[2]))
+
+	reply, err = get("a", 1)
+	log.ErrFatal(err)
+	require.Equal(t, []byte("a1"), reply.Value)
+	require.True(t, reply.Block.Equal(blocks[1]))
+
+	reply, err = get("b", 2)
+	log.ErrFatal(err)
+	require.Equal(t, []byte("b1"), reply.Value)
+	require.True(t, reply.Block.Equal(blocks[1]))
+
+	_, err = get("b", -1)
+	require.NotNil(t, err, "Deleted key should not be found")
+	_, err = get("a", 0)
+	require.NotNil(t, err, "Key wasn't set in genesis-block")
+	_, err = get("c", -1)
+	require.NotNil(t, err)
+	waitPropagationFinished(local)
+}
@@ -1,6 +1,9 @@
 package skipchain
 
-import "gopkg.in/dedis/onet.v1/network"
+import (
+	"gopkg.in/dedis/onet.v1/crypto"
+	"gopkg.in/dedis/onet.v1/network"
+)
 
 func init() {
 	for _, m := range []interface{}{
@@ -16,6 +19,30 @@ func init() {
 		// Fetch all skipchains
 		&GetAllSkipchains{},
 		&GetAllSkipchainsReply{},
+		// Request a proof of the latest block
+		&GetProof{},
+		&GetProofReply{},
+		// Wait for new blocks
+		&SubscribeSkipChain{},
+		&SubscribeSkipChainReply{},
+		// Search blocks
+		&SearchSkipChain{},
+		&SearchSkipChainReply{},
+		// Get a value of the key/value-state
+		&GetValue{},
+		&GetValueReply{},
+		// Get evidence of forks
+		&GetEquivocations{},
+		&GetEquivocationsReply{},
+		// Store a transaction in a batch
+		&StoreTransaction{},
+		&StoreTransactionReply{},
+		// Get the participation of conodes in signatures
+		&GetParticipation{},
+		&GetParticipationReply{},
+		// Export a whole skipchain
+		&ExportSkipChain{},
+		&ExportSkipChainReply{},
 		// - Internal calls
 		// Propagation
 		&PropagateSkipBlocks{},
@@ -25,9 +52,18 @@ func init() {
 		&GetBlock{},
 		// Reply with updated block
 		&GetBlockReply{},
+		// Request missing blocks of a skipchain
+		&SyncChain{},
+		// Reply with missing blocks
+		&SyncChainReply{},
+		// Ask the roster to start the timer of the views
+		&ViewChange{},
 		// - Data structures
 		&SkipBlockFix{},
 		&SkipBlock{},
+		&SkipBlockData{},
+		&SkipBlockBatch{},
+		&RootData{},
 		// Own service
 		&Service{},
 	} {
@@ -43,9 +79,12 @@ func init() {
 // StoreSkipBlock - Requests a new skipblock to be appended to
 // the given SkipBlock. If the given SkipBlock has Index 0 (which
 // is invalid), a new SkipChain will be created.
+// If the skipchain has ClientKeys, the Signature must be a schnorr-signature
+// on NewBlock.ClientHash(LatestID) by one of these keys.
 type StoreSkipBlock struct {
-	LatestID SkipBlockID
-	NewBlock *SkipBlock
+	LatestID  SkipBlockID
+	NewBlock  *SkipBlock
+	Signature *crypto.SchnorrSig
 }
 
 // StoreSkipBlockReply - returns the signed SkipBlock with updated backlinks
@@ -76,6 +115,111 @@ type GetAllSkipchainsReply struct {
 	SkipChains []*SkipBlock
 }
 
+// GetProof - the client sends the id of the genesis-block and gets a proof
+// for the latest block of that skipchain.
+type GetProof struct {
+	Genesis SkipBlockID
+}
+
+// GetProofReply - returns the proof going from the genesis-block to the
+// latest block.
+type GetProofReply struct {
+	Proof Proof
+}
+
+// SubscribeSkipChain - the client sends the id of the genesis-block and of
+// the last block it knows and waits up to Timeout msecs for new blocks.
+type SubscribeSkipChain struct {
+	Genesis SkipBlockID
+	From    SkipBlockID
+	Timeout int
+}
+
+// SubscribeSkipChainReply - returns all new blocks following From, or an
+// empty list if there was no new block before the timeout.
+type SubscribeSkipChainReply struct {
+	Blocks []*SkipBlock
+}
+
+// SearchSkipChain - the client asks for all blocks of a skipchain with a
+// timestamp between Start and End, in nanoseconds since the epoch, and that
+// set Key in their SkipBlockData. An empty Key matches all blocks and an End
+// of 0 doesn't limit the range.
+type SearchSkipChain struct {
+	Genesis SkipBlockID
+	Key     string
+	Start   int64
+	End     int64
+}
+
+// SearchSkipChainReply - returns the matching blocks, ordered by index.
+type SearchSkipChainReply struct {
+	Blocks []*SkipBlock
+}
+
+// GetValue - the client asks for the value of Key in the key/value-state of
+// the skipchain after the block at Index. A negative Index asks for the
+// latest value.
+type GetValue struct {
+	Genesis SkipBlockID
+	Key     string
+	Index   int
+}
+
+// GetValueReply - returns the value and the block that wrote it.
+type GetValueReply struct {
+	Value []byte
+	Block *SkipBlock
+}
+
+// GetEquivocations - the client asks for the evidence of forks in the
+// skipchain, or in all skipchains if Genesis is nil.
+type GetEquivocations struct {
+	Genesis SkipBlockID
+}
+
+// GetEquivocationsReply - returns the evidence found by the conode.
+type GetEquivocationsReply struct {
+	Evidence []*Equivocation
+}
+
+// StoreTransaction - the client asks the leader to store the data in the
+// next batch of transactions of the skipchain.
+type StoreTransaction struct {
+	Genesis SkipBlockID
+	Data    []byte
+}
+
+// StoreTransactionReply - returns the block holding the transaction and the
+// position of the transaction in the SkipBlockBatch of the block.
+type StoreTransactionReply struct {
+	Block    SkipBlockID
+	Index    int
+	Position int
+}
+
+// GetParticipation - the client asks how often the conodes signed the
+// forward-links of the skipchain.
+type GetParticipation struct {
+	Genesis SkipBlockID
+}
+
+// GetParticipationReply - returns the participation of every conode.
+type GetParticipationReply struct {
+	Participation []*Participation
+}
+
+// ExportSkipChain asks for all blocks of the skipchain.
+type ExportSkipChain struct {
+	Genesis SkipBlockID
+}
+
+// ExportSkipChainReply returns all blocks of the skipchain known to the
+// conode, in order and starting with the genesis-block.
+type ExportSkipChainReply struct {
+	Blocks []*SkipBlock
+}
+
 // Internal calls
 
 // PropagateSkipBlocks sends a newly signed SkipBlock to all members of
@@ -129,3 +273,24 @@ type PropagateSkipBlock struct {
 type GetBlockReply struct {
 	SkipBlock *SkipBlock
 }
+
+// SyncChain asks for all blocks of a skipchain following From, or for all
+// blocks if From is nil. It is sent by a conode that joined the roster of
+// the skipchain.
+type SyncChain struct {
+	Genesis SkipBlockID
+	From    SkipBlockID
+}
+
+// SyncChainReply returns the requested blocks in order.
+type SyncChainReply struct {
+	Genesis SkipBlockID
+	Blocks  []*SkipBlock
+}
+
+// ViewChange is sent by a member of the roster that got a request to append
+// a block to Previous without being the leader of the first view. The
+// receiving members start their timers of the views of Previous.
+type ViewChange struct {
+	Previous SkipBlockID
+}
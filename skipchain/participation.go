@@ -0,0 +1,80 @@
+package skipchain
+
+import (
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+/*
+This file holds the statistics about the participation of the conodes in the
+signatures of the forward-links of a skipchain. Conodes that are often absent
+or refuse to sign can be replaced before the roster loses the 2/3 needed to
+sign new blocks.
+*/
+
+// Participation counts how often a conode took part in the signatures of
+// the forward-links of a skipchain.
+type Participation struct {
+	ServerIdentity *network.ServerIdentity
+	// Links is the number of forward-links the conode had to sign.
+	Links int
+	// Signed is the number of forward-links the conode signed.
+	Signed int
+	// Absent is the number of forward-links the conode didn't sign.
+	Absent int
+	// Refused is the number of forward-links the conode refused to sign in
+	// the prepare phase of the BFT-round.
+	Refused int
+}
+
+// GetParticipation returns the participation of all conodes in the
+// forward-links of the known blocks of the skipchain, in the order the
+// conodes appear in the rosters.
+func (s *Service) GetParticipation(req *GetParticipation) (*GetParticipationReply, onet.ClientError) {
+	genesis := s.Sbm.GetByID(req.Genesis)
+	if genesis == nil || genesis.Index != 0 {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			"Didn't find genesis-block")
+	}
+	reply := &GetParticipationReply{}
+	stats := map[network.ServerIdentityID]*Participation{}
+	get := func(si *network.ServerIdentity) *Participation {
+		p, ok := stats[si.ID]
+		if !ok {
+			p = &Participation{ServerIdentity: si}
+			stats[si.ID] = p
+			reply.Participation = append(reply.Participation, p)
+		}
+		return p
+	}
+	for _, sb := range append([]*SkipBlock{genesis}, s.Sbm.blocksAfter(genesis)...) {
+		n := len(sb.Roster.List)
+		for _, fl := range sb.ForwardLink {
+			absent, err := fl.Absent(n)
+			if err != nil {
+				log.Lvl2("Skipping forward-link of block", sb.Index, ":", err)
+				continue
+			}
+			missing := make([]bool, n)
+			for _, i := range absent {
+				if i >= 0 && i < n {
+					missing[i] = true
+				}
+			}
+			for i, si := range sb.Roster.List {
+				p := get(si)
+				p.Links++
+				if missing[i] {
+					p.Absent++
+				} else {
+					p.Signed++
+				}
+			}
+			for _, i := range fl.Refused(sb.Roster.Publics()) {
+				get(sb.Roster.List[i]).Refused++
+			}
+		}
+	}
+	return reply, nil
+}
@@ -0,0 +1,71 @@
+package skipchain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// refuseVerifier refuses all blocks if refuse is true.
+type refuseVerifier struct {
+	refuse bool
+}
+
+func (rv *refuseVerifier) VerifyBlock(newSB *SkipBlock, ctx *VerifierContext) error {
+	if rv.refuse {
+		return errors.New("refusing everything")
+	}
+	return nil
+}
+
+func TestService_GetParticipation(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	servers, roster, s := makeHELS(local, 4)
+	verifyID := VerifierID(uuid.NewV5(uuid.NamespaceURL, "TestRefuse"))
+	for i, srv := range local.GetServices(servers, skipchainSID) {
+		log.ErrFatal(srv.(*Service).registerBlockVerifier(verifyID,
+			&refuseVerifier{i == 3}))
+	}
+	genesis, err := makeGenesisRosterArgs(s, roster, nil, []VerifierID{verifyID}, 1, 1)
+	log.ErrFatal(err)
+	latest := genesis
+	nbrLinks := 2
+	for i := 0; i < nbrLinks; i++ {
+		sb := NewSkipBlock()
+		sb.Roster = roster
+		ssbr, cerr := s.StoreSkipBlock(&StoreSkipBlock{latest.Hash, sb, nil})
+		log.ErrFatal(cerr)
+		latest = ssbr.Latest
+		fl := ssbr.Previous.ForwardLink[0]
+		require.Equal(t, []int{3}, fl.Refused(roster.Publics()))
+		require.Equal(t, "refusing everything", fl.Refusals[0].Refusal.Reason.Message)
+		// A refusal that isn't signed by its member is not counted.
+		forged := fl.Copy()
+		forged.Refusals = append(forged.Refusals, LinkRefusal{0, fl.Refusals[0].Refusal})
+		require.Equal(t, []int{3}, forged.Refused(roster.Publics()))
+	}
+	waitPropagationFinished(local)
+
+	reply, cerr := s.GetParticipation(&GetParticipation{genesis.Hash})
+	log.ErrFatal(cerr)
+	require.Equal(t, len(roster.List), len(reply.Participation))
+	for i, p := range reply.Participation {
+		require.True(t, p.ServerIdentity.Equal(roster.List[i]))
+		require.Equal(t, nbrLinks, p.Links)
+		require.Equal(t, nbrLinks, p.Signed)
+		require.Equal(t, 0, p.Absent)
+		if i == 3 {
+			require.Equal(t, nbrLinks, p.Refused)
+		} else {
+			require.Equal(t, 0, p.Refused)
+		}
+	}
+
+	_, cerr = s.GetParticipation(&GetParticipation{latest.Hash})
+	require.NotNil(t, cerr)
+}
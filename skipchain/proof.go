@@ -0,0 +1,64 @@
+package skipchain
+
+import "errors"
+
+// Proof is a chain of skipblocks going from the genesis-block to the latest
+// block of a skipchain, always following the highest forward-link. Every
+// block except the last one only holds the forward-link to the next block,
+// so that the proof can be verified by somebody who only knows the
+// genesis-id.
+type Proof []*SkipBlock
+
+// Verify makes sure that the proof starts at the genesis-block with the given
+// id and that every block is linked to the next one by a forward-link
+// collectively signed by the roster of that block, with roster changes
+// signed by at least 2/3 of the previous roster. It returns nil if the
+// proof is valid, in which case the last block of the proof can be trusted.
+func (p Proof) Verify(genesisID SkipBlockID) error {
+	if len(p) == 0 {
+		return errors.New("empty proof")
+	}
+	return verifyChain(genesisID, nil, p)
+}
+
+// Latest returns the last block of the proof, or nil if the proof is empty.
+func (p Proof) Latest() *SkipBlock {
+	if len(p) == 0 {
+		return nil
+	}
+	return p[len(p)-1]
+}
+
+// newProof returns the proof for the given update-chain, which has to start
+// at the genesis-block. Forward-links that are not needed and the children
+// are removed from the blocks.
+func newProof(update []*SkipBlock) (Proof, error) {
+	if len(update) == 0 || update[0].Index != 0 {
+		return nil, errors.New("update-chain doesn't start at the genesis-block")
+	}
+	p := make(Proof, len(update))
+	for i, sb := range update {
+		p[i] = sb.Copy()
+		p[i].ChildSL = nil
+		p[i].ForwardLink = nil
+		if i < len(update)-1 {
+			link := sb.linkTo(update[i+1].Hash)
+			if link == nil {
+				return nil, errors.New("update-chain has a missing forward-link")
+			}
+			p[i].ForwardLink = []*BlockLink{link.Copy()}
+		}
+	}
+	return p, nil
+}
+
+// linkTo returns the forward-link pointing to the block with the given id,
+// or nil if there is none.
+func (sb *SkipBlock) linkTo(id SkipBlockID) *BlockLink {
+	for _, fl := range sb.ForwardLink {
+		if fl.Hash.Equal(id) {
+			return fl
+		}
+	}
+	return nil
+}
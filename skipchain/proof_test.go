@@ -0,0 +1,87 @@
+package skipchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+func TestClient_GetProof(t *testing.T) {
+	l := onet.NewTCPTest()
+	_, roster, _ := l.GenTree(3, true)
+	defer l.CloseAll()
+	c := newTestClient(l)
+
+	genesis, cerr := c.CreateGenesis(roster, 2, 2, VerificationStandard, nil, nil)
+	log.ErrFatal(cerr)
+	latest := genesis
+	nbrBlocks := 9
+	for i := 1; i < nbrBlocks; i++ {
+		ssbr, cerr := c.StoreSkipBlock(latest, nil, []byte{byte(i)})
+		log.ErrFatal(cerr)
+		latest = ssbr.Latest
+	}
+
+	proof, cerr := c.GetProof(roster, genesis.Hash)
+	log.ErrFatal(cerr)
+	require.True(t, proof.Latest().Equal(latest))
+	require.True(t, len(proof) < nbrBlocks, "Proof should skip blocks")
+	for _, sb := range proof[:len(proof)-1] {
+		require.Equal(t, 1, len(sb.ForwardLink))
+	}
+	_, cerr = c.GetProof(roster, latest.Hash)
+	require.NotNil(t, cerr, "Proof should start at the genesis-block")
+}
+
+func TestProof_Verify(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, roster, s := makeHELS(local, 3)
+	genesis, err := makeGenesisRosterArgs(s, roster, nil, VerificationNone, 2, 2)
+	log.ErrFatal(err)
+	latest := genesis
+	for i := 1; i < 5; i++ {
+		sb := NewSkipBlock()
+		sb.Roster = roster
+		ssbr, cerr := s.StoreSkipBlock(&StoreSkipBlock{latest.Hash, sb, nil})
+		log.ErrFatal(cerr)
+		latest = ssbr.Latest
+	}
+	reply, cerr := s.GetProof(&GetProof{genesis.Hash})
+	log.ErrFatal(cerr)
+	proof := reply.Proof
+	require.True(t, len(proof) > 2)
+	log.ErrFatal(proof.Verify(genesis.Hash))
+
+	log.Lvl1("Refusing empty proof")
+	require.NotNil(t, Proof{}.Verify(genesis.Hash))
+
+	log.Lvl1("Refusing wrong genesis-id")
+	require.NotNil(t, proof.Verify(latest.Hash))
+
+	log.Lvl1("Refusing changed block")
+	changed := copyProof(proof)
+	changed[1].Data = []byte{1, 2, 3}
+	require.NotNil(t, changed.Verify(genesis.Hash))
+
+	log.Lvl1("Refusing missing block")
+	missing := copyProof(proof)
+	missing = append(missing[:1], missing[2:]...)
+	require.NotNil(t, missing.Verify(genesis.Hash))
+
+	log.Lvl1("Refusing wrong signature")
+	wrongSig := copyProof(proof)
+	wrongSig[0].ForwardLink[0].Signature[0] ^= 0xff
+	require.NotNil(t, wrongSig.Verify(genesis.Hash))
+	waitPropagationFinished(local)
+}
+
+func copyProof(p Proof) Proof {
+	c := make(Proof, len(p))
+	for i, sb := range p {
+		c[i] = sb.Copy()
+	}
+	return c
+}
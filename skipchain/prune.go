@@ -0,0 +1,247 @@
+package skipchain
+
+import (
+	"errors"
+	"sort"
+
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+/*
+This file holds the pruning of skipblocks that are not needed anymore
+following the RetentionPolicy of a skipchain.
+*/
+
+// pruneState holds what the last pruning of a skipchain decided, so that
+// the next pruning only needs to look at the blocks that got added since
+// then and the blocks that were only kept because of their position
+// relative to the latest block.
+type pruneState struct {
+	// latest is the latest block during the last pruning.
+	latest SkipBlockID
+	// temporary holds the kept blocks that may be pruned later on. All
+	// other blocks of the skipchain that were kept are kept forever.
+	temporary []SkipBlockID
+}
+
+// SetRetentionPolicy overrides the retention policy of the genesis-block of
+// the given skipchain on this conode. If genesis is nil, the policy is used
+// for all skipchains that don't define a policy in their genesis-block.
+func (s *Service) SetRetentionPolicy(genesis SkipBlockID, rp RetentionPolicy) {
+	s.pruneMutex.Lock()
+	defer s.pruneMutex.Unlock()
+	if genesis.IsNull() {
+		s.retentionDefault = rp
+		s.pruned = make(map[string]*pruneState)
+		return
+	}
+	s.retention[string(genesis)] = rp
+	delete(s.pruned, string(genesis))
+}
+
+// SetArchive sets the file to which the pruned skipblocks are appended. If
+// filename is empty, pruned skipblocks are dropped.
+func (s *Service) SetArchive(filename string) {
+	s.pruneMutex.Lock()
+	defer s.pruneMutex.Unlock()
+	s.archive = filename
+}
+
+// resetPruning makes the next pruning of the skipchain look at all its
+// blocks, for example because older blocks have been added.
+func (s *Service) resetPruning(genesis SkipBlockID) {
+	s.pruneMutex.Lock()
+	defer s.pruneMutex.Unlock()
+	delete(s.pruned, string(genesis))
+}
+
+// retentionPolicy returns the policy that applies to the skipchain of the
+// genesis-block. The caller must hold pruneMutex.
+func (s *Service) retentionPolicy(genesis *SkipBlock) RetentionPolicy {
+	if rp, ok := s.retention[string(genesis.Hash)]; ok {
+		return rp
+	}
+	if !genesis.Retention.KeepAll() {
+		return genesis.Retention
+	}
+	return s.retentionDefault
+}
+
+// pruneSkipChain removes all blocks of the skipchain that are not kept by
+// its retention policy. The following blocks are always kept:
+//   - the genesis-block and the latest block
+//   - blocks that have children
+//   - the blocks needed to append a new block and add its forward-links
+//   - the blocks on the path of the highest forward-links from a kept block
+//     to the latest block, so that GetUpdateChain works for every kept block
+//
+// Only the blocks added since the last pruning and the blocks that have
+// been kept temporarily are looked at, so every block is handled once
+// unless it is kept because of its position.
+func (s *Service) pruneSkipChain(genesisID SkipBlockID) error {
+	s.pruneMutex.Lock()
+	defer s.pruneMutex.Unlock()
+	genesis := s.Sbm.GetByID(genesisID)
+	if genesis == nil {
+		return errors.New("Didn't find genesis-block")
+	}
+	rp := s.retentionPolicy(genesis)
+	if rp.KeepAll() {
+		return nil
+	}
+	latest, err := s.Sbm.GetLatest(genesis)
+	if err != nil {
+		return err
+	}
+	blocks := s.pruneCandidates(genesis, latest)
+
+	keep := map[string]bool{
+		string(genesis.Hash): true,
+		string(latest.Hash):  true,
+	}
+	// The back-links of the latest block still need their forward-links.
+	for _, id := range latest.BackLinkIDs {
+		keep[string(id)] = true
+	}
+	// The next block will have back-links to the newest block of every
+	// height.
+	pointer := latest
+	for h := 0; h < latest.MaximumHeight; h++ {
+		for pointer.Height <= h {
+			pointer = s.Sbm.GetByID(pointer.BackLinkIDs[len(pointer.BackLinkIDs)-1])
+			if pointer == nil {
+				return errors.New("Missing block in back-links")
+			}
+		}
+		keep[string(pointer.Hash)] = true
+	}
+	for id, sb := range blocks {
+		if rp.keep(sb, latest) || len(sb.ChildSL) > 0 {
+			keep[id] = true
+		}
+	}
+	// Keep the path from every kept block to the latest block. Kept blocks
+	// that are not candidates only need their path checked if it goes
+	// through a candidate, in which case they are in the back-links of
+	// that candidate.
+	var starts []SkipBlockID
+	for id, sb := range blocks {
+		if keep[id] {
+			starts = append(starts, sb.Hash)
+		}
+		starts = append(starts, sb.BackLinkIDs...)
+	}
+	onPath := map[string]bool{}
+	for _, id := range starts {
+		if _, candidate := blocks[string(id)]; candidate && !keep[string(id)] {
+			continue
+		}
+		sb := blocks[string(id)]
+		if sb == nil {
+			sb = s.Sbm.GetByID(id)
+		}
+		for sb != nil && !onPath[string(sb.Hash)] {
+			onPath[string(sb.Hash)] = true
+			keep[string(sb.Hash)] = true
+			if sb.GetForwardLen() == 0 {
+				break
+			}
+			next := sb.ForwardLink[sb.GetForwardLen()-1].Hash
+			if sb = blocks[string(next)]; sb == nil {
+				sb = s.Sbm.GetByID(next)
+			}
+		}
+	}
+
+	state := &pruneState{latest: latest.Hash}
+	var pruned sbIndex
+	for id, sb := range blocks {
+		if !keep[id] {
+			pruned = append(pruned, sb)
+		} else if sb.Index > 0 && len(sb.ChildSL) == 0 && !rp.keepForever(sb) {
+			state.temporary = append(state.temporary, sb.Hash)
+		}
+	}
+	s.pruned[string(genesisID)] = state
+	if len(pruned) == 0 {
+		return nil
+	}
+	sort.Sort(pruned)
+	if s.archive != "" {
+		if err := appendArchive(s.archive, pruned); err != nil {
+			delete(s.pruned, string(genesisID))
+			return errors.New("Couldn't archive skipblocks: " + err.Error())
+		}
+	}
+	log.Lvlf3("%s prunes %d blocks of %x", s.ServerIdentity(), len(pruned),
+		[]byte(genesisID))
+	for _, sb := range pruned {
+		if err := s.Sbm.Remove(sb.Hash); err != nil {
+			delete(s.pruned, string(genesisID))
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneCandidates returns the blocks of the skipchain that may be pruned:
+// the blocks added since the last pruning and the blocks that were kept
+// temporarily. If the skipchain has not been pruned yet, or if the blocks
+// since the last pruning are not all known, all known blocks of the
+// skipchain are returned. The caller must hold pruneMutex.
+func (s *Service) pruneCandidates(genesis, latest *SkipBlock) map[string]*SkipBlock {
+	if state, ok := s.pruned[string(genesis.Hash)]; ok {
+		blocks := map[string]*SkipBlock{}
+		sb := latest
+		for sb != nil && !sb.Hash.Equal(state.latest) && sb.Index > 0 {
+			blocks[string(sb.Hash)] = sb
+			sb = s.Sbm.GetByID(sb.BackLinkIDs[0])
+		}
+		if sb != nil && sb.Hash.Equal(state.latest) {
+			for _, id := range state.temporary {
+				if sb := s.Sbm.GetByID(id); sb != nil {
+					blocks[string(id)] = sb
+				}
+			}
+			return blocks
+		}
+		log.Lvl3("Didn't find blocks since last pruning, looking at all blocks")
+	}
+
+	// Collect all known blocks of the skipchain by following the back-links.
+	blocks := map[string]*SkipBlock{
+		string(genesis.Hash): genesis,
+		string(latest.Hash):  latest,
+	}
+	todo := []*SkipBlock{latest}
+	for len(todo) > 0 {
+		sb := todo[0]
+		todo = todo[1:]
+		if sb.Index == 0 {
+			continue
+		}
+		for _, id := range sb.BackLinkIDs {
+			if _, known := blocks[string(id)]; known {
+				continue
+			}
+			if back := s.Sbm.GetByID(id); back != nil {
+				blocks[string(id)] = back
+				todo = append(todo, back)
+			}
+		}
+	}
+	return blocks
+}
+
+// sbIndex sorts skipblocks by their index.
+type sbIndex []*SkipBlock
+
+func (s sbIndex) Len() int {
+	return len(s)
+}
+func (s sbIndex) Less(i, j int) bool {
+	return s[i].Index < s[j].Index
+}
+func (s sbIndex) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
@@ -0,0 +1,112 @@
+package skipchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+func TestService_PruneKeepLast(t *testing.T) {
+	testPrune(t, RetentionPolicy{KeepLast: 2}, false)
+}
+
+func TestService_PruneMinHeight(t *testing.T) {
+	testPrune(t, RetentionPolicy{MinHeight: 2}, true)
+}
+
+// testPrune creates a skipchain with the given retention policy, either
+// in the genesis-block or as a policy of the conodes, and verifies that the
+// pruned blocks are archived and that the kept blocks still lead to the
+// latest block.
+func testPrune(t *testing.T, rp RetentionPolicy, perConode bool) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	servers, roster, s := makeHELS(local, 3)
+	services := make([]*Service, len(servers))
+	for i, srv := range local.GetServices(servers, skipchainSID) {
+		services[i] = srv.(*Service)
+		if perConode {
+			services[i].SetRetentionPolicy(nil, rp)
+		}
+	}
+	dir, err := ioutil.TempDir("", "skipchain")
+	log.ErrFatal(err)
+	defer os.RemoveAll(dir)
+	archive := path.Join(dir, "archive.bin")
+	s.SetArchive(archive)
+
+	genesis := NewSkipBlock()
+	genesis.Roster = roster
+	genesis.MaximumHeight = 3
+	genesis.BaseHeight = 2
+	if !perConode {
+		genesis.Retention = rp
+	}
+	ssbr, cerr := s.StoreSkipBlock(&StoreSkipBlock{nil, genesis, nil})
+	log.ErrFatal(cerr)
+	genesis = ssbr.Latest
+	latest := genesis
+	nbrBlocks := 17
+	for i := 1; i < nbrBlocks; i++ {
+		sb := NewSkipBlock()
+		sb.Roster = roster
+		ssbr, cerr = s.StoreSkipBlock(&StoreSkipBlock{latest.Hash, sb, nil})
+		log.ErrFatal(cerr)
+		latest = ssbr.Latest
+		checkBacklinks(services, latest)
+	}
+	waitPropagationFinished(local)
+	// Trigger a last pruning once all forward-links are present.
+	log.ErrFatal(s.pruneSkipChain(genesis.Hash))
+
+	archived, err := ReadArchive(archive)
+	log.ErrFatal(err)
+	require.NotEqual(t, 0, len(archived), "No blocks got pruned")
+
+	// Looking at all blocks doesn't prune more than the incremental
+	// pruning did.
+	s.resetPruning(genesis.Hash)
+	log.ErrFatal(s.pruneSkipChain(genesis.Hash))
+	all, err := ReadArchive(archive)
+	log.ErrFatal(err)
+	require.Equal(t, len(archived), len(all))
+	require.Equal(t, nbrBlocks, len(archived)+s.Sbm.Length())
+	for _, sb := range archived {
+		require.Nil(t, s.Sbm.GetByID(sb.Hash))
+	}
+
+	for i := 0; i < nbrBlocks; i++ {
+		sb, cerr := s.GetSingleBlockByIndex(&GetSingleBlockByIndex{genesis.Hash, i})
+		if cerr != nil {
+			continue
+		}
+		reply, cerr := s.GetUpdateChain(&GetUpdateChain{sb.Hash})
+		log.ErrFatal(cerr)
+		update := reply.(*GetUpdateChainReply).Update
+		require.True(t, update[len(update)-1].Equal(latest))
+		for _, up := range update {
+			log.ErrFatal(up.VerifyForwardSignatures())
+		}
+	}
+
+	// Make sure new blocks can still be appended.
+	sb := NewSkipBlock()
+	sb.Roster = roster
+	_, cerr = s.StoreSkipBlock(&StoreSkipBlock{latest.Hash, sb, nil})
+	log.ErrFatal(cerr)
+	waitPropagationFinished(local)
+}
+
+func TestReadBlocksTooBig(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log.ErrFatal(binary.Write(buf, binary.BigEndian, uint32(maxBlockSize+1)))
+	_, err := ReadBlocks(buf)
+	require.NotNil(t, err)
+}
@@ -0,0 +1,120 @@
+package skipchain
+
+import (
+	"errors"
+
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+/*
+This file holds the repair of forward-links of height > 1. These links are
+signed in the background once a new block is stored, and if the roster of
+the target-block isn't reachable at that moment, the link is missing. This
+makes GetUpdateChain fall back to following every single block. After every
+new block, the leader of the roster of such a target-block asks its roster
+again to sign the missing link.
+*/
+
+// repairSkipChain starts repairing the missing forward-links of the
+// skipchain in the background, unless a repair is already running.
+func (s *Service) repairSkipChain(genesis SkipBlockID) {
+	s.repairMutex.Lock()
+	defer s.repairMutex.Unlock()
+	if s.repairing[string(genesis)] {
+		return
+	}
+	s.repairing[string(genesis)] = true
+	go func() {
+		n, err := s.repairForwardLinks(genesis)
+		if err != nil {
+			log.Lvl2("Couldn't repair forward-links:", err)
+		} else if n > 0 {
+			log.Lvl2(s.ServerIdentity(), "repaired", n, "forward-links")
+		}
+		s.repairMutex.Lock()
+		delete(s.repairing, string(genesis))
+		s.repairMutex.Unlock()
+	}()
+}
+
+// repairForwardLinks searches the skipchain for blocks that miss a
+// forward-link of height > 1 where this conode is the leader of the block's
+// roster, and signs these links again. The newest block is left alone, as
+// its forward-links are probably still being signed. It returns how many
+// links have been repaired.
+//
+// All blocks before the first block that still misses a link are remembered
+// as complete, so that the next search starts there.
+func (s *Service) repairForwardLinks(genesisID SkipBlockID) (int, error) {
+	genesis := s.Sbm.GetByID(genesisID)
+	if genesis == nil {
+		return 0, errors.New("Didn't find genesis-block")
+	}
+	s.repairMutex.Lock()
+	start := s.Sbm.GetByID(s.repaired[string(genesisID)])
+	s.repairMutex.Unlock()
+	if start == nil {
+		start = genesis
+	}
+
+	repaired := 0
+	complete := start
+	for _, sb := range s.Sbm.blocksAfter(start) {
+		if sb.GetForwardLen() == 0 {
+			break
+		}
+		missing := false
+		for h := 1; h < len(sb.BackLinkIDs); h++ {
+			ok, err := s.repairForwardLink(sb, h)
+			if err != nil {
+				log.Lvl3("Forward-link still missing:", err)
+				missing = true
+			} else if ok {
+				repaired++
+			}
+		}
+		if !missing && complete.Index+1 == sb.Index {
+			complete = sb
+		}
+	}
+	s.repairMutex.Lock()
+	s.repaired[string(genesisID)] = complete.Hash
+	s.repairMutex.Unlock()
+	return repaired, nil
+}
+
+// repairForwardLink makes sure that the block pointed to by the back-link
+// of the given height of newest has a forward-link to newest. It returns
+// true if the link has been signed again, and an error if it is still
+// missing, for example because another conode is responsible for it.
+func (s *Service) repairForwardLink(newest *SkipBlock, height int) (bool, error) {
+	target := s.Sbm.GetByID(newest.BackLinkIDs[height])
+	if target == nil {
+		// Pruned blocks don't need any forward-links.
+		return false, nil
+	}
+	if target.GetForwardLen() > height {
+		return false, nil
+	}
+	if !target.Roster.Get(0).Equal(s.ServerIdentity()) {
+		return false, errors.New("not leader of target-block")
+	}
+	if target.GetForwardLen() < height {
+		return false, errors.New("lower forward-links of target are missing")
+	}
+	previous := s.Sbm.GetByID(newest.BackLinkIDs[0])
+	if previous == nil {
+		return false, errors.New("Didn't find previous block")
+	}
+	link := previous.GetForward(0)
+	if link == nil || !link.Hash.Equal(newest.Hash) {
+		return false, errors.New("previous block doesn't link to newest")
+	}
+	log.Lvl2("Repairing forward-link of height", height+1, "from",
+		target.Index, "to", newest.Index)
+	if err := s.signForwardLink(&ForwardSignature{height, previous.Hash,
+		newest, link}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
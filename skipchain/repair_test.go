@@ -0,0 +1,57 @@
+package skipchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+func TestService_RepairForwardLinks(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	servers, roster, s := makeHELS(local, 3)
+	services := make([]*Service, len(servers))
+	for i, srv := range local.GetServices(servers, skipchainSID) {
+		services[i] = srv.(*Service)
+	}
+	genesis, err := makeGenesisRosterArgs(s, roster, nil, VerificationNone, 2, 2)
+	log.ErrFatal(err)
+	blocks := []*SkipBlock{genesis}
+	for i := 1; i < 6; i++ {
+		sb := NewSkipBlock()
+		sb.Roster = roster
+		ssbr, cerr := s.StoreSkipBlock(&StoreSkipBlock{blocks[i-1].Hash, sb, nil})
+		log.ErrFatal(cerr)
+		blocks = append(blocks, ssbr.Latest)
+	}
+	waitPropagationFinished(local)
+
+	log.Lvl1("Removing the forward-link from block 2 to block 4")
+	for _, srv := range services {
+		sb := srv.Sbm.GetByID(blocks[2].Hash)
+		require.Equal(t, 2, len(sb.ForwardLink))
+		sb.ForwardLink = sb.ForwardLink[:1]
+		log.ErrFatal(srv.Sbm.Remove(sb.Hash))
+		srv.Sbm.Store(sb)
+		srv.repairMutex.Lock()
+		delete(srv.repaired, string(genesis.Hash))
+		srv.repairMutex.Unlock()
+	}
+
+	n, err := s.repairForwardLinks(genesis.Hash)
+	log.ErrFatal(err)
+	require.Equal(t, 1, n)
+	waitPropagationFinished(local)
+	for _, srv := range services {
+		sb := srv.Sbm.GetByID(blocks[2].Hash)
+		require.Equal(t, 2, len(sb.ForwardLink))
+		require.True(t, sb.ForwardLink[1].Hash.Equal(blocks[4].Hash))
+		log.ErrFatal(sb.VerifyForwardSignatures())
+	}
+
+	n, err = s.repairForwardLinks(genesis.Hash)
+	log.ErrFatal(err)
+	require.Equal(t, 0, n)
+}
@@ -0,0 +1,111 @@
+package skipchain
+
+import (
+	"errors"
+	"sort"
+
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+/*
+This file holds the search of blocks of a skipchain by their timestamp and
+by the keys of their SkipBlockData. Every conode keeps an index per
+skipchain in memory that is built on the first search and extended with the
+new blocks on every following search.
+*/
+
+// blockIndex holds the timestamps and keys of the blocks of one skipchain,
+// ordered by their index.
+type blockIndex struct {
+	entries []indexEntry
+	// last is the id of the last block in the index.
+	last SkipBlockID
+}
+
+// indexEntry describes one block of the index.
+type indexEntry struct {
+	id        SkipBlockID
+	timestamp int64
+	keys      []string
+}
+
+// hasKey returns true if the block set the given key in its SkipBlockData.
+func (ie indexEntry) hasKey(key string) bool {
+	for _, k := range ie.keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// SearchSkipChain returns all blocks of the skipchain with a timestamp in the
+// given range and that set the given key in their SkipBlockData. An empty key
+// matches all blocks and an end of 0 doesn't limit the range. Blocks that
+// have been pruned are not returned.
+func (s *Service) SearchSkipChain(req *SearchSkipChain) (*SearchSkipChainReply, onet.ClientError) {
+	s.indexMutex.Lock()
+	defer s.indexMutex.Unlock()
+	index, err := s.updateIndex(req.Genesis)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound, err.Error())
+	}
+	// The timestamps are increasing, as they are verified for every new
+	// block.
+	first := sort.Search(len(index.entries), func(i int) bool {
+		return index.entries[i].timestamp >= req.Start
+	})
+	reply := &SearchSkipChainReply{}
+	for _, e := range index.entries[first:] {
+		if req.End != 0 && e.timestamp > req.End {
+			break
+		}
+		if req.Key != "" && !e.hasKey(req.Key) {
+			continue
+		}
+		if sb := s.Sbm.GetByID(e.id); sb != nil {
+			reply.Blocks = append(reply.Blocks, sb)
+		}
+	}
+	return reply, nil
+}
+
+// updateIndex adds all blocks following the last indexed block to the index
+// of the skipchain and returns the index. The caller must hold indexMutex.
+func (s *Service) updateIndex(genesisID SkipBlockID) (*blockIndex, error) {
+	index, ok := s.index[string(genesisID)]
+	if !ok {
+		genesis := s.Sbm.GetByID(genesisID)
+		if genesis == nil || genesis.Index != 0 {
+			return nil, errors.New("Didn't find genesis-block")
+		}
+		index = &blockIndex{}
+		index.add(genesis)
+		s.index[string(genesisID)] = index
+	}
+	last := s.Sbm.GetByID(index.last)
+	if last == nil {
+		// The last block got pruned - start again.
+		delete(s.index, string(genesisID))
+		return s.updateIndex(genesisID)
+	}
+	for _, sb := range s.Sbm.blocksAfter(last) {
+		index.add(sb)
+	}
+	return index, nil
+}
+
+// add appends the block to the index.
+func (bi *blockIndex) add(sb *SkipBlock) {
+	e := indexEntry{id: sb.Hash, timestamp: sb.Timestamp}
+	if _, msg, err := network.Unmarshal(sb.Data); err == nil {
+		if data, ok := msg.(*SkipBlockData); ok {
+			for _, entry := range data.Entries {
+				e.keys = append(e.keys, entry.Key)
+			}
+		}
+	}
+	bi.entries = append(bi.entries, e)
+	bi.last = sb.Hash
+}
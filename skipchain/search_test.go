@@ -0,0 +1,63 @@
+package skipchain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func TestService_SearchSkipChain(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, roster, s := makeHELS(local, 3)
+	genesis, err := makeGenesisRosterArgs(s, roster, nil, VerificationNone, 2, 2)
+	log.ErrFatal(err)
+	blocks := []*SkipBlock{genesis}
+	for i, key := range []string{"a", "b", "a", "c", "a"} {
+		data := &SkipBlockData{}
+		data.Set(key, []byte{byte(i)})
+		buf, err := network.Marshal(data)
+		log.ErrFatal(err)
+		sb := NewSkipBlock()
+		sb.Roster = roster
+		sb.Data = buf
+		ssbr, cerr := s.StoreSkipBlock(&StoreSkipBlock{blocks[i].Hash, sb, nil})
+		log.ErrFatal(cerr)
+		blocks = append(blocks, ssbr.Latest)
+		time.Sleep(time.Millisecond)
+	}
+	for i := 1; i < len(blocks); i++ {
+		require.True(t, blocks[i].Timestamp >= blocks[i-1].Timestamp)
+	}
+
+	search := func(key string, start, end int64) []*SkipBlock {
+		reply, cerr := s.SearchSkipChain(&SearchSkipChain{genesis.Hash, key, start, end})
+		log.ErrFatal(cerr)
+		return reply.Blocks
+	}
+	require.Equal(t, len(blocks), len(search("", 0, 0)))
+	found := search("a", 0, 0)
+	require.Equal(t, 3, len(found))
+	for i, index := range []int{1, 3, 5} {
+		require.True(t, found[i].Equal(blocks[index]))
+	}
+	found = search("a", blocks[2].Timestamp, blocks[4].Timestamp)
+	require.Equal(t, 1, len(found))
+	require.True(t, found[0].Equal(blocks[3]))
+	require.Equal(t, 0, len(search("d", 0, 0)))
+
+	log.Lvl1("Index gets updated with new blocks")
+	sb := NewSkipBlock()
+	sb.Roster = roster
+	_, cerr := s.StoreSkipBlock(&StoreSkipBlock{blocks[len(blocks)-1].Hash, sb, nil})
+	log.ErrFatal(cerr)
+	require.Equal(t, len(blocks)+1, len(search("", 0, 0)))
+
+	_, cerr = s.SearchSkipChain(&SearchSkipChain{blocks[1].Hash, "", 0, 0})
+	require.NotNil(t, cerr)
+	waitPropagationFinished(local)
+}
@@ -9,6 +9,8 @@ import (
 
 	"fmt"
 
+	"net"
+	"path"
 	"sync"
 
 	"github.com/dedis/cothority/bftcosi"
@@ -16,6 +18,7 @@ import (
 	"github.com/satori/go.uuid"
 	"gopkg.in/dedis/crypto.v0/random"
 	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/crypto"
 	"gopkg.in/dedis/onet.v1/log"
 	"gopkg.in/dedis/onet.v1/network"
 )
@@ -36,18 +39,76 @@ var skipchainSID onet.ServiceID
 // Name used to store skipblocks
 const skipblocksID = "skipblocks"
 
+// running holds the services of this process, so that CloseServices can
+// close their storages on shutdown.
+var running = struct {
+	sync.Mutex
+	services []*Service
+}{}
+
 // Service handles adding new SkipBlocks
 type Service struct {
 	*onet.ServiceProcessor
 	// Sbm is the skipblock-map that holds all known skipblocks to this service.
 	Sbm                *SkipBlockMap
 	propagate          messaging.PropagationFunc
-	verifiers          map[VerifierID]SkipBlockVerifier
+	verifiers          map[VerifierID]BlockVerifier
 	blockRequestsMutex sync.Mutex
 	blockRequests      map[string]chan *SkipBlock
 	lastSave           time.Time
 	newBlocksMutex     sync.Mutex
 	newBlocks          map[string]bool
+	// pruneMutex protects the retention-policies, the archive and the
+	// state of the last pruning of every skipchain.
+	pruneMutex       sync.Mutex
+	retention        map[string]RetentionPolicy
+	retentionDefault RetentionPolicy
+	archive          string
+	pruned           map[string]*pruneState
+	// subscriptionsMutex protects the subscriptions to new blocks.
+	subscriptionsMutex sync.Mutex
+	subscriptions      map[string][]*subscription
+	// syncMutex protects the synchronisation of skipchains to new members.
+	syncMutex    sync.Mutex
+	syncing      map[string]bool
+	syncRequests map[string]chan []*SkipBlock
+	// repairMutex protects the repair of missing forward-links.
+	repairMutex sync.Mutex
+	repairing   map[string]bool
+	repaired    map[string]SkipBlockID
+	// indexMutex protects the search-indexes of the skipchains.
+	indexMutex sync.Mutex
+	index      map[string]*blockIndex
+	// stateMutex protects the key/value-states of the skipchains.
+	stateMutex sync.Mutex
+	state      map[string]*kvState
+	// successorsMutex protects the successors verified for every block
+	// and the timers of the views.
+	successorsMutex sync.Mutex
+	successors      map[string]successorLock
+	views           map[string]viewTimer
+	// evidenceMutex protects the evidence of equivocations.
+	evidenceMutex sync.Mutex
+	evidence      []*Equivocation
+	// batchesMutex protects the pending batches of transactions.
+	batchesMutex  sync.Mutex
+	batches       map[string]*batch
+	batchCommits  map[string]*sync.Mutex
+	batchPolicies map[string]BatchPolicy
+	batchDefault  BatchPolicy
+	// rejectionsMutex protects the last rejection of a successor of every
+	// block.
+	rejectionsMutex sync.Mutex
+	rejections      map[string]*rejection
+	// httpMutex protects the listener of the HTTP-gateway.
+	httpMutex    sync.Mutex
+	httpListener net.Listener
+}
+
+// rejection is a successor of a block the verifiers refused.
+type rejection struct {
+	block SkipBlockID
+	err   *Rejection
 }
 
 // StoreSkipBlock stores a new skipblock in the system. This can be either a
@@ -66,17 +127,23 @@ type Service struct {
 //
 // If the latest block is non-nil and exists, the skipblock will be added to the
 // skipchain after verification that it fits and no other block already has been
-// added.
+// added. If the genesis-block of the skipchain has ClientKeys, the request
+// must be signed by one of these keys.
+//
+// Only the first member of the roster of the new block can create a new
+// skipchain. Any other member of the roster of the latest block can append a
+// block once the members before it have been silent, which allows to append
+// blocks if the first member is down.
 func (s *Service) StoreSkipBlock(psbd *StoreSkipBlock) (*StoreSkipBlockReply, onet.ClientError) {
 	prop := psbd.NewBlock
-	if !s.ServerIdentity().Equal(prop.Roster.Get(0)) {
-		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
-			"only leader is allowed to add blocks")
-	}
 	var prev *SkipBlock
 	var changed []*SkipBlock
 
 	if psbd.LatestID.IsNull() {
+		if i, _ := prop.Roster.Search(s.ServerIdentity().ID); i != 0 {
+			return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+				"only leader is allowed to add blocks")
+		}
 		// A new chain is created
 		prop.Index = 0
 		prop.Height = prop.MaximumHeight
@@ -85,6 +152,8 @@ func (s *Service) StoreSkipBlock(psbd *StoreSkipBlock) (*StoreSkipBlockReply, on
 		bl := random.Bytes(32, random.Stream)
 		prop.BackLinkIDs = []SkipBlockID{SkipBlockID(bl)}
 		prop.GenesisID = nil
+		prop.ClientSignature = nil
+		prop.Timestamp = time.Now().UnixNano()
 		prop.updateHash()
 		err := s.verifyBlock(prop)
 		if err != nil {
@@ -109,10 +178,20 @@ func (s *Service) StoreSkipBlock(psbd *StoreSkipBlock) (*StoreSkipBlockReply, on
 			return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
 				"Didn't find latest block")
 		}
-		if i, _ := prev.Roster.Search(s.ServerIdentity().ID); i < 0 {
+		// The position in the roster is the view in which we are the
+		// leader.
+		view, _ := prev.Roster.Search(s.ServerIdentity().ID)
+		if view < 0 {
 			return nil, onet.NewClientErrorCode(ErrorBlockContent,
 				"We're not responsible for latest block")
 		}
+		if view > 0 {
+			if err := s.waitForView(prev, view); err != nil {
+				return nil, onet.NewClientErrorCode(ErrorBlockContent,
+					err.Error())
+			}
+			prev = s.Sbm.GetByID(psbd.LatestID)
+		}
 		if len(prev.ForwardLink) > 0 {
 			return nil, onet.NewClientErrorCode(ErrorBlockContent,
 				"the latest block already has a follower")
@@ -126,13 +205,26 @@ func (s *Service) StoreSkipBlock(psbd *StoreSkipBlock) (*StoreSkipBlockReply, on
 		prop.BaseHeight = prev.BaseHeight
 		prop.ParentBlockID = nil
 		prop.VerifierIDs = prev.VerifierIDs
+		prop.ClientKeys = prev.ClientKeys
+		prop.Retention = prev.Retention
+		prop.ClientSignature = psbd.Signature
 		prop.Index = prev.Index + 1
 		prop.GenesisID = prev.SkipChainID()
-		index := prop.Index
-		for prop.Height = 1; index%prop.BaseHeight == 0; prop.Height++ {
-			index /= prop.BaseHeight
-			if prop.Height >= prop.MaximumHeight {
-				break
+		prop.Timestamp = time.Now().UnixNano()
+		if prop.BaseHeight == 0 {
+			var err error
+			prop.Height, err = s.Sbm.randomHeight(prev)
+			if err != nil {
+				return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+					err.Error())
+			}
+		} else {
+			index := prop.Index
+			for prop.Height = 1; index%prop.BaseHeight == 0; prop.Height++ {
+				index /= prop.BaseHeight
+				if prop.Height >= prop.MaximumHeight {
+					break
+				}
 			}
 		}
 		log.Lvl4("Found height", prop.Height, "for index", prop.Index,
@@ -140,8 +232,10 @@ func (s *Service) StoreSkipBlock(psbd *StoreSkipBlock) (*StoreSkipBlockReply, on
 		prop.BackLinkIDs = make([]SkipBlockID, prop.Height)
 		pointer := prev
 		for h := range prop.BackLinkIDs {
+			// Following the highest back-link skips the blocks in
+			// between, which might have been pruned.
 			for pointer.Height < h+1 {
-				pointer = s.Sbm.GetByID(pointer.BackLinkIDs[0])
+				pointer = s.Sbm.GetByID(pointer.BackLinkIDs[len(pointer.BackLinkIDs)-1])
 				if pointer == nil {
 					return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
 						"Didn't find convenient SkipBlock for height "+
@@ -151,7 +245,15 @@ func (s *Service) StoreSkipBlock(psbd *StoreSkipBlock) (*StoreSkipBlockReply, on
 			prop.BackLinkIDs[h] = pointer.Hash
 		}
 		prop.updateHash()
+		if err := s.verifyClientSignature(prop); err != nil {
+			return nil, onet.NewClientErrorCode(ErrorVerification,
+				err.Error())
+		}
 		if err := s.addForwardLink(prev, prop); err != nil {
+			if rej, ok := err.(*Rejection); ok {
+				return nil, onet.NewClientErrorCode(ErrorRejected,
+					rej.Error())
+			}
 			return nil, onet.NewClientErrorCode(ErrorBlockContent,
 				"Couldn't get forward signature on block: "+err.Error())
 		}
@@ -228,6 +330,20 @@ func (s *Service) GetUpdateChain(latestKnown *GetUpdateChain) (network.Message,
 	return reply, nil
 }
 
+// GetProof returns the shortest chain of blocks from the genesis-block to the
+// latest block, holding only the forward-links needed to verify it.
+func (s *Service) GetProof(req *GetProof) (*GetProofReply, onet.ClientError) {
+	msg, cerr := s.GetUpdateChain(&GetUpdateChain{req.Genesis})
+	if cerr != nil {
+		return nil, cerr
+	}
+	proof, err := newProof(msg.(*GetUpdateChainReply).Update)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockContent, err.Error())
+	}
+	return &GetProofReply{proof}, nil
+}
+
 // GetSingleBlock searches for the given block and returns it. If no such block is
 // found, a nil is returned.
 func (s *Service) GetSingleBlock(id *GetSingleBlock) (*SkipBlock, onet.ClientError) {
@@ -247,15 +363,25 @@ func (s *Service) GetSingleBlockByIndex(id *GetSingleBlockByIndex) (*SkipBlock,
 		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
 			"No such genesis-block")
 	}
+	// Follow the highest forward-link that doesn't overshoot the index,
+	// as blocks in between might have been pruned.
+	for sb.Index < id.Index {
+		var next *SkipBlock
+		for h := sb.GetForwardLen() - 1; h >= 0; h-- {
+			fl := s.Sbm.GetByID(sb.ForwardLink[h].Hash)
+			if fl != nil && fl.Index <= id.Index {
+				next = fl
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+		sb = next
+	}
 	if sb.Index == id.Index {
 		return sb, nil
 	}
-	for len(sb.ForwardLink) > 0 {
-		sb = s.Sbm.GetByID(sb.ForwardLink[0].Hash)
-		if sb.Index == id.Index {
-			return sb, nil
-		}
-	}
 	return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
 		"No block with this index found")
 }
@@ -264,11 +390,10 @@ func (s *Service) GetSingleBlockByIndex(id *GetSingleBlockByIndex) (*SkipBlock,
 func (s *Service) GetAllSkipchains(id *GetAllSkipchains) (*GetAllSkipchainsReply, onet.ClientError) {
 	// Write all known skipblocks to a map, thus removing double blocks.
 	chains := map[string]*SkipBlock{}
-	s.Sbm.Lock()
-	for _, sb := range s.Sbm.SkipBlocks {
+	s.Sbm.Iterate(func(sb *SkipBlock) bool {
 		chains[string(sb.SkipChainID())] = sb
-	}
-	s.Sbm.Unlock()
+		return true
+	})
 
 	reply := &GetAllSkipchainsReply{
 		SkipChains: make([]*SkipBlock, 0, len(chains)),
@@ -279,11 +404,15 @@ func (s *Service) GetAllSkipchains(id *GetAllSkipchains) (*GetAllSkipchainsReply
 	return reply, nil
 }
 
-// IsPropagating returns true if there is at least one propagation running.
+// IsPropagating returns true if there is at least one propagation or one
+// repair of forward-links running.
 func (s *Service) IsPropagating() bool {
 	s.newBlocksMutex.Lock()
-	defer s.newBlocksMutex.Unlock()
-	return len(s.newBlocks) > 0
+	newBlocks := len(s.newBlocks)
+	s.newBlocksMutex.Unlock()
+	s.repairMutex.Lock()
+	defer s.repairMutex.Unlock()
+	return newBlocks > 0 || len(s.repairing) > 0
 }
 
 func (s *Service) getUpdateBlock(known *SkipBlock, unknown SkipBlockID) (*SkipBlock, error) {
@@ -314,39 +443,42 @@ func (s *Service) getUpdateBlock(known *SkipBlock, unknown SkipBlockID) (*SkipBl
 // forwardSignature receives a signature request of a newly accepted block.
 // It only needs the 2nd-newest block and the forward-link.
 func (s *Service) forwardSignature(env *network.Envelope) {
-	err := func() error {
-		fs, ok := env.Msg.(*ForwardSignature)
-		if !ok {
-			return errors.New("Didn't receive a ForwardSignature")
-		}
-		if fs.TargetHeight >= len(fs.Newest.BackLinkIDs) {
-			return errors.New("This backlink-height doesn't exist")
-		}
-		target := s.Sbm.GetByID(fs.Newest.BackLinkIDs[fs.TargetHeight])
-		if target == nil {
-			return errors.New("Didn't find target-block")
-		}
-		data, err := network.Marshal(fs)
-		if err != nil {
-			return err
-		}
-		// TODO: is this really signed by target.roster?
-		sig, err := s.startBFT(bftFollowBlock, target.Roster, fs.ForwardLink.Hash, data)
-		if err != nil {
-			return errors.New("Couldn't get signature")
-		}
-		s.Sbm.Lock()
-		log.Lvl1("Adding forward-link to", target.Index)
-		target.AddForward(&BlockLink{fs.ForwardLink.Hash, sig.Sig})
-		s.Sbm.Unlock()
-		s.startPropagation([]*SkipBlock{target})
-		return nil
-	}()
-	if err != nil {
+	fs, ok := env.Msg.(*ForwardSignature)
+	if !ok {
+		log.Error("Didn't receive a ForwardSignature")
+		return
+	}
+	if err := s.signForwardLink(fs); err != nil {
 		log.Error(err)
 	}
 }
 
+// signForwardLink asks the roster of the target-block of fs to sign the
+// forward-link to the newest block and propagates the target-block.
+func (s *Service) signForwardLink(fs *ForwardSignature) error {
+	if fs.TargetHeight >= len(fs.Newest.BackLinkIDs) {
+		return errors.New("This backlink-height doesn't exist")
+	}
+	target := s.Sbm.GetByID(fs.Newest.BackLinkIDs[fs.TargetHeight])
+	if target == nil {
+		return errors.New("Didn't find target-block")
+	}
+	data, err := network.Marshal(fs)
+	if err != nil {
+		return err
+	}
+	// TODO: is this really signed by target.roster?
+	link, err := s.startBFT(bftFollowBlock, target.Roster, fs.ForwardLink.Hash, data)
+	if err != nil {
+		return errors.New("Couldn't get signature")
+	}
+	s.Sbm.Lock()
+	log.Lvl1("Adding forward-link to", target.Index)
+	target.AddForward(link)
+	s.Sbm.Unlock()
+	return s.startPropagation([]*SkipBlock{target})
+}
+
 func (s *Service) getBlock(env *network.Envelope) {
 	gb, ok := env.Msg.(*GetBlock)
 	if !ok {
@@ -381,6 +513,10 @@ func (s *Service) getBlockReply(env *network.Envelope) {
 	if err := s.Sbm.VerifyLinks(gbr.SkipBlock); err != nil {
 		log.Error("Received invalid skipblock: " + err.Error())
 	}
+	if s.checkFork(gbr.SkipBlock, nil) {
+		log.Error("Received block of a forked skipchain")
+		return
+	}
 	id := s.Sbm.Store(gbr.SkipBlock)
 	s.save()
 	log.Lvl3("Sending block to channel")
@@ -389,7 +525,7 @@ func (s *Service) getBlockReply(env *network.Envelope) {
 
 // verifyFollowBlock makes sure that a signature-request for a forward-link
 // is valid.
-func (s *Service) bftVerifyFollowBlock(msg []byte, data []byte) bool {
+func (s *Service) bftVerifyFollowBlock(msg []byte, data []byte) error {
 	err := func() error {
 		_, fsInt, err := network.Unmarshal(data)
 		if err != nil {
@@ -401,7 +537,7 @@ func (s *Service) bftVerifyFollowBlock(msg []byte, data []byte) bool {
 		}
 		previous := s.Sbm.GetByID(fs.Previous)
 		if previous == nil {
-			return errors.New("Didn't find newest block")
+			return refuse(RefuseUnknownBlock, "Didn't find newest block")
 		}
 		newest := fs.Newest
 		if len(newest.BackLinkIDs) <= fs.TargetHeight {
@@ -417,10 +553,10 @@ func (s *Service) bftVerifyFollowBlock(msg []byte, data []byte) bool {
 		s.Sbm.Lock()
 		defer s.Sbm.Unlock()
 		if target == nil {
-			return errors.New("Don't have target-block")
+			return refuse(RefuseUnknownBlock, "Don't have target-block")
 		}
 		if target.GetForwardLen() >= fs.TargetHeight+1 {
-			return errors.New("Already have forward-link at height " +
+			return refuse(RefuseForwardLink, "Already have forward-link at height "+
 				strconv.Itoa(fs.TargetHeight+1))
 		}
 		if !target.SkipChainID().Equal(newest.SkipChainID()) {
@@ -430,55 +566,125 @@ func (s *Service) bftVerifyFollowBlock(msg []byte, data []byte) bool {
 	}()
 	if err != nil {
 		log.Error(err)
-		return false
 	}
-	return true
+	return err
 }
 
 // verifyNewBlock makes sure that a signature-request for a forward-link
-// is valid.
-func (s *Service) bftVerifyNewBlock(msg []byte, data []byte) bool {
+// is valid. If it isn't, the returned reason is signed and sent to the
+// leader.
+//
+// The leader is the root of the BFT-round, and it must be the leader of a
+// view that started.
+func (s *Service) bftVerifyNewBlock(leader *network.ServerIdentity, msg []byte, data []byte) error {
 	log.Lvlf4("%s verifying block %x", s.ServerIdentity(), msg)
 	srcHash := data[0:32]
 	prevSB := s.Sbm.GetByID(srcHash)
 	if prevSB == nil {
 		log.Error("Didn't find src-skipblock")
-		return false
+		return refuse(RefuseUnknownBlock, "parent block unknown")
+	}
+	view, _ := prevSB.Roster.Search(leader.ID)
+	if view < 0 {
+		log.Lvl2("Leader is not in the roster of the previous block")
+		return refuse(RefuseView, "leader is not in the roster")
+	}
+	if !s.viewStarted(prevSB.Hash, view, viewChangeMargin) {
+		log.Lvl2("View", view, "of", prevSB.Index, "didn't start yet")
+		return refuse(RefuseView, "view of leader didn't start yet")
 	}
 	_, newSBi, err := network.Unmarshal(data[32:])
 	if err != nil {
 		log.Error("Couldn't unmarshal SkipBlock", data)
-		return false
+		return refuse(RefuseInvalidBlock, "couldn't unmarshal block")
 	}
 	newSB := newSBi.(*SkipBlock)
 	if !newSB.Hash.Equal(SkipBlockID(msg)) {
 		log.Lvlf2("Dest skipBlock different from msg %x %x", msg, []byte(newSB.Hash))
-		return false
+		return refuse(RefuseInvalidBlock, "block different from message")
 	}
 
 	if !newSB.BackLinkIDs[0].Equal(srcHash) {
 		log.Lvl2("Backlink does not point to previous block:", prevSB.Index, newSB.Index)
-		return false
+		return refuse(RefuseInvalidBlock, "backlink does not point to previous block")
 	}
 	if len(prevSB.ForwardLink) > 0 {
 		log.Lvl2("previous block already has forward-link")
-		return false
+		return refuse(RefuseForwardLink, "previous block already has forward-link")
+	}
+	if err := verifyTimestamp(prevSB, newSB); err != nil {
+		log.Lvl2(err)
+		return refuse(RefuseInvalidBlock, err.Error())
+	}
+	if prevSB.BaseHeight == 0 {
+		height, err := s.Sbm.randomHeight(prevSB)
+		if err != nil || height != newSB.Height {
+			log.Lvl2("Wrong height for random skipchain:", err)
+			return refuse(RefuseInvalidBlock, "wrong height for random skipchain")
+		}
 	}
 
-	ok := func() bool {
-		for _, ver := range newSB.VerifierIDs {
-			f, ok := s.verifiers[ver]
+	if rej := s.runVerifiers(prevSB, newSB); rej != nil {
+		log.Lvl2(s.ServerIdentity(), rej)
+		s.rejectionsMutex.Lock()
+		s.rejections[string(prevSB.Hash)] = &rejection{newSB.Hash, rej}
+		s.rejectionsMutex.Unlock()
+		return refuse(RefuseRejected, rej.Error())
+	}
+	if !s.lockSuccessor(prevSB.Hash, view, newSB.Hash) {
+		log.Lvl2("Already verified another successor of", prevSB.Index)
+		return refuse(RefuseForwardLink, "already verified another successor")
+	}
+	s.leaderActive(prevSB.Hash, view)
+	return nil
+}
+
+// refuse returns the reason of a refusal to sign a forward-link.
+func refuse(code int, msg string) error {
+	return &bftcosi.Reason{Code: code, Message: msg}
+}
+
+// runVerifiers calls all verifiers of the new block and returns the
+// rejection of the first verifier that refuses it.
+func (s *Service) runVerifiers(prev, newSB *SkipBlock) *Rejection {
+	ctx := &VerifierContext{
+		Previous: prev,
+		Genesis:  s.Sbm.GetByID(newSB.GenesisID),
+	}
+	if ctx.Genesis != nil && !ctx.Genesis.ParentBlockID.IsNull() {
+		ctx.Parent = s.Sbm.GetByID(ctx.Genesis.ParentBlockID)
+	}
+	for _, ver := range newSB.VerifierIDs {
+		bv, ok := s.verifiers[ver]
+		if !ok {
+			return &Rejection{ver, "no such verifier"}
+		}
+		if err := bv.VerifyBlock(newSB, ctx); err != nil {
+			rej, ok := err.(*Rejection)
 			if !ok {
-				log.Lvlf2("Found no user verification for %x", ver)
-				return false
-			}
-			if !f(msg, newSB) {
-				return false
+				rej = NewRejection(err.Error())
 			}
+			rej.Verifier = ver
+			return rej
 		}
-		return true
-	}()
-	return ok
+	}
+	return nil
+}
+
+// popRejection returns and removes the rejection of the verifiers of this
+// conode for the successor next of the block prev, if any.
+func (s *Service) popRejection(prev, next SkipBlockID) *Rejection {
+	s.rejectionsMutex.Lock()
+	defer s.rejectionsMutex.Unlock()
+	rej, ok := s.rejections[string(prev)]
+	if !ok {
+		return nil
+	}
+	delete(s.rejections, string(prev))
+	if !rej.block.Equal(next) {
+		return nil
+	}
+	return rej.err
 }
 
 // PropagateSkipBlock will save a new SkipBlock
@@ -488,20 +694,88 @@ func (s *Service) propagateSkipBlock(msg network.Message) {
 		log.Error("Couldn't convert to slice of SkipBlocks")
 		return
 	}
+	chains := map[string]*SkipBlock{}
+	var newBlocks []*SkipBlock
 	for _, sb := range sbs.SkipBlocks {
 		if err := sb.VerifyForwardSignatures(); err != nil {
 			log.Error(err)
 			return
 		}
+	}
+	for _, sb := range sbs.SkipBlocks {
+		if s.checkFork(sb, sbs.SkipBlocks) {
+			log.Error("Refusing blocks of a forked skipchain")
+			return
+		}
+	}
+	for _, sb := range sbs.SkipBlocks {
+		if s.Sbm.GetByID(sb.Hash) == nil {
+			newBlocks = append(newBlocks, sb)
+		}
+		if sb.GetForwardLen() > 0 {
+			s.unlockSuccessor(sb.Hash)
+			s.popRejection(sb.Hash, nil)
+		}
 		s.Sbm.Store(sb)
 		s.save()
+		newest := chains[string(sb.SkipChainID())]
+		if newest == nil || sb.Index > newest.Index {
+			chains[string(sb.SkipChainID())] = sb
+		}
+	}
+	for _, sb := range newBlocks {
+		s.notifySubscribers(sb)
+	}
+	for id, newest := range chains {
+		// Conodes that just joined the roster first need the blocks
+		// they missed.
+		if s.needsSync(newest) {
+			go func(newest *SkipBlock) {
+				if err := s.syncSkipChain(newest); err != nil {
+					log.Error(err)
+				}
+			}(newest)
+			continue
+		}
+		// The state needs the blocks before they're pruned.
+		if err := s.updateState(SkipBlockID(id)); err != nil {
+			log.Error("Couldn't update state:", err)
+		}
+		if err := s.pruneSkipChain(SkipBlockID(id)); err != nil {
+			log.Error("Couldn't prune skipchain:", err)
+		}
+		if len(newBlocks) > 0 && newest.Index > 0 {
+			s.repairSkipChain(SkipBlockID(id))
+		}
 	}
 }
 
 // RegisterVerification stores the verification in a map and will
 // call it whenever a verification needs to be done.
 func (s *Service) registerVerification(v VerifierID, f SkipBlockVerifier) error {
-	s.verifiers[v] = f
+	return s.registerBlockVerifier(v, verifierFunc(f))
+}
+
+// registerBlockVerifier stores the verifier in a map and will call it
+// whenever a verification needs to be done.
+func (s *Service) registerBlockVerifier(v VerifierID, bv BlockVerifier) error {
+	s.verifiers[v] = bv
+	return nil
+}
+
+// verifyTimestamp makes sure that the timestamp of the new block is not
+// before the timestamp of the previous block and close to the local time.
+func verifyTimestamp(prev, sb *SkipBlock) error {
+	if sb.Timestamp < prev.Timestamp {
+		return errors.New("timestamp is before the previous block")
+	}
+	drift := time.Now().UnixNano() - sb.Timestamp
+	if drift < 0 {
+		drift = -drift
+	}
+	if drift > int64(maxTimestampDrift*time.Second) {
+		return errors.New("timestamp is too far from the local time")
+	}
 	return nil
 }
 
@@ -511,10 +785,10 @@ func (s *Service) verifyBlock(sb *SkipBlock) error {
 	if sb.MaximumHeight <= 0 {
 		return errors.New("Set a maximumHeight > 0")
 	}
-	if sb.BaseHeight <= 0 {
-		return errors.New("Set a baseHeight > 0")
+	if sb.BaseHeight < 0 {
+		return errors.New("Set a baseHeight >= 0")
 	}
-	if sb.MaximumHeight > sb.BaseHeight {
+	if sb.BaseHeight > 0 && sb.MaximumHeight > sb.BaseHeight {
 		return errors.New("maximumHeight must be smaller or equal baseHeight")
 	}
 	if sb.Index < 0 {
@@ -535,6 +809,41 @@ func (s *Service) verifyBlock(sb *SkipBlock) error {
 	return nil
 }
 
+// verifyClientSignature makes sure that the block has been signed by one of
+// the ClientKeys of the genesis-block. If the genesis-block is not known, the
+// ClientKeys of the previous block or, failing that, of the block itself are
+// used. If the skipchain has no ClientKeys, every block is accepted.
+func (s *Service) verifyClientSignature(sb *SkipBlock) error {
+	if sb.Index == 0 {
+		return nil
+	}
+	keys := sb.ClientKeys
+	if genesis := s.Sbm.GetByID(sb.GenesisID); genesis != nil {
+		keys = genesis.ClientKeys
+	} else if len(sb.BackLinkIDs) > 0 {
+		if prev := s.Sbm.GetByID(sb.BackLinkIDs[0]); prev != nil {
+			keys = prev.ClientKeys
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	if sb.ClientSignature == nil {
+		return errors.New("Missing client-signature")
+	}
+	if len(sb.BackLinkIDs) == 0 {
+		return errors.New("Can't verify client-signature without back-link")
+	}
+	msg := sb.ClientHash(sb.BackLinkIDs[0])
+	for _, pub := range keys {
+		if crypto.VerifySchnorr(network.Suite, pub, msg,
+			*sb.ClientSignature) == nil {
+			return nil
+		}
+	}
+	return errors.New("Client-signature doesn't match any client-key")
+}
+
 // addForwardLink verifies if the new block is valid. If it is not valid, it
 // returns with an error.
 // If it finds a valid block, a forward-link will be added and a BFT-signature
@@ -553,14 +862,15 @@ func (s *Service) addForwardLink(src, dst *SkipBlock) error {
 		return fmt.Errorf("Couldn't marshal block: %s", err.Error())
 	}
 	msg := []byte(dst.Hash)
-	sig, err := s.startBFT(bftNewBlock, roster, msg, append(src.Hash, data...))
+	fwd, err := s.startBFT(bftNewBlock, roster, msg, append(src.Hash, data...))
+	if rej := s.popRejection(src.Hash, dst.Hash); rej != nil {
+		return rej
+	}
 	if err != nil {
 		return err
 	}
-
-	fwd := &BlockLink{
-		Hash:      dst.Hash,
-		Signature: sig.Sig,
+	if err := verifyHandover(src, dst, fwd); err != nil {
+		return err
 	}
 	fwl := s.Sbm.GetByID(src.Hash).ForwardLink
 	log.Lvlf3("%s adds forward-link to %s: %d->%d - fwlinks:%v", s.ServerIdentity(),
@@ -575,8 +885,9 @@ func (s *Service) addForwardLink(src, dst *SkipBlock) error {
 	return nil
 }
 
-// startBFT starts a BFT-protocol with the given parameters.
-func (s *Service) startBFT(proto string, roster *onet.Roster, msg, data []byte) (*bftcosi.BFTSignature, error) {
+// startBFT starts a BFT-protocol with the given parameters and returns the
+// link to msg, holding the signature and the members that refused to sign.
+func (s *Service) startBFT(proto string, roster *onet.Roster, msg, data []byte) (*BlockLink, error) {
 	switch len(roster.List) {
 	case 0:
 		return nil, errors.New("Found empty Roster")
@@ -597,19 +908,49 @@ func (s *Service) startBFT(proto string, roster *onet.Roster, msg, data []byte)
 	root.Data = data
 
 	// function that will be called when protocol is finished by the root
-	done := make(chan bool)
+	done := make(chan bool, 1)
 	root.RegisterOnDone(func() {
 		done <- true
 	})
 	go node.Start()
-	var sig *bftcosi.BFTSignature
 	select {
 	case <-done:
-		sig = root.Signature()
+		if err := root.Err(); err != nil {
+			return nil, fmt.Errorf("Couldn't sign forward-link: %s", err.Error())
+		}
+		// the tree put us first in its roster, but the link is
+		// verified with the roster of the block
+		indexes, err := bftcosi.RosterIndexes(tree.Roster, roster)
+		if err != nil {
+			return nil, err
+		}
+		sig := root.Signature().Remap(network.Suite, indexes)
 		if sig.Sig == nil {
+			if err := bftcosi.RefusalError(network.Suite, roster, sig); err != nil {
+				return nil, errors.New("Couldn't sign forward-link: " + err.Error())
+			}
 			return nil, errors.New("Couldn't sign forward-link")
 		}
-		return sig, nil
+		// the compact form keeps the size of the link independent of
+		// the number of conodes that left during the signature
+		compact, err := sig.Compact(network.Suite, len(roster.List))
+		if err != nil {
+			return nil, errors.New("Couldn't encode signature: " + err.Error())
+		}
+		bl := &BlockLink{
+			Hash:      msg,
+			Signature: compact,
+		}
+		refusals := map[int]*bftcosi.Refusal{}
+		for i, ref := range root.SignedRefusals() {
+			refusals[indexes[i]] = ref
+		}
+		for i := range roster.List {
+			if ref, ok := refusals[i]; ok {
+				bl.Refusals = append(bl.Refusals, LinkRefusal{i, ref})
+			}
+		}
+		return bl, nil
 	case <-time.After(time.Second * 60):
 		return nil, errors.New("Timed out while waiting for signature")
 	}
@@ -661,8 +1002,12 @@ func (s *Service) newBlockEnd(sb *SkipBlock) bool {
 	return true
 }
 
-// saves all skipblocks.
+// saves all skipblocks. If the skipblocks are stored in a storage, they
+// are already written when stored in the SkipBlockMap.
 func (s *Service) save() {
+	if s.Sbm.HasStorage() {
+		return
+	}
 	s.Sbm.Lock()
 	defer s.Sbm.Unlock()
 	if time.Now().Sub(s.lastSave) < time.Second*timeBetweenSave {
@@ -677,7 +1022,8 @@ func (s *Service) save() {
 }
 
 // Tries to load the configuration and updates the data in the service
-// if it finds a valid config-file.
+// if it finds a valid config-file. If the service has a storage, the
+// skipblocks of the config-file are moved to the storage.
 func (s *Service) tryLoad() error {
 	if !s.DataAvailable(skipblocksID) {
 		return nil
@@ -686,34 +1032,127 @@ func (s *Service) tryLoad() error {
 	if err != nil {
 		return err
 	}
-	var ok bool
-	s.Sbm, ok = msg.(*SkipBlockMap)
+	sbm, ok := msg.(*SkipBlockMap)
 	if !ok {
 		return errors.New("Data of wrong type")
 	}
-	return nil
+	if !s.Sbm.HasStorage() {
+		s.Sbm = sbm
+		return nil
+	}
+	if len(sbm.SkipBlocks) == 0 {
+		return nil
+	}
+	log.Lvl1("Moving", len(sbm.SkipBlocks), "skipblocks to the storage")
+	for _, sb := range sbm.SkipBlocks {
+		s.Sbm.Store(sb)
+	}
+	return s.Save(skipblocksID, NewSkipBlockMap())
+}
+
+// openStorage opens the database holding the skipblocks of this conode.
+func (s *Service) openStorage() (SkipBlockStorage, error) {
+	name := fmt.Sprintf("%s-%s.db", skipblocksID,
+		uuid.UUID(s.ServerIdentity().ID).String())
+	return NewBoltStorage(path.Join(onet.ContextDataPath, name))
+}
+
+// Close stops the HTTP-gateway and closes the storage of the skipblocks.
+func (s *Service) Close() error {
+	running.Lock()
+	for i, srv := range running.services {
+		if srv == s {
+			running.services = append(running.services[:i],
+				running.services[i+1:]...)
+			break
+		}
+	}
+	running.Unlock()
+	if err := s.StopHTTP(); err != nil {
+		log.Error("Couldn't stop HTTP-gateway:", err)
+	}
+	return s.Sbm.Close()
+}
+
+// CloseServices closes all skipchain-services of this process. It has to be
+// called when the conode shuts down, so that the storages are closed
+// cleanly.
+func CloseServices() error {
+	running.Lock()
+	services := running.services
+	running.services = nil
+	running.Unlock()
+	var err error
+	for _, s := range services {
+		if e := s.Close(); e != nil {
+			log.Error("Couldn't close skipchain-service:", e)
+			err = e
+		}
+	}
+	return err
 }
 
 func newSkipchainService(c *onet.Context) onet.Service {
 	s := &Service{
 		ServiceProcessor: onet.NewServiceProcessor(c),
 		Sbm:              NewSkipBlockMap(),
-		verifiers:        map[VerifierID]SkipBlockVerifier{},
+		verifiers:        map[VerifierID]BlockVerifier{},
 		blockRequests:    make(map[string]chan *SkipBlock),
 		newBlocks:        make(map[string]bool),
+		retention:        make(map[string]RetentionPolicy),
+		pruned:           make(map[string]*pruneState),
+		subscriptions:    make(map[string][]*subscription),
+		syncing:          make(map[string]bool),
+		syncRequests:     make(map[string]chan []*SkipBlock),
+		repairing:        make(map[string]bool),
+		repaired:         make(map[string]SkipBlockID),
+		index:            make(map[string]*blockIndex),
+		state:            make(map[string]*kvState),
+		successors:       make(map[string]successorLock),
+		views:            make(map[string]viewTimer),
+		batches:          make(map[string]*batch),
+		batchCommits:     make(map[string]*sync.Mutex),
+		batchPolicies:    make(map[string]BatchPolicy),
+		batchDefault:     BatchPolicy{defaultBatchInterval, defaultBatchSize},
+		rejections:       make(map[string]*rejection),
+	}
+	if st, err := s.openStorage(); err != nil {
+		log.Error("Couldn't open storage, keeping skipblocks in memory:", err)
+	} else {
+		s.Sbm = NewSkipBlockMapStorage(st)
 	}
 	if err := s.tryLoad(); err != nil {
 		log.Error(err)
 	}
+	if err := s.loadEvidence(); err != nil {
+		log.Error(err)
+	}
+	if httpAddress != "" {
+		if err := s.StartHTTP(httpAddress); err != nil {
+			log.Error("Couldn't start HTTP-gateway:", err)
+		}
+	}
 	s.lastSave = time.Now()
+	running.Lock()
+	running.services = append(running.services, s)
+	running.Unlock()
 	log.ErrFatal(s.RegisterHandlers(s.StoreSkipBlock, s.GetUpdateChain,
-		s.GetSingleBlock, s.GetSingleBlockByIndex, s.GetAllSkipchains))
+		s.GetSingleBlock, s.GetSingleBlockByIndex, s.GetAllSkipchains,
+		s.GetProof, s.SubscribeSkipChain, s.SearchSkipChain,
+		s.GetValue, s.GetEquivocations, s.StoreTransaction,
+		s.GetParticipation, s.ExportSkipChain))
 	s.RegisterProcessorFunc(network.MessageType(ForwardSignature{}),
 		s.forwardSignature)
 	s.RegisterProcessorFunc(network.MessageType(GetBlock{}),
 		s.getBlock)
 	s.RegisterProcessorFunc(network.MessageType(GetBlockReply{}),
 		s.getBlockReply)
+	s.RegisterProcessorFunc(network.MessageType(SyncChain{}),
+		s.syncChain)
+	s.RegisterProcessorFunc(network.MessageType(SyncChainReply{}),
+		s.syncChainReply)
+	s.RegisterProcessorFunc(network.MessageType(ViewChange{}),
+		s.viewChange)
 
 	log.ErrFatal(s.registerVerification(VerifyBase, s.verifyFuncBase))
 	log.ErrFatal(s.registerVerification(VerifyRoot, s.verifyFuncRoot))
@@ -724,10 +1163,15 @@ func newSkipchainService(c *onet.Context) onet.Service {
 	s.propagate, err = messaging.NewPropagationFunc(c, "SkipchainPropagate", s.propagateSkipBlock)
 	log.ErrFatal(err)
 	s.ProtocolRegister(bftNewBlock, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
-		return bftcosi.NewBFTCoSiProtocol(n, s.bftVerifyNewBlock)
+		verify := func(msg, data []byte) error {
+			return s.bftVerifyNewBlock(n.Root().ServerIdentity, msg, data)
+		}
+		return bftcosi.NewBFTCoSiProtocolReason(n, verify,
+			bftcosi.DefaultPolicy(len(n.Tree().List())))
 	})
 	s.ProtocolRegister(bftFollowBlock, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
-		return bftcosi.NewBFTCoSiProtocol(n, s.bftVerifyFollowBlock)
+		return bftcosi.NewBFTCoSiProtocolReason(n, s.bftVerifyFollowBlock,
+			bftcosi.DefaultPolicy(len(n.Tree().List())))
 	})
 	return s
 }
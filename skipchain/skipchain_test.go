@@ -17,8 +17,12 @@ import (
 	"github.com/satori/go.uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/config"
 	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/crypto"
 	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
 )
 
 func TestMain(m *testing.M) {
@@ -46,7 +50,7 @@ func TestService_StoreSkipBlock(t *testing.T) {
 	genesis.Roster = sbRoot.Roster
 	genesis.VerifierIDs = VerificationStandard
 	blockCount := 0
-	psbr, err := service.StoreSkipBlock(&StoreSkipBlock{nil, genesis})
+	psbr, err := service.StoreSkipBlock(&StoreSkipBlock{nil, genesis, nil})
 	assert.Nil(t, err)
 	latest := psbr.Latest
 	// verify creation of GenesisBlock:
@@ -63,7 +67,7 @@ func TestService_StoreSkipBlock(t *testing.T) {
 	next.ParentBlockID = sbRoot.Hash
 	next.Roster = sbRoot.Roster
 	id := psbr.Latest.Hash
-	psbr2, err := service.StoreSkipBlock(&StoreSkipBlock{id, next})
+	psbr2, err := service.StoreSkipBlock(&StoreSkipBlock{id, next, nil})
 	assert.Nil(t, err)
 	log.Lvl2(psbr2)
 	if psbr2 == nil {
@@ -102,7 +106,7 @@ func TestService_GetUpdateChain(t *testing.T) {
 		newSB.Roster = onet.NewRoster(el.List[i : i+2])
 		service := local.Services[servers[i].ServerIdentity.ID][skipchainSID].(*Service)
 		log.Lvl2("Doing skipblock", i, servers[i].ServerIdentity, newSB.Roster.List)
-		reply, err := service.StoreSkipBlock(&StoreSkipBlock{sbs[i-1].Hash, newSB})
+		reply, err := service.StoreSkipBlock(&StoreSkipBlock{sbs[i-1].Hash, newSB, nil})
 		assert.Nil(t, err)
 		require.NotNil(t, reply.Latest)
 		sbs[i] = reply.Latest
@@ -228,7 +232,7 @@ func TestService_MultiLevel(t *testing.T) {
 				log.Lvl3("Adding block", sbi)
 				sb := NewSkipBlock()
 				sb.Roster = el
-				psbr, err := service.StoreSkipBlock(&StoreSkipBlock{latest.Hash, sb})
+				psbr, err := service.StoreSkipBlock(&StoreSkipBlock{latest.Hash, sb, nil})
 				log.ErrFatal(err)
 				latest = psbr.Latest
 				checkBacklinks(services, latest)
@@ -326,7 +330,7 @@ func TestService_SignBlock(t *testing.T) {
 	el2 := onet.NewRoster(el.List[0:2])
 	sb := NewSkipBlock()
 	sb.Roster = el2
-	reply, err := service.StoreSkipBlock(&StoreSkipBlock{sbRoot.Hash, sb})
+	reply, err := service.StoreSkipBlock(&StoreSkipBlock{sbRoot.Hash, sb, nil})
 	log.ErrFatal(err)
 	sbRoot = reply.Previous
 	sbSecond := reply.Latest
@@ -356,7 +360,7 @@ func TestService_ProtocolVerification(t *testing.T) {
 	log.ErrFatal(err)
 	sbNext := sbRoot.Copy()
 	sbNext.BackLinkIDs = []SkipBlockID{sbRoot.Hash}
-	_, cerr := s1.StoreSkipBlock(&StoreSkipBlock{sbRoot.Hash, sbNext})
+	_, cerr := s1.StoreSkipBlock(&StoreSkipBlock{sbRoot.Hash, sbNext, nil})
 	log.ErrFatal(cerr)
 	for i := 0; i < 3; i++ {
 		select {
@@ -416,15 +420,15 @@ func TestService_StoreSkipBlock2(t *testing.T) {
 			Data:          []byte{},
 		},
 	}
-	ssbr, cerr := s1.StoreSkipBlock(&StoreSkipBlock{nil, sbRoot})
+	ssbr, cerr := s1.StoreSkipBlock(&StoreSkipBlock{nil, sbRoot, nil})
 	log.ErrFatal(cerr)
 	roster2 := onet.NewRoster(roster.List[:nbrHosts-1])
 	log.Lvl1("Proposing roster", roster2)
 	sb1 := ssbr.Latest.Copy()
 	sb1.Roster = roster2
-	ssbr, cerr = s2.StoreSkipBlock(&StoreSkipBlock{sbRoot.Hash, sb1})
+	ssbr, cerr = s2.StoreSkipBlock(&StoreSkipBlock{sbRoot.Hash, sb1, nil})
 	require.NotNil(t, cerr)
-	ssbr, cerr = s1.StoreSkipBlock(&StoreSkipBlock{sbRoot.Hash, sb1})
+	ssbr, cerr = s1.StoreSkipBlock(&StoreSkipBlock{sbRoot.Hash, sb1, nil})
 	log.ErrFatal(cerr)
 	require.NotNil(t, ssbr.Latest)
 
@@ -438,18 +442,86 @@ func TestService_StoreSkipBlock2(t *testing.T) {
 		},
 	}
 	sbErr.ParentBlockID = SkipBlockID([]byte{1, 2, 3})
-	_, cerr = s1.StoreSkipBlock(&StoreSkipBlock{nil, sbErr})
+	_, cerr = s1.StoreSkipBlock(&StoreSkipBlock{nil, sbErr, nil})
 	require.NotNil(t, cerr)
-	_, cerr = s1.StoreSkipBlock(&StoreSkipBlock{sbErr.ParentBlockID, sbErr})
+	_, cerr = s1.StoreSkipBlock(&StoreSkipBlock{sbErr.ParentBlockID, sbErr, nil})
 	// Last successful log...
 	require.NotNil(t, cerr)
 
 	sbErr = ssbr.Latest.Copy()
-	_, cerr = s3.StoreSkipBlock(&StoreSkipBlock{ssbr.Latest.Hash, sbErr})
+	_, cerr = s3.StoreSkipBlock(&StoreSkipBlock{ssbr.Latest.Hash, sbErr, nil})
 	require.NotNil(t, cerr)
 	waitPropagationFinished(local)
 }
 
+func TestService_StoreSkipBlockClientKeys(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, roster, s1 := makeHELS(local, 3)
+	kp := config.NewKeyPair(network.Suite)
+	kpWrong := config.NewKeyPair(network.Suite)
+
+	genesis := NewSkipBlock()
+	genesis.MaximumHeight = 1
+	genesis.BaseHeight = 1
+	genesis.Roster = roster
+	genesis.VerifierIDs = VerificationStandard
+	genesis.ClientKeys = []abstract.Point{kp.Public}
+	ssbr, cerr := s1.StoreSkipBlock(&StoreSkipBlock{nil, genesis, nil})
+	log.ErrFatal(cerr)
+	latest := ssbr.Latest
+
+	next := NewSkipBlock()
+	next.Roster = roster
+	next.Data = []byte{1, 2, 3}
+	_, cerr = s1.StoreSkipBlock(&StoreSkipBlock{latest.Hash, next, nil})
+	require.NotNil(t, cerr, "Unsigned block should be refused")
+
+	sig, err := crypto.SignSchnorr(network.Suite, kpWrong.Secret,
+		next.ClientHash(latest.Hash))
+	log.ErrFatal(err)
+	_, cerr = s1.StoreSkipBlock(&StoreSkipBlock{latest.Hash, next, &sig})
+	require.NotNil(t, cerr, "Block signed with wrong key should be refused")
+
+	sig, err = crypto.SignSchnorr(network.Suite, kp.Secret,
+		next.ClientHash(latest.Hash))
+	log.ErrFatal(err)
+	ssbr, cerr = s1.StoreSkipBlock(&StoreSkipBlock{latest.Hash, next, &sig})
+	log.ErrFatal(cerr)
+	require.NotNil(t, ssbr.Latest.ClientSignature)
+	require.True(t, s1.verifyFuncBase(ssbr.Latest.Hash, ssbr.Latest))
+
+	unsigned := ssbr.Latest.Copy()
+	unsigned.ClientSignature = nil
+	require.False(t, s1.verifyFuncBase(unsigned.Hash, unsigned))
+	waitPropagationFinished(local)
+}
+
+func TestService_VerifyClientSignatureUnknownGenesis(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, roster, s := makeHELS(local, 1)
+	kp := config.NewKeyPair(network.Suite)
+
+	sb := NewSkipBlock()
+	sb.Index = 1
+	sb.Roster = roster
+	sb.GenesisID = SkipBlockID{1, 2, 3}
+	sb.BackLinkIDs = []SkipBlockID{SkipBlockID{1, 2, 3}}
+	require.Nil(t, s.verifyClientSignature(sb),
+		"Block of a skipchain without client-keys should be accepted")
+
+	sb.ClientKeys = []abstract.Point{kp.Public}
+	require.NotNil(t, s.verifyClientSignature(sb),
+		"Unsigned block of a skipchain with client-keys should be refused")
+
+	sig, err := crypto.SignSchnorr(network.Suite, kp.Secret,
+		sb.ClientHash(sb.BackLinkIDs[0]))
+	log.ErrFatal(err)
+	sb.ClientSignature = &sig
+	require.Nil(t, s.verifyClientSignature(sb))
+}
+
 func TestService_StoreSkipBlockSpeed(t *testing.T) {
 	t.Skip("This is a hidden benchmark")
 	nbrHosts := 3
@@ -466,7 +538,7 @@ func TestService_StoreSkipBlockSpeed(t *testing.T) {
 			Data:          []byte{},
 		},
 	}
-	ssbrep, cerr := s1.StoreSkipBlock(&StoreSkipBlock{nil, sbRoot})
+	ssbrep, cerr := s1.StoreSkipBlock(&StoreSkipBlock{nil, sbRoot, nil})
 	log.ErrFatal(cerr)
 
 	last := time.Now()
@@ -475,7 +547,7 @@ func TestService_StoreSkipBlockSpeed(t *testing.T) {
 		log.Lvl3(i, now.Sub(last))
 		last = now
 		ssbrep, cerr = s1.StoreSkipBlock(&StoreSkipBlock{ssbrep.Latest.Hash,
-			sbRoot})
+			sbRoot, nil})
 		log.ErrFatal(cerr)
 	}
 }
@@ -493,7 +565,7 @@ func TestService_ParallelStore(t *testing.T) {
 			Data:          []byte{},
 		},
 	}
-	ssbrep, cerr := s1.StoreSkipBlock(&StoreSkipBlock{nil, sbRoot})
+	ssbrep, cerr := s1.StoreSkipBlock(&StoreSkipBlock{nil, sbRoot, nil})
 	log.ErrFatal(cerr)
 
 	wg := &sync.WaitGroup{}
@@ -503,7 +575,7 @@ func TestService_ParallelStore(t *testing.T) {
 			cl := NewClient()
 			block := sbRoot.Copy()
 			for {
-				_, cerr := s1.StoreSkipBlock(&StoreSkipBlock{latest.Hash, block})
+				_, cerr := s1.StoreSkipBlock(&StoreSkipBlock{latest.Hash, block, nil})
 				if cerr == nil {
 					log.Lvl1("Done with", i)
 					wg.Done()
@@ -527,6 +599,58 @@ func TestService_ParallelStore(t *testing.T) {
 	wg.Wait()
 }
 
+func TestService_RandomHeights(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, roster, s := makeHELS(local, 3)
+	nbrBlocks := 32
+	// makeChain returns the length of the update-chain from the
+	// genesis-block to the latest block, and the heights of all blocks.
+	makeChain := func(base int) (int, []int) {
+		genesis, err := makeGenesisRosterArgs(s, roster, nil, VerificationNone, base, 4)
+		log.ErrFatal(err)
+		latest := genesis
+		heights := []int{}
+		for i := 1; i < nbrBlocks; i++ {
+			sb := NewSkipBlock()
+			sb.Roster = roster
+			ssbr, cerr := s.StoreSkipBlock(&StoreSkipBlock{latest.Hash, sb, nil})
+			log.ErrFatal(cerr)
+			latest = ssbr.Latest
+			heights = append(heights, latest.Height)
+			require.Equal(t, latest.Height, len(latest.BackLinkIDs))
+		}
+		waitPropagationFinished(local)
+		reply, cerr := s.GetUpdateChain(&GetUpdateChain{genesis.Hash})
+		log.ErrFatal(cerr)
+		update := reply.(*GetUpdateChainReply).Update
+		require.True(t, update[len(update)-1].Equal(latest))
+		return len(update), heights
+	}
+
+	deterministic, _ := makeChain(2)
+	random, heights := makeChain(0)
+	log.Lvl1("Update-chain of deterministic chain:", deterministic,
+		"- of random chain:", random, "- heights:", heights)
+	require.True(t, deterministic < nbrBlocks)
+	require.True(t, random < nbrBlocks, "Random chain should skip blocks")
+	higher := 0
+	for _, h := range heights {
+		require.True(t, h >= 1 && h <= 4)
+		if h > 1 {
+			higher++
+		}
+	}
+	require.True(t, higher > 0, "All blocks have height 1")
+
+	log.Lvl1("Refusing wrong heights")
+	genesis, err := makeGenesisRosterArgs(s, roster, nil, VerificationNone, 0, 4)
+	log.ErrFatal(err)
+	require.Nil(t, s.verifyBlock(genesis))
+	genesis.BaseHeight = -1
+	require.NotNil(t, s.verifyBlock(genesis))
+}
+
 func checkMLForwardBackward(service *Service, root *SkipBlock, base, height int) error {
 	genesis := service.Sbm.GetByID(root.Hash)
 	if genesis == nil {
@@ -599,7 +723,7 @@ func makeGenesisRosterArgs(s *Service, el *onet.Roster, parent SkipBlockID,
 	sb.BaseHeight = base
 	sb.ParentBlockID = parent
 	sb.VerifierIDs = vid
-	psbr, err := s.StoreSkipBlock(&StoreSkipBlock{nil, sb})
+	psbr, err := s.StoreSkipBlock(&StoreSkipBlock{nil, sb, nil})
 	if err != nil {
 		return nil, err
 	}
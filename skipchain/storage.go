@@ -0,0 +1,136 @@
+package skipchain
+
+import (
+	"errors"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+/*
+This file holds the persistent storage of the skipblocks. Every block is
+stored once under its hash and is overwritten when its forward-links or
+children change.
+*/
+
+// SkipBlockStorage is a persistent backend for the SkipBlockMap. The
+// SkipBlockMap keeps a bounded number of the blocks it already loaded in
+// memory and asks the storage for all other blocks.
+type SkipBlockStorage interface {
+	// Load returns the block with the given ID, or nil if it is not
+	// stored.
+	Load(id SkipBlockID) (*SkipBlock, error)
+	// Store writes the block, replacing a block with the same ID.
+	Store(sb *SkipBlock) error
+	// Remove deletes the block with the given ID.
+	Remove(id SkipBlockID) error
+	// Iterate calls f for every stored block. If f returns an error, the
+	// iteration stops and the error is returned.
+	Iterate(f func(sb *SkipBlock) error) error
+	// Length returns the number of stored blocks.
+	Length() (int, error)
+	// Close releases all resources of the storage.
+	Close() error
+}
+
+// skipblocksBucket is the name of the bolt-bucket holding the skipblocks.
+var skipblocksBucket = []byte("skipblocks")
+
+// BoltStorage stores the skipblocks in a bolt key/value database, using
+// the hash of the block as key.
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+// NewBoltStorage opens or creates the database in the given file.
+func NewBoltStorage(filename string) (*BoltStorage, error) {
+	db, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(skipblocksBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStorage{db: db}, nil
+}
+
+// Load returns the block with the given ID, or nil if it is not stored.
+func (bs *BoltStorage) Load(id SkipBlockID) (*SkipBlock, error) {
+	var sb *SkipBlock
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		buf := tx.Bucket(skipblocksBucket).Get(id)
+		if buf == nil {
+			return nil
+		}
+		var err error
+		sb, err = unmarshalSkipBlock(buf)
+		return err
+	})
+	return sb, err
+}
+
+// Store writes the block, replacing a block with the same ID.
+func (bs *BoltStorage) Store(sb *SkipBlock) error {
+	buf, err := network.Marshal(sb)
+	if err != nil {
+		return err
+	}
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(skipblocksBucket).Put(sb.Hash, buf)
+	})
+}
+
+// Remove deletes the block with the given ID.
+func (bs *BoltStorage) Remove(id SkipBlockID) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(skipblocksBucket).Delete(id)
+	})
+}
+
+// Iterate calls f for every stored block.
+func (bs *BoltStorage) Iterate(f func(sb *SkipBlock) error) error {
+	return bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(skipblocksBucket).ForEach(func(k, v []byte) error {
+			sb, err := unmarshalSkipBlock(v)
+			if err != nil {
+				return err
+			}
+			return f(sb)
+		})
+	})
+}
+
+// Length returns the number of stored blocks.
+func (bs *BoltStorage) Length() (int, error) {
+	var n int
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(skipblocksBucket).Stats().KeyN
+		return nil
+	})
+	return n, err
+}
+
+// Close closes the database.
+func (bs *BoltStorage) Close() error {
+	return bs.db.Close()
+}
+
+// unmarshalSkipBlock copies the buffer, as bolt only guarantees its
+// content during a transaction, and returns the decoded block.
+func unmarshalSkipBlock(buf []byte) (*SkipBlock, error) {
+	_, msg, err := network.Unmarshal(append([]byte{}, buf...))
+	if err != nil {
+		return nil, err
+	}
+	sb, ok := msg.(*SkipBlock)
+	if !ok {
+		return nil, errors.New("stored data is not a SkipBlock")
+	}
+	return sb, nil
+}
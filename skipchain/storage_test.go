@@ -0,0 +1,118 @@
+package skipchain
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+func TestBoltStorage(t *testing.T) {
+	l := onet.NewTCPTest()
+	_, roster, _ := l.GenTree(3, true)
+	defer l.CloseAll()
+	dir, err := ioutil.TempDir("", "skipchain")
+	log.ErrFatal(err)
+	defer os.RemoveAll(dir)
+	filename := path.Join(dir, "skipblocks.db")
+
+	st, err := NewBoltStorage(filename)
+	log.ErrFatal(err)
+	sbs := make([]*SkipBlock, 3)
+	for i := range sbs {
+		sbs[i] = NewSkipBlock()
+		sbs[i].Index = i
+		sbs[i].Roster = roster
+		sbs[i].Data = []byte{byte(i)}
+		sbs[i].updateHash()
+		log.ErrFatal(st.Store(sbs[i]))
+	}
+	n, err := st.Length()
+	log.ErrFatal(err)
+	require.Equal(t, 3, n)
+	sb, err := st.Load(sbs[1].Hash)
+	log.ErrFatal(err)
+	require.True(t, sb.Equal(sbs[1]))
+	require.Equal(t, sbs[1].Data, sb.Data)
+	sb, err = st.Load(SkipBlockID{1, 2, 3})
+	log.ErrFatal(err)
+	require.Nil(t, sb)
+	count := 0
+	log.ErrFatal(st.Iterate(func(sb *SkipBlock) error {
+		count++
+		return nil
+	}))
+	require.Equal(t, 3, count)
+	log.ErrFatal(st.Close())
+
+	// Re-open the storage and make sure the blocks are loaded lazily and
+	// changes are written back.
+	st, err = NewBoltStorage(filename)
+	log.ErrFatal(err)
+	sbm := NewSkipBlockMapStorage(st)
+	require.Equal(t, 0, len(sbm.SkipBlocks))
+	require.Equal(t, 3, sbm.Length())
+	sb = sbm.GetByID(sbs[2].Hash)
+	require.NotNil(t, sb)
+	require.Equal(t, 1, len(sbm.SkipBlocks))
+	sb.ChildSL = []SkipBlockID{sbs[0].Hash}
+	sbm.Store(sb)
+	require.Equal(t, 3, sbm.Length())
+	require.NotNil(t, sbm.GetFuzzy(sbs[1].Hash.Short()))
+	log.ErrFatal(st.Close())
+
+	st, err = NewBoltStorage(filename)
+	log.ErrFatal(err)
+	defer st.Close()
+	sbm = NewSkipBlockMapStorage(st)
+	sb = sbm.GetByID(sbs[2].Hash)
+	require.Equal(t, 1, len(sb.ChildSL))
+	require.True(t, sb.ChildSL[0].Equal(sbs[0].Hash))
+}
+
+func TestSkipBlockMapCache(t *testing.T) {
+	l := onet.NewTCPTest()
+	_, roster, _ := l.GenTree(3, true)
+	defer l.CloseAll()
+	dir, err := ioutil.TempDir("", "skipchain")
+	log.ErrFatal(err)
+	defer os.RemoveAll(dir)
+
+	st, err := NewBoltStorage(path.Join(dir, "skipblocks.db"))
+	log.ErrFatal(err)
+	sbm := NewSkipBlockMapStorage(st)
+	defer sbm.Close()
+	var first *SkipBlock
+	for i := 0; i < maxCachedBlocks+10; i++ {
+		sb := NewSkipBlock()
+		sb.Index = i
+		sb.Roster = roster
+		sb.updateHash()
+		sbm.Store(sb)
+		if first == nil {
+			first = sb
+		}
+	}
+	require.Equal(t, maxCachedBlocks+10, sbm.Length())
+	require.Equal(t, maxCachedBlocks, len(sbm.SkipBlocks))
+	require.NotNil(t, sbm.GetByID(first.Hash))
+	require.Equal(t, maxCachedBlocks, len(sbm.SkipBlocks))
+}
+
+func TestService_CloseServices(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	servers, _, _ := makeHELS(local, 2)
+	log.ErrFatal(CloseServices())
+	// the databases can only be opened again once they are closed
+	for _, srv := range local.GetServices(servers, skipchainSID) {
+		st, err := srv.(*Service).openStorage()
+		log.ErrFatal(err)
+		log.ErrFatal(st.Close())
+	}
+	log.ErrFatal(CloseServices())
+}
@@ -9,15 +9,18 @@ import (
 
 	"errors"
 
+	"crypto/sha256"
 	"encoding/binary"
 
 	"encoding/hex"
+	"sort"
 	"strings"
 
+	"github.com/dedis/cothority/bftcosi"
 	"github.com/satori/go.uuid"
 	"gopkg.in/dedis/crypto.v0/abstract"
-	"gopkg.in/dedis/crypto.v0/cosi"
 	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/crypto"
 	"gopkg.in/dedis/onet.v1/log"
 	"gopkg.in/dedis/onet.v1/network"
 )
@@ -28,6 +31,10 @@ const propagateTimeout = 10000
 // How often we save the skipchains - in seconds.
 const timeBetweenSave = 0
 
+// How many seconds the timestamp of a new block may differ from the time of
+// the conodes signing it.
+const maxTimestampDrift = 60
+
 // SkipBlockID represents the Hash of the SkipBlock
 type SkipBlockID []byte
 
@@ -92,6 +99,89 @@ func RegisterVerification(s GetService, v VerifierID, f SkipBlockVerifier) error
 	return scs.(*Service).registerVerification(v, f)
 }
 
+// VerifierContext holds the blocks a BlockVerifier needs to verify a new
+// block, as known by the conode doing the verification.
+type VerifierContext struct {
+	// Previous is the block the new block will be appended to.
+	Previous *SkipBlock
+	// Genesis is the genesis-block of the skipchain.
+	Genesis *SkipBlock
+	// Parent is the parent-block of the genesis-block, or nil if the
+	// skipchain has no parent or it is not known.
+	Parent *SkipBlock
+}
+
+// BlockVerifier is a verification of a skipchain that needs more than the
+// new block. If the block is refused, VerifyBlock returns an error, which
+// can be a *Rejection to give the reason to the client.
+type BlockVerifier interface {
+	VerifyBlock(newSB *SkipBlock, ctx *VerifierContext) error
+}
+
+// Rejection is the reason why a verifier refused a new block. If the leader
+// refuses a block, the rejection is returned to the client with the
+// ErrorRejected error-code.
+type Rejection struct {
+	// Verifier is the verifier that refused the block.
+	Verifier VerifierID
+	// Reason is a human readable description of why the block has been
+	// refused.
+	Reason string
+}
+
+// NewRejection returns a rejection with the given reason.
+func NewRejection(reason string) *Rejection {
+	return &Rejection{Reason: reason}
+}
+
+// Error returns the reason of the rejection together with the verifier.
+func (r *Rejection) Error() string {
+	return fmt.Sprintf("verifier %s refused block: %s", r.Verifier, r.Reason)
+}
+
+// Codes of the reasons a conode gives when it refuses to sign a
+// forward-link. They are passed to the leader with the bftcosi-refusal.
+const (
+	// RefuseUnknown is used if no more precise reason is given.
+	RefuseUnknown = iota
+	// RefuseUnknownBlock indicates the conode doesn't have the block
+	// the forward-link starts from.
+	RefuseUnknownBlock
+	// RefuseInvalidBlock indicates the new block is malformed or doesn't
+	// fit the previous block.
+	RefuseInvalidBlock
+	// RefuseForwardLink indicates the block already has a forward-link.
+	RefuseForwardLink
+	// RefuseRejected indicates a verifier of the skipchain refused the
+	// new block.
+	RefuseRejected
+	// RefuseView indicates the leader is not allowed to propose a block
+	// yet.
+	RefuseView
+)
+
+// RegisterBlockVerifier stores the verifier in a map and will call it
+// whenever a verification needs to be done.
+func RegisterBlockVerifier(s GetService, v VerifierID, bv BlockVerifier) error {
+	scs := s.Service(ServiceName)
+	if scs == nil {
+		return errors.New("Didn't find our service: " + ServiceName)
+	}
+	return scs.(*Service).registerBlockVerifier(v, bv)
+}
+
+// verifierFunc wraps a SkipBlockVerifier so it can be used as a
+// BlockVerifier.
+type verifierFunc SkipBlockVerifier
+
+// VerifyBlock calls the wrapped SkipBlockVerifier.
+func (f verifierFunc) VerifyBlock(newSB *SkipBlock, ctx *VerifierContext) error {
+	if !f(newSB.Hash, newSB) {
+		return NewRejection("verification failed")
+	}
+	return nil
+}
+
 var (
 	// VerifyBase checks that the base-parameters are correct, i.e.,
 	// the links are correctly set up, the height-parameters and the
@@ -144,7 +234,9 @@ type SkipBlockFix struct {
 	MaximumHeight int
 	// For deterministic SkipChains, chose a value >= 1 - higher
 	// bases mean more 'height = 1' SkipBlocks
-	// For random SkipChains, chose a value of 0
+	// For random SkipChains, chose a value of 0 - the height of every
+	// block is then derived from the collective signature of the
+	// forward-link to the previous block
 	BaseHeight int
 	// BackLink is a slice of hashes to previous SkipBlocks
 	BackLinkIDs []SkipBlockID
@@ -159,16 +251,66 @@ type SkipBlockFix struct {
 	Data []byte
 	// Roster holds the roster-definition of that SkipBlock
 	Roster *onet.Roster
+	// ClientKeys are the public keys of the clients that are allowed to
+	// append new blocks to this skipchain. If empty, every client can
+	// append blocks.
+	ClientKeys []abstract.Point
+	// Retention defines which blocks of this skipchain the conodes keep.
+	// The zero value keeps all blocks.
+	Retention RetentionPolicy
+	// Timestamp is the creation time of the block in nanoseconds since
+	// the epoch. It is set by the leader and checked by the roster before
+	// signing the block.
+	Timestamp int64
+}
+
+// RetentionPolicy defines which blocks of a skipchain a conode keeps. If
+// both KeepLast and MinHeight are set, a block is kept if it fulfills one of
+// them. Independently of the policy, the genesis-block, the latest block and
+// all blocks needed to follow the forward-links from a kept block to the
+// latest block or to append new blocks are always kept.
+type RetentionPolicy struct {
+	// KeepLast is the number of latest blocks to keep. If 0, this rule
+	// doesn't apply.
+	KeepLast int
+	// MinHeight keeps only the blocks with a height >= MinHeight. If 0,
+	// this rule doesn't apply.
+	MinHeight int
+}
+
+// KeepAll returns true if the policy doesn't prune any block.
+func (rp RetentionPolicy) KeepAll() bool {
+	return rp.KeepLast <= 0 && rp.MinHeight <= 0
+}
+
+// keep returns whether the policy keeps the block sb if latest is the
+// latest block of the skipchain.
+func (rp RetentionPolicy) keep(sb, latest *SkipBlock) bool {
+	if rp.KeepAll() {
+		return true
+	}
+	if rp.KeepLast > 0 && sb.Index > latest.Index-rp.KeepLast {
+		return true
+	}
+	return rp.MinHeight > 0 && sb.Height >= rp.MinHeight
+}
+
+// keepForever returns whether the policy keeps the block sb whatever the
+// latest block of the skipchain is.
+func (rp RetentionPolicy) keepForever(sb *SkipBlock) bool {
+	return rp.KeepAll() || rp.MinHeight > 0 && sb.Height >= rp.MinHeight
 }
 
 // SkipBlockData represents all entries - as maps are not ordered and thus
 // difficult to hash, this is as a slice to {key,data}-pairs.
+// Stored in a block, the entries are the changes to the key/value-state of
+// the skipchain: every entry sets or deletes one key.
 type SkipBlockData struct {
 	Entries []SkipBlockDataEntry
 }
 
-// Get returns the data-portion of the key. If key does not exist, it returns
-// nil.
+// Get returns the data-portion of the key. If key does not exist or is
+// deleted, it returns nil.
 func (sbd *SkipBlockData) Get(key string) []byte {
 	for _, d := range sbd.Entries {
 		if d.Key == key {
@@ -181,19 +323,38 @@ func (sbd *SkipBlockData) Get(key string) []byte {
 // Set replaces an existing entry or adds a new entry if the key is not
 // existant.
 func (sbd *SkipBlockData) Set(key string, data []byte) {
+	sbd.set(SkipBlockDataEntry{key, data, false})
+}
+
+// Delete marks the key as deleted.
+func (sbd *SkipBlockData) Delete(key string) {
+	sbd.set(SkipBlockDataEntry{key, nil, true})
+}
+
+func (sbd *SkipBlockData) set(entry SkipBlockDataEntry) {
 	for i := range sbd.Entries {
-		if sbd.Entries[i].Key == key {
-			sbd.Entries[i].Data = data
+		if sbd.Entries[i].Key == entry.Key {
+			sbd.Entries[i] = entry
 			return
 		}
 	}
-	sbd.Entries = append(sbd.Entries, SkipBlockDataEntry{key, data})
+	sbd.Entries = append(sbd.Entries, entry)
+}
+
+// RootData is the data stored in the blocks of a skipchain using
+// VerifyRoot. The private parts of the keys are supposed to be offline and
+// are used to sign the next block of the root-chain.
+type RootData struct {
+	Keys []abstract.Point
 }
 
 // SkipBlockDataEntry is one entry for the SkipBlockData.
 type SkipBlockDataEntry struct {
 	Key  string
 	Data []byte
+	// Deleted is true if the key is removed from the state of the
+	// skipchain.
+	Deleted bool
 }
 
 // addSliceToHash hashes the whole SkipBlockFix plus a slice of bytes.
@@ -204,12 +365,27 @@ func (sbf *SkipBlockFix) calculateHash() SkipBlockID {
 		sbf.BaseHeight} {
 		binary.Write(hash, binary.LittleEndian, i)
 	}
+	// The retention policy is only hashed if it is set, so that the blocks
+	// created without one keep their hash.
+	if !sbf.Retention.KeepAll() {
+		for _, i := range []int{sbf.Retention.KeepLast, sbf.Retention.MinHeight} {
+			if err := binary.Write(hash, binary.LittleEndian, int64(i)); err != nil {
+				log.Error("Couldn't hash block:", err)
+			}
+		}
+	}
 	for _, bl := range sbf.BackLinkIDs {
 		hash.Write(bl)
 	}
 	for _, v := range sbf.VerifierIDs {
 		hash.Write(v[:])
 	}
+	// Blocks without a timestamp keep their hash.
+	if sbf.Timestamp != 0 {
+		if err := binary.Write(hash, binary.LittleEndian, sbf.Timestamp); err != nil {
+			log.Error("Couldn't hash block:", err)
+		}
+	}
 	hash.Write(sbf.ParentBlockID)
 	hash.Write(sbf.GenesisID)
 	hash.Write(sbf.Data)
@@ -218,10 +394,29 @@ func (sbf *SkipBlockFix) calculateHash() SkipBlockID {
 			pub.MarshalTo(hash)
 		}
 	}
+	for _, pub := range sbf.ClientKeys {
+		pub.MarshalTo(hash)
+	}
 	buf := hash.Sum(nil)
 	return buf
 }
 
+// ClientHash returns the hash of the parts of a new block that are chosen by
+// the client: the block it is appended to, the data and the roster. This is
+// the message a client signs when appending a block to a skipchain with
+// ClientKeys.
+func (sbf *SkipBlockFix) ClientHash(previous SkipBlockID) []byte {
+	hash := network.Suite.Hash()
+	hash.Write(previous)
+	hash.Write(sbf.Data)
+	if sbf.Roster != nil {
+		for _, pub := range sbf.Roster.Publics() {
+			pub.MarshalTo(hash)
+		}
+	}
+	return hash.Sum(nil)
+}
+
 // SkipBlock represents a SkipBlock of any type - the fields that won't
 // be hashed (yet).
 type SkipBlock struct {
@@ -235,6 +430,9 @@ type SkipBlock struct {
 	// SkipLists that depend on us, given as the first SkipBlock - can
 	// be a Data or a Roster SkipBlock
 	ChildSL []SkipBlockID
+	// ClientSignature is the signature of the client on the ClientHash of
+	// this block. It is only needed if the skipchain has ClientKeys.
+	ClientSignature *crypto.SchnorrSig
 }
 
 // NewSkipBlock pre-initialises the block so it can be sent over
@@ -282,6 +480,10 @@ func (sb *SkipBlock) Copy() *SkipBlock {
 	copy(b.Hash, sb.Hash)
 	b.VerifierIDs = make([]VerifierID, len(sb.VerifierIDs))
 	copy(b.VerifierIDs, sb.VerifierIDs)
+	if sb.ClientSignature != nil {
+		sig := *sb.ClientSignature
+		b.ClientSignature = &sig
+	}
 	return b
 }
 
@@ -337,10 +539,29 @@ func (sb *SkipBlock) updateHash() SkipBlockID {
 	return sb.Hash
 }
 
-// BlockLink has the hash and a signature of a block
+// BlockLink has the hash and a signature of a block. The signature is
+// either in the compact form of bftcosi, holding the members that didn't
+// respond in the commit phase as a bitmask, or a cosi-signature with these
+// members listed in Exceptions.
 type BlockLink struct {
 	Hash      SkipBlockID
 	Signature []byte
+	// Exceptions holds the members that didn't respond in the commit
+	// phase if the signature is not in the compact form.
+	Exceptions []bftcosi.Exception
+	// Refusals holds the reasons of the members that refused to sign the
+	// link in the prepare phase of the BFT-round. They are not covered by
+	// the signature of the link, but each reason is signed by its member,
+	// so only refusals that verify with Refused are to be trusted.
+	Refusals []LinkRefusal
+}
+
+// LinkRefusal is the reason a member of the roster gave for refusing to sign
+// a forward-link, signed by that member.
+type LinkRefusal struct {
+	// Index is the position of the member in the roster.
+	Index   int
+	Refusal *bftcosi.Refusal
 }
 
 // Copy makes a deep copy of a blocklink
@@ -348,9 +569,28 @@ func (bl *BlockLink) Copy() *BlockLink {
 	sigCopy := make([]byte, len(bl.Signature))
 	copy(sigCopy, bl.Signature)
 	return &BlockLink{
-		Hash:      bl.Hash,
-		Signature: sigCopy,
+		Hash:       bl.Hash,
+		Signature:  sigCopy,
+		Exceptions: append([]bftcosi.Exception{}, bl.Exceptions...),
+		Refusals:   append([]LinkRefusal{}, bl.Refusals...),
+	}
+}
+
+// Refused returns the indexes of the members of the roster with the given
+// public keys that correctly signed their refusal to sign the link.
+func (bl *BlockLink) Refused(publics []abstract.Point) []int {
+	var refused []int
+	for _, lr := range bl.Refusals {
+		if lr.Refusal == nil || lr.Index < 0 || lr.Index >= len(publics) {
+			continue
+		}
+		if err := lr.Refusal.Verify(network.Suite, publics[lr.Index], bl.Hash); err != nil {
+			log.Lvl2("Wrong signature on refusal of", lr.Index, ":", err)
+			continue
+		}
+		refused = append(refused, lr.Index)
 	}
+	return refused
 }
 
 // VerifySignature returns whether the BlockLink has been signed
@@ -359,13 +599,64 @@ func (bl *BlockLink) VerifySignature(publics []abstract.Point) error {
 	if len(bl.Signature) == 0 {
 		return errors.New("No signature present" + log.Stack())
 	}
-	return cosi.VerifySignature(network.Suite, publics, bl.Hash, bl.Signature)
+	if len(bl.Exceptions) > 0 {
+		sig := &bftcosi.BFTSignature{
+			Sig:        bl.Signature,
+			Msg:        bl.Hash,
+			Exceptions: bl.Exceptions,
+		}
+		return sig.Verify(network.Suite, publics)
+	}
+	// a signature without participation mask has been signed by all
+	// members, else the policy has to be checked for the masked members
+	sig := bl.Signature
+	if len(sig) == network.Suite.PointLen()+network.Suite.ScalarLen() {
+		sig = append(append([]byte{}, sig...), make([]byte, (len(publics)+7)/8)...)
+	}
+	return bftcosi.VerifyCompact(network.Suite, publics, bl.Hash, sig)
+}
+
+// Absent returns the indexes of the members of the roster of n members
+// that didn't take part in the signature of the link: the members missing
+// from the participation mask and the exceptions.
+func (bl *BlockLink) Absent(n int) ([]int, error) {
+	sigLen := network.Suite.PointLen() + network.Suite.ScalarLen()
+	if len(bl.Signature) < sigLen+(n+7)/8 {
+		return nil, errors.New("signature too short for roster")
+	}
+	absent, err := bftcosi.CompactAbsent(network.Suite, n, bl.Signature)
+	if err != nil {
+		return nil, err
+	}
+	for _, ex := range bl.Exceptions {
+		absent = append(absent, ex.Index)
+	}
+	sort.Ints(absent)
+	return absent, nil
 }
 
+// signers returns how many of the n members of the roster took part in the
+// signature of the link.
+func (bl *BlockLink) signers(n int) (int, error) {
+	absent, err := bl.Absent(n)
+	if err != nil {
+		return 0, err
+	}
+	return n - len(absent), nil
+}
+
+// maxCachedBlocks is the number of blocks a SkipBlockMap with a storage
+// keeps in memory.
+const maxCachedBlocks = 1000
+
 // SkipBlockMap holds the map to the skipblocks. This is used for verification,
 // so that all links can be followed.
+// If the SkipBlockMap has a storage, every block is written to that storage
+// and the map only holds up to maxCachedBlocks of the blocks that have
+// already been used.
 type SkipBlockMap struct {
 	SkipBlocks map[string]*SkipBlock
+	storage    SkipBlockStorage
 	sync.Mutex
 }
 
@@ -374,20 +665,66 @@ func NewSkipBlockMap() *SkipBlockMap {
 	return &SkipBlockMap{SkipBlocks: make(map[string]*SkipBlock)}
 }
 
+// NewSkipBlockMapStorage returns a SkipBlockMap that writes all blocks to
+// the storage and loads them from there when they are needed.
+func NewSkipBlockMapStorage(st SkipBlockStorage) *SkipBlockMap {
+	sbm := NewSkipBlockMap()
+	sbm.storage = st
+	return sbm
+}
+
+// HasStorage returns true if the blocks are written to a storage.
+func (sbm *SkipBlockMap) HasStorage() bool {
+	return sbm.storage != nil
+}
+
 // GetByID returns the skip-block or nil if it doesn't exist
 func (sbm *SkipBlockMap) GetByID(sbID SkipBlockID) *SkipBlock {
 	sbm.Lock()
 	defer sbm.Unlock()
-	return sbm.SkipBlocks[string(sbID)].Copy()
+	return sbm.getByID(sbID).Copy()
+}
+
+// getByID returns the block from the map and loads it from the storage if
+// it is not in the map yet. The caller must hold the lock.
+func (sbm *SkipBlockMap) getByID(sbID SkipBlockID) *SkipBlock {
+	sb, exists := sbm.SkipBlocks[string(sbID)]
+	if exists || sbm.storage == nil {
+		return sb
+	}
+	sb, err := sbm.storage.Load(sbID)
+	if err != nil {
+		log.Error("Couldn't load skipblock:", err)
+		return nil
+	}
+	if sb != nil {
+		sbm.cache(sb)
+	}
+	return sb
+}
+
+// cache adds the block to the map. If the blocks are in a storage and the
+// map is full, a random block is evicted, as it can be loaded again. The
+// caller must hold the lock.
+func (sbm *SkipBlockMap) cache(sb *SkipBlock) {
+	if sbm.storage != nil && len(sbm.SkipBlocks) >= maxCachedBlocks {
+		for id := range sbm.SkipBlocks {
+			delete(sbm.SkipBlocks, id)
+			break
+		}
+	}
+	sbm.SkipBlocks[string(sb.Hash)] = sb
 }
 
 // Store stores the given SkipBlock in the service-list
 func (sbm *SkipBlockMap) Store(sb *SkipBlock) SkipBlockID {
 	sbm.Lock()
 	defer sbm.Unlock()
-	if sbOld, exists := sbm.SkipBlocks[string(sb.Hash)]; exists {
+	changed := true
+	if sbOld := sbm.getByID(sb.Hash); sbOld != nil {
 		// If this skipblock already exists, only copy forward-links and
 		// new children.
+		changed = false
 		if len(sb.ForwardLink) > len(sbOld.ForwardLink) {
 			for _, fl := range sb.ForwardLink[len(sbOld.ForwardLink):] {
 				if err := fl.VerifySignature(sbOld.Roster.Publics()); err != nil {
@@ -396,23 +733,88 @@ func (sbm *SkipBlockMap) Store(sb *SkipBlock) SkipBlockID {
 				}
 				sbOld.ForwardLink = append(sbOld.ForwardLink, fl)
 			}
+			changed = true
 		}
 		if len(sb.ChildSL) > len(sbOld.ChildSL) {
 			sbOld.ChildSL = append(sbOld.ChildSL, sb.ChildSL[len(sbOld.ChildSL):]...)
+			changed = true
 		}
+		sb = sbOld
 	} else {
-		sbm.SkipBlocks[string(sb.Hash)] = sb
+		sbm.cache(sb)
+	}
+	if changed && sbm.storage != nil {
+		if err := sbm.storage.Store(sb); err != nil {
+			log.Error("Couldn't write skipblock to storage:", err)
+		}
 	}
 	return sb.Hash
 }
 
+// Remove deletes the block from the map and the storage.
+func (sbm *SkipBlockMap) Remove(sbID SkipBlockID) error {
+	sbm.Lock()
+	defer sbm.Unlock()
+	delete(sbm.SkipBlocks, string(sbID))
+	if sbm.storage != nil {
+		return sbm.storage.Remove(sbID)
+	}
+	return nil
+}
+
+// Close closes the storage of the blocks, if there is one.
+func (sbm *SkipBlockMap) Close() error {
+	sbm.Lock()
+	defer sbm.Unlock()
+	if sbm.storage == nil {
+		return nil
+	}
+	return sbm.storage.Close()
+}
+
 // Length returns the actual length using mutexes
 func (sbm *SkipBlockMap) Length() int {
 	sbm.Lock()
 	defer sbm.Unlock()
+	if sbm.storage != nil {
+		n, err := sbm.storage.Length()
+		if err == nil {
+			return n
+		}
+		log.Error("Couldn't get length of storage:", err)
+	}
 	return len(sbm.SkipBlocks)
 }
 
+// Iterate calls f for every known skipblock. If f returns false, the
+// iteration stops. The blocks passed to f must not be changed.
+func (sbm *SkipBlockMap) Iterate(f func(sb *SkipBlock) bool) {
+	if sbm.storage != nil {
+		errStop := errors.New("stop")
+		err := sbm.storage.Iterate(func(sb *SkipBlock) error {
+			if !f(sb) {
+				return errStop
+			}
+			return nil
+		})
+		if err != nil && err != errStop {
+			log.Error("Couldn't iterate over storage:", err)
+		}
+		return
+	}
+	sbm.Lock()
+	sbs := make([]*SkipBlock, 0, len(sbm.SkipBlocks))
+	for _, sb := range sbm.SkipBlocks {
+		sbs = append(sbs, sb)
+	}
+	sbm.Unlock()
+	for _, sb := range sbs {
+		if !f(sb) {
+			return
+		}
+	}
+}
+
 // GetResponsible searches for the block that is responsible for sb
 // - Root_Genesis - himself
 // - *_Gensis - it's his parent
@@ -510,6 +912,50 @@ func (sbm *SkipBlockMap) GetLatest(sb *SkipBlock) (*SkipBlock, error) {
 	return latest, nil
 }
 
+// blocksAfter returns all known blocks following sb, in order. If a block
+// is not known, for example because it has been pruned, the lowest
+// forward-link to a known block is followed.
+func (sbm *SkipBlockMap) blocksAfter(sb *SkipBlock) []*SkipBlock {
+	var blocks []*SkipBlock
+	for sb.GetForwardLen() > 0 {
+		var next *SkipBlock
+		for _, fl := range sb.ForwardLink {
+			if next = sbm.GetByID(fl.Hash); next != nil {
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+		blocks = append(blocks, next)
+		sb = next
+	}
+	return blocks
+}
+
+// randomHeight returns the height of the block following prev in a random
+// skipchain. The height is taken from the collective signature of the
+// forward-link to prev, which is unpredictable until prev got signed: every
+// height h is chosen with a probability of 1/2^h, up to MaximumHeight. The
+// block following the genesis-block has height 1.
+func (sbm *SkipBlockMap) randomHeight(prev *SkipBlock) (int, error) {
+	if prev.Index == 0 {
+		return 1, nil
+	}
+	back := sbm.GetByID(prev.BackLinkIDs[0])
+	if back == nil || back.GetForwardLen() == 0 ||
+		!back.ForwardLink[0].Hash.Equal(prev.Hash) {
+		return 0, errors.New("Didn't find forward-link to previous block")
+	}
+	random := sha256.Sum256(back.ForwardLink[0].Signature)
+	height := 1
+	for i := 0; height < prev.MaximumHeight &&
+		random[i/8]&(1<<uint(i%8)) == 0; i++ {
+		height++
+	}
+	return height, nil
+}
+
 // GetFuzzy searches for a block that resembles the given ID, if ID is not full.
 // If there are multiple matching skipblocks, the first one is chosen. If none
 // match, nil will be returned.
@@ -519,17 +965,22 @@ func (sbm *SkipBlockMap) GetLatest(sb *SkipBlock) (*SkipBlock, error) {
 //  2. as suffix - if none is found
 //  3. anywhere
 func (sbm *SkipBlockMap) GetFuzzy(id string) *SkipBlock {
-	for _, sb := range sbm.SkipBlocks {
+	var sbs []*SkipBlock
+	sbm.Iterate(func(sb *SkipBlock) bool {
+		sbs = append(sbs, sb)
+		return true
+	})
+	for _, sb := range sbs {
 		if strings.HasPrefix(hex.EncodeToString(sb.Hash), id) {
 			return sb
 		}
 	}
-	for _, sb := range sbm.SkipBlocks {
+	for _, sb := range sbs {
 		if strings.HasSuffix(hex.EncodeToString(sb.Hash), id) {
 			return sb
 		}
 	}
-	for _, sb := range sbm.SkipBlocks {
+	for _, sb := range sbs {
 		if strings.Contains(hex.EncodeToString(sb.Hash), id) {
 			return sb
 		}
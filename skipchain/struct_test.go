@@ -145,6 +145,35 @@ func TestSign(t *testing.T) {
 	defer l.CloseAll()
 }
 
+func TestBlockLink_VerifyMasked(t *testing.T) {
+	l := onet.NewTCPTest()
+	servers, roster, _ := l.GenTree(10, true)
+	defer l.CloseAll()
+	msg := sha512.New().Sum(nil)
+	publics := roster.Publics()
+
+	// only 3 out of 10 members sign, the others are missing from the mask
+	sig, err := sign(msg, servers[:3], l)
+	log.ErrFatal(err)
+	masked := make([]byte, 64+2)
+	copy(masked, sig.Sig[:64])
+	for i := 3; i < 10; i++ {
+		masked[64+i/8] |= 1 << uint(i%8)
+	}
+	log.ErrFatal(bftcosi.VerifyCompactPolicy(network.Suite, publics, msg,
+		masked, bftcosi.ExceptionThreshold(8)))
+	bl := &BlockLink{Hash: msg, Signature: masked}
+	require.NotNil(t, bl.VerifySignature(publics))
+
+	// a signature of all members is accepted with and without mask
+	sig, err = sign(msg, servers, l)
+	log.ErrFatal(err)
+	bl.Signature = sig.Sig[:64]
+	log.ErrFatal(bl.VerifySignature(publics))
+	bl.Signature = append(sig.Sig[:64:64], 0, 0)
+	log.ErrFatal(bl.VerifySignature(publics))
+}
+
 func sign(msg SkipBlockID, servers []*onet.Server, l *onet.LocalTest) (*bftcosi.BFTSignature, error) {
 	aggScalar := network.Suite.Scalar().Zero()
 	aggPoint := network.Suite.Point().Null()
@@ -175,3 +204,17 @@ func sign(msg SkipBlockID, servers []*onet.Server, l *onet.LocalTest) (*bftcosi.
 	copy(sig[32:64], sigR)
 	return &bftcosi.BFTSignature{Sig: sig, Msg: msg, Exceptions: nil}, nil
 }
+
+func TestSkipBlock_HashRetention(t *testing.T) {
+	sb := NewSkipBlock()
+	sb.updateHash()
+	kept := sb.Copy()
+	kept.Retention = RetentionPolicy{KeepLast: 10}
+	kept.updateHash()
+	require.False(t, sb.Hash.Equal(kept.Hash))
+	minHeight := sb.Copy()
+	minHeight.Retention = RetentionPolicy{MinHeight: 2}
+	minHeight.updateHash()
+	require.False(t, sb.Hash.Equal(minHeight.Hash))
+	require.False(t, kept.Hash.Equal(minHeight.Hash))
+}
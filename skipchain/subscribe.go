@@ -0,0 +1,152 @@
+package skipchain
+
+import (
+	"errors"
+	"time"
+
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+/*
+This file holds the subscriptions to new blocks of a skipchain. Services on
+the same conode can subscribe directly using Service.Subscribe, while
+clients ask repeatedly with SubscribeSkipChain, which returns as soon as new
+blocks are available.
+*/
+
+// How many blocks a subscription can hold before the subscriber is dropped.
+const subscribeBuffer = 16
+
+// How many msec a SubscribeSkipChain-request waits for new blocks.
+const subscribeTimeout = 10000
+
+// subscription holds the channel of one subscriber and the index of the last
+// block sent to it.
+type subscription struct {
+	blocks    chan *SkipBlock
+	lastIndex int
+	closed    bool
+}
+
+// Subscribe returns a channel that receives every new block of the skipchain
+// with the given genesis-id. If from is not nil, all known blocks following
+// from are sent first, so that a subscriber can resume after a
+// reconnection. If the subscriber doesn't read the blocks fast enough, the
+// channel is closed and the subscriber has to subscribe again.
+// The returned function cancels the subscription.
+func (s *Service) Subscribe(genesis, from SkipBlockID) (<-chan *SkipBlock, func(), error) {
+	s.subscriptionsMutex.Lock()
+	defer s.subscriptionsMutex.Unlock()
+	gen := s.Sbm.GetByID(genesis)
+	if gen == nil || gen.Index != 0 {
+		return nil, nil, errors.New("Didn't find genesis-block")
+	}
+	latest, err := s.Sbm.GetLatest(gen)
+	if err != nil {
+		return nil, nil, err
+	}
+	var replay []*SkipBlock
+	lastIndex := latest.Index
+	if !from.IsNull() {
+		sb := s.Sbm.GetByID(from)
+		if sb == nil || !sb.SkipChainID().Equal(genesis) {
+			return nil, nil, errors.New("Didn't find block to resume from")
+		}
+		replay = s.Sbm.blocksAfter(sb)
+		lastIndex = sb.Index
+		if len(replay) > 0 {
+			lastIndex = replay[len(replay)-1].Index
+		}
+	}
+	sub := &subscription{
+		blocks:    make(chan *SkipBlock, len(replay)+subscribeBuffer),
+		lastIndex: lastIndex,
+	}
+	for _, sb := range replay {
+		sub.blocks <- sb
+	}
+	s.subscriptions[string(genesis)] = append(s.subscriptions[string(genesis)], sub)
+	cancel := func() {
+		s.subscriptionsMutex.Lock()
+		defer s.subscriptionsMutex.Unlock()
+		s.unsubscribe(string(genesis), sub)
+	}
+	return sub.blocks, cancel, nil
+}
+
+// SubscribeSkipChain waits for new blocks following the block From and
+// returns them. If no new block arrives before the timeout, an empty list is
+// returned and the client is supposed to ask again.
+func (s *Service) SubscribeSkipChain(req *SubscribeSkipChain) (*SubscribeSkipChainReply, onet.ClientError) {
+	blocks, cancel, err := s.Subscribe(req.Genesis, req.From)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound, err.Error())
+	}
+	defer cancel()
+	timeout := req.Timeout
+	if timeout <= 0 || timeout > subscribeTimeout {
+		timeout = subscribeTimeout
+	}
+	reply := &SubscribeSkipChainReply{}
+	select {
+	case sb, ok := <-blocks:
+		if !ok {
+			return reply, nil
+		}
+		reply.Blocks = append(reply.Blocks, sb)
+	case <-time.After(time.Millisecond * time.Duration(timeout)):
+		return reply, nil
+	}
+	// Return all other blocks that are already available.
+	for {
+		select {
+		case sb, ok := <-blocks:
+			if !ok {
+				return reply, nil
+			}
+			reply.Blocks = append(reply.Blocks, sb)
+		default:
+			return reply, nil
+		}
+	}
+}
+
+// notifySubscribers sends the new block to all subscribers of its
+// skipchain. Subscribers that are too slow are dropped.
+func (s *Service) notifySubscribers(sb *SkipBlock) {
+	s.subscriptionsMutex.Lock()
+	defer s.subscriptionsMutex.Unlock()
+	genesis := string(sb.SkipChainID())
+	for _, sub := range s.subscriptions[genesis] {
+		if sb.Index <= sub.lastIndex {
+			continue
+		}
+		select {
+		case sub.blocks <- sb:
+			sub.lastIndex = sb.Index
+		default:
+			log.Warn("Dropping slow subscriber of skipchain", sb.SkipChainID().Short())
+			s.unsubscribe(genesis, sub)
+		}
+	}
+}
+
+// unsubscribe removes the subscription and closes its channel. The caller
+// must hold subscriptionsMutex.
+func (s *Service) unsubscribe(genesis string, sub *subscription) {
+	subs := s.subscriptions[genesis]
+	for i, other := range subs {
+		if other == sub {
+			s.subscriptions[genesis] = append(subs[:i:i], subs[i+1:]...)
+			break
+		}
+	}
+	if len(s.subscriptions[genesis]) == 0 {
+		delete(s.subscriptions, genesis)
+	}
+	if !sub.closed {
+		sub.closed = true
+		close(sub.blocks)
+	}
+}
@@ -0,0 +1,76 @@
+package skipchain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+func TestService_Subscribe(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	servers, roster, s := makeHELS(local, 3)
+	genesis, err := makeGenesisRosterArgs(s, roster, nil, VerificationNone, 2, 2)
+	log.ErrFatal(err)
+	other := local.GetServices(servers, skipchainSID)[1].(*Service)
+
+	blocks, cancel, err := other.Subscribe(genesis.Hash, nil)
+	log.ErrFatal(err)
+	var stored []*SkipBlock
+	latest := genesis
+	for i := 1; i < 4; i++ {
+		sb := NewSkipBlock()
+		sb.Roster = roster
+		ssbr, cerr := s.StoreSkipBlock(&StoreSkipBlock{latest.Hash, sb, nil})
+		log.ErrFatal(cerr)
+		latest = ssbr.Latest
+		stored = append(stored, latest)
+	}
+	for _, sb := range stored {
+		require.Equal(t, sb.Hash, (<-blocks).Hash)
+	}
+	cancel()
+	_, ok := <-blocks
+	require.False(t, ok, "Channel should be closed")
+	cancel()
+
+	log.Lvl1("Resuming after the first block")
+	blocks, cancel, err = other.Subscribe(genesis.Hash, stored[0].Hash)
+	log.ErrFatal(err)
+	for _, sb := range stored[1:] {
+		require.Equal(t, sb.Hash, (<-blocks).Hash)
+	}
+	cancel()
+
+	_, _, err = other.Subscribe(stored[0].Hash, nil)
+	require.NotNil(t, err, "Should only subscribe to genesis-blocks")
+	waitPropagationFinished(local)
+}
+
+func TestClient_Subscribe(t *testing.T) {
+	l := onet.NewTCPTest()
+	_, roster, _ := l.GenTree(3, true)
+	defer l.CloseAll()
+	c := newTestClient(l)
+
+	genesis, cerr := c.CreateGenesis(roster, 2, 2, VerificationNone, nil, nil)
+	log.ErrFatal(cerr)
+	ssbr, cerr := c.StoreSkipBlock(genesis, nil, []byte{1})
+	log.ErrFatal(cerr)
+	first := ssbr.Latest
+
+	blocks, stop := c.Subscribe(roster, genesis.Hash, genesis.Hash)
+	defer stop()
+	require.Equal(t, first.Hash, (<-blocks).Hash)
+	ssbr, cerr = c.StoreSkipBlock(first, nil, []byte{2})
+	log.ErrFatal(cerr)
+	select {
+	case sb := <-blocks:
+		require.Equal(t, ssbr.Latest.Hash, sb.Hash)
+	case <-time.After(time.Second * 5):
+		t.Fatal("Didn't get new block")
+	}
+}
@@ -0,0 +1,259 @@
+package skipchain
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+/*
+This file holds the synchronisation of skipchains to conodes that join the
+roster of a skipchain. Once such a conode receives a block of a skipchain it
+doesn't fully know, it fetches the missing blocks from the members of the
+previous roster. The blocks are only stored if every forward-link is signed
+and every change of the roster has been signed by at least 2/3 of the
+previous roster.
+*/
+
+// How many msec to wait for the blocks of a skipchain.
+const syncTimeout = 60000
+
+// needsSync returns true if this conode is in the roster of sb but doesn't
+// know all blocks from the genesis-block up to sb.
+func (s *Service) needsSync(sb *SkipBlock) bool {
+	if i, _ := sb.Roster.Search(s.ServerIdentity().ID); i < 0 {
+		return false
+	}
+	genesis := s.Sbm.GetByID(sb.SkipChainID())
+	if genesis == nil {
+		return true
+	}
+	return s.lastKnown(genesis).Index < sb.Index
+}
+
+// lastKnown follows the highest known forward-links starting at sb and
+// returns the last block it finds.
+func (s *Service) lastKnown(sb *SkipBlock) *SkipBlock {
+	for {
+		var next *SkipBlock
+		for h := sb.GetForwardLen() - 1; h >= 0 && next == nil; h-- {
+			next = s.Sbm.GetByID(sb.ForwardLink[h].Hash)
+		}
+		if next == nil {
+			return sb
+		}
+		sb = next
+	}
+}
+
+// syncSkipChain fetches the blocks of the skipchain of newest that are
+// missing on this conode, starting at the last known block. It asks the
+// members of the roster of the block preceding newest, one after the other,
+// until one of them returns a valid chain of blocks.
+func (s *Service) syncSkipChain(newest *SkipBlock) error {
+	genesisID := newest.SkipChainID()
+	if !s.syncStart(genesisID) {
+		log.Lvl3("Already syncing", genesisID.Short())
+		return nil
+	}
+	defer s.syncEnd(genesisID)
+
+	var from *SkipBlock
+	if genesis := s.Sbm.GetByID(genesisID); genesis != nil {
+		from = s.lastKnown(genesis)
+	}
+	roster := newest.Roster
+	if newest.Index > 0 {
+		if prev := s.Sbm.GetByID(newest.BackLinkIDs[0]); prev != nil {
+			roster = prev.Roster
+		}
+	}
+	err := errors.New("no other conode in the roster")
+	for _, i := range rand.Perm(len(roster.List)) {
+		si := roster.List[i]
+		if si.ID.Equal(s.ServerIdentity().ID) {
+			continue
+		}
+		var blocks []*SkipBlock
+		blocks, err = s.requestSync(si, genesisID, from)
+		if err == nil {
+			err = verifyChain(genesisID, from, blocks)
+		}
+		if err != nil {
+			log.Lvl2("Couldn't sync from", si, ":", err)
+			continue
+		}
+		log.Lvlf2("%s got %d blocks of %x", s.ServerIdentity(), len(blocks),
+			[]byte(genesisID))
+		for _, sb := range blocks {
+			s.Sbm.Store(sb)
+		}
+		s.save()
+		if err := s.updateState(genesisID); err != nil {
+			return err
+		}
+		// The synced blocks may be older than the last pruned ones.
+		s.resetPruning(genesisID)
+		return s.pruneSkipChain(genesisID)
+	}
+	return errors.New("Couldn't sync skipchain: " + err.Error())
+}
+
+// syncStart returns false if the skipchain is already being synced, else it
+// marks it as being synced and returns true.
+func (s *Service) syncStart(genesis SkipBlockID) bool {
+	s.syncMutex.Lock()
+	defer s.syncMutex.Unlock()
+	if s.syncing[string(genesis)] {
+		return false
+	}
+	s.syncing[string(genesis)] = true
+	return true
+}
+
+// syncEnd marks the skipchain as not being synced anymore.
+func (s *Service) syncEnd(genesis SkipBlockID) {
+	s.syncMutex.Lock()
+	defer s.syncMutex.Unlock()
+	delete(s.syncing, string(genesis))
+}
+
+// requestSync asks si for all blocks of the skipchain following from, or
+// for the whole skipchain if from is nil.
+func (s *Service) requestSync(si *network.ServerIdentity, genesis SkipBlockID,
+	from *SkipBlock) ([]*SkipBlock, error) {
+	req := &SyncChain{Genesis: genesis}
+	if from != nil {
+		req.From = from.Hash
+	}
+	reply := make(chan []*SkipBlock, 1)
+	s.syncMutex.Lock()
+	s.syncRequests[string(genesis)] = reply
+	s.syncMutex.Unlock()
+	defer func() {
+		s.syncMutex.Lock()
+		delete(s.syncRequests, string(genesis))
+		s.syncMutex.Unlock()
+	}()
+	if err := s.SendRaw(si, req); err != nil {
+		return nil, err
+	}
+	select {
+	case blocks := <-reply:
+		return blocks, nil
+	case <-time.After(time.Millisecond * syncTimeout):
+		return nil, errors.New("timed out while waiting for blocks")
+	}
+}
+
+// syncChain returns the requested blocks to a conode that joined the roster
+// of a skipchain.
+func (s *Service) syncChain(env *network.Envelope) {
+	req, ok := env.Msg.(*SyncChain)
+	if !ok {
+		log.Error("Didn't receive SyncChain")
+		return
+	}
+	reply := &SyncChainReply{Genesis: req.Genesis}
+	if req.From.IsNull() {
+		if genesis := s.Sbm.GetByID(req.Genesis); genesis != nil {
+			reply.Blocks = append([]*SkipBlock{genesis},
+				s.Sbm.blocksAfter(genesis)...)
+		}
+	} else {
+		from := s.Sbm.GetByID(req.From)
+		if from != nil && from.SkipChainID().Equal(req.Genesis) {
+			reply.Blocks = s.Sbm.blocksAfter(from)
+		}
+	}
+	if err := s.SendRaw(env.ServerIdentity, reply); err != nil {
+		log.Error(err)
+	}
+}
+
+// syncChainReply passes the received blocks to the waiting requestSync.
+func (s *Service) syncChainReply(env *network.Envelope) {
+	reply, ok := env.Msg.(*SyncChainReply)
+	if !ok {
+		log.Error("Didn't receive SyncChainReply")
+		return
+	}
+	s.syncMutex.Lock()
+	defer s.syncMutex.Unlock()
+	request, ok := s.syncRequests[string(reply.Genesis)]
+	if !ok {
+		log.Lvl2("Got blocks nobody asked for")
+		return
+	}
+	select {
+	case request <- reply.Blocks:
+	default:
+		log.Lvl2("Already got blocks for", reply.Genesis.Short())
+	}
+}
+
+// verifyChain makes sure that the blocks follow each other, starting at
+// prev, or at the genesis-block if prev is nil. Every block has to be linked
+// by a forward-link collectively signed by the roster of the block before,
+// and a change of the roster has to be signed by at least 2/3 of the
+// previous roster.
+func verifyChain(genesisID SkipBlockID, prev *SkipBlock, blocks []*SkipBlock) error {
+	if len(blocks) == 0 {
+		return errors.New("no blocks")
+	}
+	for i, sb := range blocks {
+		if sb.SkipBlockFix == nil || sb.Roster == nil {
+			return fmt.Errorf("block %d is incomplete", i)
+		}
+		if !sb.calculateHash().Equal(sb.Hash) {
+			return fmt.Errorf("wrong hash of block %d", i)
+		}
+		if prev == nil {
+			if sb.Index != 0 || !sb.Hash.Equal(genesisID) {
+				return errors.New("chain doesn't start with the genesis-block")
+			}
+			prev = sb
+			continue
+		}
+		if !sb.GenesisID.Equal(genesisID) {
+			return fmt.Errorf("block %d is from another skipchain", i)
+		}
+		if sb.Index <= prev.Index {
+			return fmt.Errorf("block %d doesn't follow the previous block", i)
+		}
+		link := prev.linkTo(sb.Hash)
+		if link == nil {
+			return fmt.Errorf("no forward-link to block %d", i)
+		}
+		if err := link.VerifySignature(prev.Roster.Publics()); err != nil {
+			return fmt.Errorf("wrong forward-link to block %d: %s", i, err)
+		}
+		if err := verifyHandover(prev, sb, link); err != nil {
+			return fmt.Errorf("wrong handover to block %d: %s", i, err)
+		}
+		prev = sb
+	}
+	return nil
+}
+
+// verifyHandover makes sure that at least 2/3 of the roster of prev signed
+// the forward-link to next if the roster changed between the two blocks.
+func verifyHandover(prev, next *SkipBlock, link *BlockLink) error {
+	if rosterChange(prev.Roster, next.Roster) == 0 {
+		return nil
+	}
+	n := len(prev.Roster.List)
+	signers, err := link.signers(n)
+	if err != nil {
+		return err
+	}
+	if signers*3 < n*2 {
+		return fmt.Errorf("only %d out of %d members signed the new roster",
+			signers, n)
+	}
+	return nil
+}
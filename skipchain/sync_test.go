@@ -0,0 +1,84 @@
+package skipchain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+func TestService_SyncNewMember(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	servers, roster, s := makeHELS(local, 4)
+	joining := local.GetServices(servers, skipchainSID)[3].(*Service)
+	oldRoster := onet.NewRoster(roster.List[:3])
+
+	genesis, err := makeGenesisRosterArgs(s, oldRoster, nil, VerificationNone, 2, 2)
+	log.ErrFatal(err)
+	blocks := []*SkipBlock{genesis}
+	latest := genesis
+	for i := 1; i < 6; i++ {
+		sb := NewSkipBlock()
+		sb.Roster = oldRoster
+		if i == 5 {
+			sb.Roster = roster
+		}
+		ssbr, cerr := s.StoreSkipBlock(&StoreSkipBlock{latest.Hash, sb, nil})
+		log.ErrFatal(cerr)
+		latest = ssbr.Latest
+		blocks = append(blocks, latest)
+	}
+	waitPropagationFinished(local)
+
+	for i := 0; i < 50; i++ {
+		if gen := joining.Sbm.GetByID(genesis.Hash); gen != nil &&
+			joining.lastKnown(gen).Equal(latest) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	for _, sb := range blocks {
+		require.NotNil(t, joining.Sbm.GetByID(sb.Hash), "Missing block", sb.Index)
+	}
+
+	log.Lvl1("New member can add blocks")
+	sb := NewSkipBlock()
+	sb.Roster = roster
+	_, cerr := s.StoreSkipBlock(&StoreSkipBlock{latest.Hash, sb, nil})
+	log.ErrFatal(cerr)
+	waitPropagationFinished(local)
+}
+
+func TestVerifyHandover(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, roster, s := makeHELS(local, 4)
+	oldRoster := onet.NewRoster(roster.List[:3])
+	genesis, err := makeGenesisRosterArgs(s, oldRoster, nil, VerificationNone, 1, 1)
+	log.ErrFatal(err)
+	sb := NewSkipBlock()
+	sb.Roster = roster
+	ssbr, cerr := s.StoreSkipBlock(&StoreSkipBlock{genesis.Hash, sb, nil})
+	log.ErrFatal(cerr)
+	prev, next := ssbr.Previous, ssbr.Latest
+	link := prev.ForwardLink[0]
+	log.ErrFatal(verifyHandover(prev, next, link))
+	log.ErrFatal(verifyChain(genesis.Hash, nil, []*SkipBlock{prev, next}))
+
+	log.Lvl1("Accepting one missing signer out of three")
+	missing := link.Copy()
+	sigLen := len(missing.Signature) - 1
+	missing.Signature[sigLen] |= 1
+	log.ErrFatal(verifyHandover(prev, next, missing))
+
+	log.Lvl1("Refusing two missing signers out of three")
+	missing.Signature[sigLen] |= 2
+	require.NotNil(t, verifyHandover(prev, next, missing))
+
+	log.Lvl1("Not checking links without roster change")
+	require.Nil(t, verifyHandover(prev, prev, missing))
+	waitPropagationFinished(local)
+}
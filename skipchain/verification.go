@@ -1,6 +1,14 @@
 package skipchain
 
-import "gopkg.in/dedis/onet.v1/log"
+import (
+	"errors"
+
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/crypto"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
 
 /*
 This file holds all verification-functions for the skipchain.
@@ -14,6 +22,10 @@ func (s *Service) verifyFuncBase(newID []byte, newSB *SkipBlock) bool {
 	if s.verifyBlock(newSB) != nil {
 		return false
 	}
+	if err := s.verifyClientSignature(newSB); err != nil {
+		log.Lvl2(err)
+		return false
+	}
 	log.Lvl4("No verification - accepted")
 	return true
 }
@@ -23,14 +35,77 @@ func (s *Service) verifyFuncBase(newID []byte, newSB *SkipBlock) bool {
 // keys are supposed to be offline. It makes sure
 // that every new block is signed by the keys present in the previous block.
 func (s *Service) verifyFuncRoot(newID []byte, newSB *SkipBlock) bool {
-	return true
+	if newSB.Index == 0 {
+		return true
+	}
+	prev := s.Sbm.GetByID(newSB.BackLinkIDs[0])
+	if prev == nil {
+		log.Lvl3("Previous skipblock doesn't exist")
+		return false
+	}
+	keys, err := getRootKeys(prev)
+	if err != nil {
+		log.Lvl3("Previous skipblock has no keys:", err)
+		return false
+	}
+	if _, err := getRootKeys(newSB); err != nil {
+		log.Lvl3("New skipblock has no keys:", err)
+		return false
+	}
+	if newSB.ClientSignature == nil {
+		log.Lvl3("New skipblock is not signed")
+		return false
+	}
+	msg := newSB.ClientHash(prev.Hash)
+	for _, pub := range keys {
+		if crypto.VerifySchnorr(network.Suite, pub, msg,
+			*newSB.ClientSignature) == nil {
+			return true
+		}
+	}
+	log.Lvl3("New skipblock is not signed by the keys of the previous skipblock")
+	return false
 }
 
 // VerifyControl makes sure this chain is a child of a Root-chain and
-// that there is now new block if a newer parent is present.
+// that there is no new block if a newer parent is present.
 // It also makes sure that no more than 1/3 of the members of the roster
-// change between two blocks.
+// are replaced between two blocks.
 func (s *Service) verifyFuncControl(newID []byte, newSB *SkipBlock) bool {
+	if newSB.Index == 0 {
+		return true
+	}
+	genesis := s.Sbm.GetByID(newSB.GenesisID)
+	if genesis == nil {
+		log.Lvl3("Genesis skipblock doesn't exist")
+		return false
+	}
+	if genesis.ParentBlockID.IsNull() {
+		log.Lvl3("No parent skipblock to verify against")
+		return false
+	}
+	sbParent := s.Sbm.GetByID(genesis.ParentBlockID)
+	if sbParent == nil {
+		log.Lvl3("Parent skipblock doesn't exist")
+		return false
+	}
+	if !hasVerifier(sbParent.VerifierIDs, VerifyRoot) {
+		log.Lvl3("Parent skipblock is not from a root-chain")
+		return false
+	}
+	if sbParent.GetForwardLen() > 0 {
+		log.Lvl3("A newer parent skipblock is present")
+		return false
+	}
+	prev := s.Sbm.GetByID(newSB.BackLinkIDs[0])
+	if prev == nil {
+		log.Lvl3("Previous skipblock doesn't exist")
+		return false
+	}
+	if rosterChange(prev.Roster, newSB.Roster)*3 > len(prev.Roster.List) {
+		log.Lvl3("More than 1/3 of the roster changed")
+		return false
+	}
 	return true
 }
 
@@ -56,3 +131,50 @@ func (s *Service) verifyFuncData(newID []byte, newSB *SkipBlock) bool {
 	}
 	return true
 }
+
+// getRootKeys returns the keys stored as RootData in the skipblock.
+func getRootKeys(sb *SkipBlock) ([]abstract.Point, error) {
+	_, msg, err := network.Unmarshal(sb.Data)
+	if err != nil {
+		return nil, err
+	}
+	rd, ok := msg.(*RootData)
+	if !ok {
+		return nil, errors.New("data is not of type RootData")
+	}
+	if len(rd.Keys) == 0 {
+		return nil, errors.New("no keys in RootData")
+	}
+	return rd.Keys, nil
+}
+
+// hasVerifier returns true if ver is part of the verifiers.
+func hasVerifier(verifiers []VerifierID, ver VerifierID) bool {
+	for _, v := range verifiers {
+		if v.Equal(ver) {
+			return true
+		}
+	}
+	return false
+}
+
+// rosterChange returns how many members of the roster prev have been
+// replaced to get to the roster next. A member replaced by a new one counts
+// once, as does a member added or removed without replacement.
+func rosterChange(prev, next *onet.Roster) int {
+	removed, added := 0, 0
+	for _, si := range prev.List {
+		if i, _ := next.Search(si.ID); i < 0 {
+			removed++
+		}
+	}
+	for _, si := range next.List {
+		if i, _ := prev.Search(si.ID); i < 0 {
+			added++
+		}
+	}
+	if added > removed {
+		return added
+	}
+	return removed
+}
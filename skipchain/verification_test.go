@@ -0,0 +1,240 @@
+package skipchain
+
+import (
+	"testing"
+
+	"github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/config"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/crypto"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func TestService_VerifyRoot(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, roster, s := makeHELS(local, 3)
+	kp := config.NewKeyPair(network.Suite)
+	kpWrong := config.NewKeyPair(network.Suite)
+	keys := &RootData{[]abstract.Point{kp.Public}}
+
+	root, err := makeGenesisData(s, roster, nil, VerificationRoot, keys)
+	log.ErrFatal(err)
+
+	log.Lvl1("Accepting correctly signed block")
+	next := makeNextBlock(root, roster, keys)
+	signNextBlock(next, root, kp.Secret)
+	require.True(t, s.verifyFuncRoot(next.Hash, next))
+
+	log.Lvl1("Refusing unsigned block")
+	next.ClientSignature = nil
+	require.False(t, s.verifyFuncRoot(next.Hash, next))
+
+	log.Lvl1("Refusing block signed by wrong key")
+	signNextBlock(next, root, kpWrong.Secret)
+	require.False(t, s.verifyFuncRoot(next.Hash, next))
+
+	log.Lvl1("Refusing block without keys")
+	next = makeNextBlock(root, roster, nil)
+	signNextBlock(next, root, kp.Secret)
+	require.False(t, s.verifyFuncRoot(next.Hash, next))
+
+	log.Lvl1("Refusing block with unknown previous block")
+	next = makeNextBlock(root, roster, keys)
+	next.BackLinkIDs = []SkipBlockID{SkipBlockID{1, 2, 3}}
+	signNextBlock(next, root, kp.Secret)
+	require.False(t, s.verifyFuncRoot(next.Hash, next))
+
+	log.Lvl1("Refusing block if previous block has no keys")
+	rootNoKeys, err := makeGenesisData(s, roster, nil, VerificationRoot, nil)
+	log.ErrFatal(err)
+	next = makeNextBlock(rootNoKeys, roster, keys)
+	signNextBlock(next, rootNoKeys, kp.Secret)
+	require.False(t, s.verifyFuncRoot(next.Hash, next))
+
+	log.Lvl1("Storing signed block")
+	next = NewSkipBlock()
+	next.Roster = roster
+	next.Data, err = network.Marshal(keys)
+	log.ErrFatal(err)
+	sig, err := crypto.SignSchnorr(network.Suite, kp.Secret,
+		next.ClientHash(root.Hash))
+	log.ErrFatal(err)
+	_, cerr := s.StoreSkipBlock(&StoreSkipBlock{root.Hash, next, &sig})
+	log.ErrFatal(cerr)
+	waitPropagationFinished(local)
+}
+
+func TestService_VerifyControl(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, all, s := makeHELS(local, 8)
+	roster := onet.NewRoster(all.List[:6])
+	kp := config.NewKeyPair(network.Suite)
+	keys := &RootData{[]abstract.Point{kp.Public}}
+
+	root, err := makeGenesisData(s, roster, nil, VerificationRoot, keys)
+	log.ErrFatal(err)
+	control, err := makeGenesisData(s, roster, root.Hash, VerificationControl, nil)
+	log.ErrFatal(err)
+
+	log.Lvl1("Accepting block with same roster")
+	next := makeNextBlock(control, roster, nil)
+	require.True(t, s.verifyFuncControl(next.Hash, next))
+
+	log.Lvl1("Accepting block with 1/3 of the roster changed")
+	next = makeNextBlock(control, onet.NewRoster(roster.List[2:]), nil)
+	require.True(t, s.verifyFuncControl(next.Hash, next))
+
+	log.Lvl1("Accepting block with 1/3 of the roster replaced")
+	next = makeNextBlock(control, onet.NewRoster(all.List[2:]), nil)
+	require.True(t, s.verifyFuncControl(next.Hash, next))
+
+	log.Lvl1("Refusing block with more than 1/3 of the roster changed")
+	next = makeNextBlock(control, onet.NewRoster(roster.List[3:]), nil)
+	require.False(t, s.verifyFuncControl(next.Hash, next))
+
+	log.Lvl1("Refusing block without parent")
+	noParent, err := makeGenesisData(s, roster, nil, VerificationControl, nil)
+	log.ErrFatal(err)
+	next = makeNextBlock(noParent, roster, nil)
+	require.False(t, s.verifyFuncControl(next.Hash, next))
+
+	log.Lvl1("Refusing block whose parent is not a root-chain")
+	notRoot, err := makeGenesisData(s, roster, nil, VerificationNone, nil)
+	log.ErrFatal(err)
+	wrongParent, err := makeGenesisData(s, roster, notRoot.Hash,
+		VerificationControl, nil)
+	log.ErrFatal(err)
+	next = makeNextBlock(wrongParent, roster, nil)
+	require.False(t, s.verifyFuncControl(next.Hash, next))
+
+	log.Lvl1("Storing block while parent is the latest root-block")
+	next = NewSkipBlock()
+	next.Roster = roster
+	_, cerr := s.StoreSkipBlock(&StoreSkipBlock{control.Hash, next, nil})
+	log.ErrFatal(cerr)
+	control = s.Sbm.GetByID(control.Hash)
+
+	log.Lvl1("Refusing block if a newer parent is present")
+	nextRoot := NewSkipBlock()
+	nextRoot.Roster = roster
+	nextRoot.Data, err = network.Marshal(keys)
+	log.ErrFatal(err)
+	sig, err := crypto.SignSchnorr(network.Suite, kp.Secret,
+		nextRoot.ClientHash(root.Hash))
+	log.ErrFatal(err)
+	_, cerr = s.StoreSkipBlock(&StoreSkipBlock{root.Hash, nextRoot, &sig})
+	log.ErrFatal(cerr)
+	latest, err := s.Sbm.GetLatest(control)
+	log.ErrFatal(err)
+	next = makeNextBlock(latest, roster, nil)
+	require.False(t, s.verifyFuncControl(next.Hash, next))
+	waitPropagationFinished(local)
+}
+
+// testVerifier refuses all blocks holding data and records the context it
+// got.
+type testVerifier struct {
+	ctx chan *VerifierContext
+}
+
+func (tv *testVerifier) VerifyBlock(newSB *SkipBlock, ctx *VerifierContext) error {
+	tv.ctx <- ctx
+	if len(newSB.Data) > 0 {
+		return NewRejection("blocks must be empty")
+	}
+	return nil
+}
+
+func TestService_BlockVerifier(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	servers, roster, s := makeHELS(local, 3)
+	verifyID := VerifierID(uuid.NewV5(uuid.NamespaceURL, "TestBlockVerifier"))
+	tv := &testVerifier{make(chan *VerifierContext, 6)}
+	for _, srv := range local.GetServices(servers, skipchainSID) {
+		log.ErrFatal(srv.(*Service).registerBlockVerifier(verifyID, tv))
+	}
+	genesis, err := makeGenesisRosterArgs(s, roster, nil, []VerifierID{verifyID}, 1, 1)
+	log.ErrFatal(err)
+
+	log.Lvl1("Accepting an empty block")
+	sb := NewSkipBlock()
+	sb.Roster = roster
+	_, cerr := s.StoreSkipBlock(&StoreSkipBlock{genesis.Hash, sb, nil})
+	log.ErrFatal(cerr)
+	for i := 0; i < 3; i++ {
+		ctx := <-tv.ctx
+		require.True(t, ctx.Previous.Hash.Equal(genesis.Hash))
+		require.True(t, ctx.Genesis.Hash.Equal(genesis.Hash))
+		require.Nil(t, ctx.Parent)
+	}
+	waitPropagationFinished(local)
+
+	log.Lvl1("Returning the reason of the rejection")
+	latest := s.Sbm.GetByID(genesis.Hash)
+	latest, err = s.Sbm.GetLatest(latest)
+	log.ErrFatal(err)
+	sb = NewSkipBlock()
+	sb.Roster = roster
+	sb.Data = []byte{1}
+	_, cerr = s.StoreSkipBlock(&StoreSkipBlock{latest.Hash, sb, nil})
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorRejected, cerr.ErrorCode())
+	require.Contains(t, cerr.Error(), "blocks must be empty")
+}
+
+// makeGenesisData creates a genesis-block with the given data.
+func makeGenesisData(s *Service, el *onet.Roster, parent SkipBlockID,
+	vid []VerifierID, data network.Message) (*SkipBlock, error) {
+	sb := NewSkipBlock()
+	sb.Roster = el
+	sb.MaximumHeight = 1
+	sb.BaseHeight = 1
+	sb.ParentBlockID = parent
+	sb.VerifierIDs = vid
+	if data != nil {
+		var err error
+		sb.Data, err = network.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	psbr, cerr := s.StoreSkipBlock(&StoreSkipBlock{nil, sb, nil})
+	if cerr != nil {
+		return nil, cerr
+	}
+	return psbr.Latest, nil
+}
+
+// makeNextBlock returns a block that could follow prev, without storing it.
+func makeNextBlock(prev *SkipBlock, el *onet.Roster, data network.Message) *SkipBlock {
+	sb := prev.Copy()
+	sb.Index = prev.Index + 1
+	sb.Height = 1
+	sb.GenesisID = prev.SkipChainID()
+	sb.ParentBlockID = nil
+	sb.BackLinkIDs = []SkipBlockID{prev.Hash}
+	sb.ForwardLink = nil
+	sb.ChildSL = nil
+	sb.Roster = el
+	sb.Data = []byte{}
+	if data != nil {
+		buf, err := network.Marshal(data)
+		log.ErrFatal(err)
+		sb.Data = buf
+	}
+	sb.updateHash()
+	return sb
+}
+
+// signNextBlock adds the client-signature of priv to sb.
+func signNextBlock(sb, prev *SkipBlock, priv abstract.Scalar) {
+	sig, err := crypto.SignSchnorr(network.Suite, priv, sb.ClientHash(prev.Hash))
+	log.ErrFatal(err)
+	sb.ClientSignature = &sig
+}
@@ -0,0 +1,192 @@
+package skipchain
+
+import (
+	"errors"
+	"time"
+
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+/*
+This file holds the rotation of the leader of a skipchain. The members of the
+roster of the latest block are the leaders of the consecutive views: the
+first member may append a new block at any time, the member at position v
+only once the leaders of all views before v have been silent for
+viewChangeTimeout msecs each.
+
+Every member measures the silence itself: its timer for a block starts when
+it is first asked for a successor of that block, either by a proposal or by
+a ViewChange sent by a member that got a request for a new block, and
+restarts whenever it verifies a proposal of the leader of the current view.
+A member refuses to verify a proposal of a leader whose view didn't start
+yet.
+
+To make sure that one leader can't get two different successors of the
+same block signed, every member only verifies one successor per block and
+view, and doesn't go back to an older view. If the leader goes silent after
+the members verified its successor, the leader of the next view can still
+get its own successor signed.
+*/
+
+// How many msecs the member of a roster waits for the member before it to
+// append a new block.
+const viewChangeTimeout = 5000
+
+// How many msecs before the start of its view a member accepts a proposal of
+// a leader, as the timers of the members don't start at exactly the same
+// time.
+const viewChangeMargin = 500
+
+// viewTimer is the view a member is in for a block and the time that view
+// started.
+type viewTimer struct {
+	view  int
+	start time.Time
+}
+
+// successorLock is the successor of a block a member verified, and the view
+// of the leader that proposed it.
+type successorLock struct {
+	view int
+	next SkipBlockID
+}
+
+// current returns the view at the given time. Every viewChangeTimeout msecs
+// of silence of the leader, the next view starts.
+func (vt viewTimer) current(now time.Time) int {
+	return vt.view + int(now.Sub(vt.start)/(viewChangeTimeout*time.Millisecond))
+}
+
+// waitForView waits until this conode is allowed to append a block to prev
+// as the leader of the given view. The other members of the roster are
+// asked to start their timers for prev. It returns an error if in the
+// meantime another block has been appended to prev.
+func (s *Service) waitForView(prev *SkipBlock, view int) error {
+	s.forgetViews()
+	s.startView(prev.Hash)
+	for _, si := range prev.Roster.List {
+		if si.ID.Equal(s.ServerIdentity().ID) {
+			continue
+		}
+		if err := s.SendRaw(si, &ViewChange{prev.Hash}); err != nil {
+			log.Lvl2("Couldn't send view-change to", si, err)
+		}
+	}
+	log.Lvl2(s.ServerIdentity(), "waits for view", view)
+	deadline := time.Now().Add(2 * time.Duration(view) *
+		viewChangeTimeout * time.Millisecond)
+	for !s.viewStarted(prev.Hash, view, 0) {
+		latest := s.Sbm.GetByID(prev.Hash)
+		if latest != nil && latest.GetForwardLen() > 0 {
+			return errors.New("the latest block already has a follower")
+		}
+		if time.Now().After(deadline) {
+			return errors.New("view didn't start in time")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil
+}
+
+// viewChange starts the timer of the block of the ViewChange, if the
+// sender is a member of the roster of that block.
+func (s *Service) viewChange(env *network.Envelope) {
+	vc, ok := env.Msg.(*ViewChange)
+	if !ok {
+		log.Error("Didn't receive a ViewChange")
+		return
+	}
+	prev := s.Sbm.GetByID(vc.Previous)
+	if prev == nil {
+		log.Lvl2("Got view-change for unknown block")
+		return
+	}
+	if i, _ := prev.Roster.Search(env.ServerIdentity.ID); i < 0 {
+		log.Lvl2("Got view-change from a conode outside of the roster")
+		return
+	}
+	s.forgetViews()
+	s.startView(prev.Hash)
+}
+
+// startView starts the timer of the views for a successor of prev, unless
+// it already runs.
+func (s *Service) startView(prev SkipBlockID) {
+	s.successorsMutex.Lock()
+	defer s.successorsMutex.Unlock()
+	if _, ok := s.views[string(prev)]; !ok {
+		s.views[string(prev)] = viewTimer{0, time.Now()}
+	}
+}
+
+// viewStarted returns true if the view for a successor of prev started
+// at least margin msecs ago. The timer is started if it isn't running yet.
+func (s *Service) viewStarted(prev SkipBlockID, view int, margin int) bool {
+	s.successorsMutex.Lock()
+	defer s.successorsMutex.Unlock()
+	vt, ok := s.views[string(prev)]
+	if !ok {
+		vt = viewTimer{0, time.Now()}
+		s.views[string(prev)] = vt
+	}
+	now := time.Now().Add(time.Duration(margin) * time.Millisecond)
+	return vt.current(now) >= view
+}
+
+// leaderActive restarts the timer of prev in the given view, if it is not
+// behind the current view, as its leader proposed a successor.
+func (s *Service) leaderActive(prev SkipBlockID, view int) {
+	s.successorsMutex.Lock()
+	defer s.successorsMutex.Unlock()
+	if vt, ok := s.views[string(prev)]; ok && vt.current(time.Now()) > view {
+		return
+	}
+	s.views[string(prev)] = viewTimer{view, time.Now()}
+}
+
+// lockSuccessor returns true if the member didn't verify another successor
+// of prev in the same view or a successor in a later view, and remembers
+// that it verified next in the given view.
+func (s *Service) lockSuccessor(prev SkipBlockID, view int, next SkipBlockID) bool {
+	s.successorsMutex.Lock()
+	defer s.successorsMutex.Unlock()
+	if lock, ok := s.successors[string(prev)]; ok {
+		if lock.view > view || (lock.view == view && !lock.next.Equal(next)) {
+			return false
+		}
+	}
+	s.successors[string(prev)] = successorLock{view, next}
+	return true
+}
+
+// unlockSuccessor removes the lock and the timer once the block has a
+// forward-link.
+func (s *Service) unlockSuccessor(prev SkipBlockID) {
+	s.successorsMutex.Lock()
+	defer s.successorsMutex.Unlock()
+	delete(s.successors, string(prev))
+	delete(s.views, string(prev))
+}
+
+// forgetViews removes the locks and timers of the blocks that got a
+// forward-link or aren't stored anymore, for the case where the
+// propagation of the forward-link didn't reach us.
+func (s *Service) forgetViews() {
+	s.successorsMutex.Lock()
+	var ids []SkipBlockID
+	for id := range s.views {
+		ids = append(ids, SkipBlockID(id))
+	}
+	for id := range s.successors {
+		if _, ok := s.views[id]; !ok {
+			ids = append(ids, SkipBlockID(id))
+		}
+	}
+	s.successorsMutex.Unlock()
+	for _, id := range ids {
+		if sb := s.Sbm.GetByID(id); sb == nil || sb.GetForwardLen() > 0 {
+			s.unlockSuccessor(id)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package skipchain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func TestService_StoreSkipBlockView(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	servers, roster, s := makeHELS(local, 3)
+	next := local.GetServices(servers, skipchainSID)[1].(*Service)
+	genesis, err := makeGenesisRosterArgs(s, roster, nil, VerificationNone, 2, 2)
+	log.ErrFatal(err)
+
+	log.Lvl1("Only the first member creates skipchains")
+	sb := NewSkipBlock()
+	sb.Roster = roster
+	sb.MaximumHeight = 2
+	sb.BaseHeight = 2
+	_, cerr := next.StoreSkipBlock(&StoreSkipBlock{nil, sb, nil})
+	require.NotNil(t, cerr)
+
+	log.Lvl1("The second member waits for its view")
+	sb = NewSkipBlock()
+	sb.Roster = roster
+	start := time.Now()
+	ssbr, cerr := next.StoreSkipBlock(&StoreSkipBlock{genesis.Hash, sb, nil})
+	log.ErrFatal(cerr)
+	require.True(t, time.Since(start) >= viewChangeTimeout*time.Millisecond)
+	latest := ssbr.Latest
+	require.Equal(t, 1, latest.Index)
+
+	log.Lvl1("The second member doesn't append to a block with a follower")
+	sb = NewSkipBlock()
+	sb.Roster = roster
+	_, cerr = next.StoreSkipBlock(&StoreSkipBlock{genesis.Hash, sb, nil})
+	require.NotNil(t, cerr)
+	waitPropagationFinished(local)
+}
+
+func TestService_ViewAbsentMember(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	servers, roster, s := makeHELS(local, 4)
+	next := local.GetServices(servers, skipchainSID)[1].(*Service)
+	genesis, err := makeGenesisRoster(s, roster)
+	log.ErrFatal(err)
+	waitPropagationFinished(local)
+
+	log.Lvl1("The leader of view 0 goes away and is absent in view 1")
+	log.ErrFatal(servers[0].Close())
+	delete(local.Servers, servers[0].ServerIdentity.ID)
+	sb := NewSkipBlock()
+	sb.Roster = roster
+	ssbr, cerr := next.StoreSkipBlock(&StoreSkipBlock{genesis.Hash, sb, nil})
+	log.ErrFatal(cerr)
+	link := ssbr.Previous.ForwardLink[0]
+	log.ErrFatal(link.VerifySignature(roster.Publics()))
+	absent, err := link.Absent(len(roster.List))
+	log.ErrFatal(err)
+	require.Contains(t, absent, 0)
+	waitPropagationFinished(local)
+}
+
+func TestService_LockSuccessor(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, _, s := makeHELS(local, 1)
+	prev, first, second := SkipBlockID{1}, SkipBlockID{2}, SkipBlockID{3}
+	require.True(t, s.lockSuccessor(prev, 0, first))
+	require.True(t, s.lockSuccessor(prev, 0, first))
+	require.False(t, s.lockSuccessor(prev, 0, second))
+
+	log.Lvl1("The leader of the next view can propose another successor")
+	require.True(t, s.lockSuccessor(prev, 1, second))
+	require.False(t, s.lockSuccessor(prev, 0, first))
+	s.unlockSuccessor(prev)
+	require.True(t, s.lockSuccessor(prev, 0, first))
+
+	log.Lvl1("Locks of unknown blocks are removed")
+	s.forgetViews()
+	require.Equal(t, 0, len(s.successors))
+}
+
+func TestService_ViewFailover(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	servers, roster, s := makeHELS(local, 4)
+	services := local.GetServices(servers, skipchainSID)
+	genesis, err := makeGenesisRoster(s, roster)
+	log.ErrFatal(err)
+	waitPropagationFinished(local)
+
+	log.Lvl1("The members verify the block of the leader of view 0")
+	first := genesis.Copy()
+	first.Index = 1
+	first.GenesisID = genesis.Hash
+	first.BackLinkIDs = []SkipBlockID{genesis.Hash}
+	first.ForwardLink = nil
+	first.Timestamp = time.Now().UnixNano()
+	first.Data = []byte("view 0")
+	first.updateHash()
+	data, err := network.Marshal(first)
+	log.ErrFatal(err)
+	for _, srv := range services[1:] {
+		log.ErrFatal(srv.(*Service).bftVerifyNewBlock(roster.List[0],
+			first.Hash, append(genesis.Hash, data...)))
+	}
+
+	log.Lvl1("The leader goes away after the prepare round")
+	log.ErrFatal(servers[0].Close())
+	delete(local.Servers, servers[0].ServerIdentity.ID)
+
+	log.Lvl1("The leader of view 1 appends its own block")
+	sb := NewSkipBlock()
+	sb.Roster = roster
+	ssbr, cerr := services[1].(*Service).StoreSkipBlock(
+		&StoreSkipBlock{genesis.Hash, sb, nil})
+	log.ErrFatal(cerr)
+	require.Equal(t, 1, ssbr.Latest.Index)
+	require.False(t, ssbr.Latest.Hash.Equal(first.Hash))
+	waitPropagationFinished(local)
+}
+
+func TestService_ViewTimer(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, _, s := makeHELS(local, 1)
+	prev := SkipBlockID{1}
+	require.True(t, s.viewStarted(prev, 0, 0))
+	require.False(t, s.viewStarted(prev, 1, 0))
+	require.False(t, s.viewStarted(prev, 1, viewChangeMargin))
+
+	log.Lvl1("The next view starts once the leader has been silent")
+	s.views[string(prev)] = viewTimer{0,
+		time.Now().Add(-viewChangeTimeout * time.Millisecond)}
+	require.True(t, s.viewStarted(prev, 1, 0))
+	require.False(t, s.viewStarted(prev, 2, 0))
+
+	log.Lvl1("A leader of an old view doesn't restart the timer")
+	s.leaderActive(prev, 0)
+	require.True(t, s.viewStarted(prev, 1, 0))
+
+	log.Lvl1("The leader of the current view restarts the timer")
+	s.leaderActive(prev, 1)
+	require.True(t, s.viewStarted(prev, 1, 0))
+	require.False(t, s.viewStarted(prev, 2, viewChangeMargin))
+
+	s.unlockSuccessor(prev)
+	require.False(t, s.viewStarted(prev, 1, 0))
+}
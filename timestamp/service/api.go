@@ -0,0 +1,85 @@
+package timestamp
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"github.com/dedis/cothority/bftcosi"
+	"github.com/dedis/cothority/byzcoin/blockchain"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+const (
+	// ErrorParameter indicates a wrong parameter in the request.
+	ErrorParameter = iota + 4100
+	// ErrorSignature indicates that the epoch couldn't be signed by the
+	// roster.
+	ErrorSignature
+)
+
+// StampRequest asks the conode to timestamp the hash. The conode must be
+// part of the roster, which signs the epoch.
+type StampRequest struct {
+	Hash   []byte
+	Roster *onet.Roster
+}
+
+// StampReply holds the timestamp of a hash.
+type StampReply struct {
+	// Timestamp is the time of the epoch in seconds since the Unix epoch.
+	Timestamp int64
+	// Root is the root of the Merkle tree of all hashes of the epoch.
+	Root []byte
+	// Proof is the inclusion proof of the hash in the Merkle tree.
+	Proof blockchain.Proof
+	// Signature is the collective signature of SignedMessage(Root,
+	// Timestamp) by the roster, in the compact form of bftcosi.
+	Signature []byte
+}
+
+// Verify returns an error if the reply is not a valid timestamp of hash by
+// the roster with the given public keys.
+func (sr *StampReply) Verify(publics []abstract.Point, hash []byte) error {
+	if !sr.Proof.Check(sha256.New, sr.Root, hash) {
+		return errors.New("Hash is not included in the Merkle tree")
+	}
+	return bftcosi.VerifyCompact(network.Suite, publics,
+		SignedMessage(sr.Root, sr.Timestamp), sr.Signature)
+}
+
+// SignedMessage returns the message that is collectively signed for an
+// epoch: the root of the Merkle tree followed by the time of the epoch as
+// a big-endian int64.
+func SignedMessage(root []byte, timestamp int64) []byte {
+	msg := make([]byte, len(root)+8)
+	copy(msg, root)
+	binary.BigEndian.PutUint64(msg[len(root):], uint64(timestamp))
+	return msg
+}
+
+// Client is a structure to communicate with the timestamp service.
+type Client struct {
+	*onet.Client
+}
+
+// NewClient instantiates a new timestamp.Client.
+func NewClient() *Client {
+	return &Client{Client: onet.NewClient(ServiceName)}
+}
+
+// Stamp asks the first conode of the roster to timestamp the hash.
+func (c *Client) Stamp(r *onet.Roster, hash []byte) (*StampReply, onet.ClientError) {
+	if len(r.List) == 0 {
+		return nil, onet.NewClientErrorCode(ErrorParameter,
+			"Got an empty roster-list")
+	}
+	reply := &StampReply{}
+	cerr := c.SendProtobuf(r.List[0], &StampRequest{hash, r}, reply)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return reply, nil
+}
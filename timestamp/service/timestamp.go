@@ -0,0 +1,242 @@
+package timestamp
+
+/*
+The timestamp service collects the hashes sent by the clients during an
+epoch. At the end of the epoch, it builds a Merkle tree of all hashes and
+has its root co-signed together with the time of the epoch by the roster
+of the request, using the BFTCoSi protocol. Every client gets back the
+inclusion proof of its hash and the collective signature, so one round of
+BFTCoSi timestamps all hashes of the epoch.
+
+The time of the epoch is chosen by the leader, but the other members refuse
+to sign it if it is more than maxTimestampDrift seconds off their own clock,
+so a timestamp is as accurate as the clocks of 2/3 of the roster.
+*/
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dedis/cothority/bftcosi"
+	"github.com/dedis/cothority/byzcoin/blockchain"
+	"github.com/satori/go.uuid"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+// ServiceName is the name to refer to the timestamp service.
+const ServiceName = "Timestamp"
+
+// DefaultEpoch is the time during which the hashes are collected before
+// being signed.
+const DefaultEpoch = time.Second
+
+// signTimeout is the time the leader waits for the collective signature.
+const signTimeout = time.Minute
+
+// How many seconds the time of an epoch may differ from the time of the
+// conodes signing it.
+const maxTimestampDrift = 60
+
+// bftEpoch is the name of the protocol signing an epoch.
+const bftEpoch = "TimestampBFTEpoch"
+
+var timestampSID onet.ServiceID
+
+func init() {
+	timestampSID, _ = onet.RegisterNewService(ServiceName, newTimestampService)
+	network.RegisterMessage(&StampRequest{})
+	network.RegisterMessage(&StampReply{})
+}
+
+// Service collects the hashes of the clients and timestamps them once per
+// epoch.
+type Service struct {
+	*onet.ServiceProcessor
+	// Epoch is the time during which the hashes are collected.
+	Epoch time.Duration
+	// now returns the time of the clock of this conode
+	now func() time.Time
+	// epochs holds the epoch that is collecting hashes for every roster,
+	// indexed by the members of the roster
+	epochs      map[string]*epoch
+	epochsMutex sync.Mutex
+}
+
+// epoch holds the hashes of one epoch and, once it is done, the timestamp
+// of all hashes.
+type epoch struct {
+	roster *onet.Roster
+	hashes []blockchain.HashID
+	// done is closed once the epoch has been signed or failed
+	done      chan bool
+	timestamp int64
+	root      blockchain.HashID
+	proofs    []blockchain.Proof
+	signature []byte
+	err       error
+}
+
+// Stamp adds the hash to the current epoch of the roster and returns once
+// the epoch is signed. The conode receiving the request is the leader of
+// the epoch.
+func (s *Service) Stamp(req *StampRequest) (network.Message, onet.ClientError) {
+	if len(req.Hash) != sha256.Size {
+		return nil, onet.NewClientErrorCode(ErrorParameter,
+			"Hash must be a sha256-hash")
+	}
+	if req.Roster == nil || len(req.Roster.List) == 0 {
+		return nil, onet.NewClientErrorCode(ErrorParameter,
+			"Empty roster")
+	}
+	if req.Roster.ID.IsNil() {
+		req.Roster.ID = onet.RosterID(uuid.NewV4())
+	}
+	if _, si := req.Roster.Search(s.ServerIdentity().ID); si == nil {
+		return nil, onet.NewClientErrorCode(ErrorParameter,
+			"Not in roster")
+	}
+
+	e, index := s.addHash(req.Roster, req.Hash)
+	<-e.done
+	if e.err != nil {
+		return nil, onet.NewClientErrorCode(ErrorSignature,
+			"Couldn't sign epoch: "+e.err.Error())
+	}
+	return &StampReply{
+		Timestamp: e.timestamp,
+		Root:      e.root,
+		Proof:     e.proofs[index],
+		Signature: e.signature,
+	}, nil
+}
+
+// addHash adds the hash to the current epoch of the roster, starting a new
+// epoch if there is none. It returns the epoch and the index of the hash.
+func (s *Service) addHash(roster *onet.Roster, hash []byte) (*epoch, int) {
+	s.epochsMutex.Lock()
+	defer s.epochsMutex.Unlock()
+	key := rosterKey(roster)
+	e, ok := s.epochs[key]
+	if !ok {
+		e = &epoch{
+			roster: roster,
+			done:   make(chan bool),
+		}
+		s.epochs[key] = e
+		time.AfterFunc(s.Epoch, func() {
+			s.signEpoch(e)
+		})
+	}
+	e.hashes = append(e.hashes, hash)
+	return e, len(e.hashes) - 1
+}
+
+// signEpoch closes the epoch, so that new hashes go to the next one, and
+// has the root of its Merkle tree signed.
+func (s *Service) signEpoch(e *epoch) {
+	s.epochsMutex.Lock()
+	delete(s.epochs, rosterKey(e.roster))
+	s.epochsMutex.Unlock()
+	defer close(e.done)
+
+	e.timestamp = s.now().Unix()
+	e.root, e.proofs = blockchain.ProofTree(sha256.New, e.hashes)
+	log.Lvl2(s.ServerIdentity(), "signs epoch with", len(e.hashes), "hashes")
+	e.signature, e.err = s.cosign(e.roster, SignedMessage(e.root, e.timestamp))
+	if e.err != nil {
+		log.Error(s.ServerIdentity(), e.err)
+	}
+}
+
+// cosign runs the BFTCoSi protocol on the roster with us as the leader and
+// returns the collective signature of msg in the compact form.
+func (s *Service) cosign(roster *onet.Roster, msg []byte) ([]byte, error) {
+	tree := roster.GenerateNaryTreeWithRoot(2, s.ServerIdentity())
+	if tree == nil {
+		return nil, errors.New("Couldn't create tree")
+	}
+	node, err := s.CreateProtocol(bftEpoch, tree)
+	if err != nil {
+		return nil, errors.New("Couldn't make new protocol: " + err.Error())
+	}
+	root := node.(*bftcosi.ProtocolBFTCoSi)
+	root.Msg = msg
+	done := make(chan bool, 1)
+	root.RegisterOnDone(func() {
+		done <- true
+	})
+	go node.Start()
+	select {
+	case <-done:
+		if err := root.Err(); err != nil {
+			return nil, err
+		}
+		// the tree put us first in its roster, but the clients verify
+		// the signature with the roster of their request
+		indexes, err := bftcosi.RosterIndexes(tree.Roster, roster)
+		if err != nil {
+			return nil, err
+		}
+		sig := root.Signature().Remap(network.Suite, indexes)
+		if sig.Sig == nil {
+			if err := bftcosi.RefusalError(network.Suite, roster, sig); err != nil {
+				return nil, err
+			}
+			return nil, errors.New("Epoch has been refused")
+		}
+		return sig.Compact(network.Suite, len(roster.List))
+	case <-time.After(signTimeout):
+		return nil, errors.New("Timed out while waiting for signature")
+	}
+}
+
+// verifyEpoch is called by the members of the roster before signing an
+// epoch. They can't check the hashes, which only the leader knows, but they
+// refuse a time that is too far from their own clock.
+func (s *Service) verifyEpoch(msg, data []byte) error {
+	if len(msg) != sha256.Size+8 {
+		return errors.New("Wrong length of epoch message")
+	}
+	drift := s.now().Unix() - int64(binary.BigEndian.Uint64(msg[sha256.Size:]))
+	if drift < 0 {
+		drift = -drift
+	}
+	if drift > maxTimestampDrift {
+		return fmt.Errorf("Time of epoch is %d seconds off", drift)
+	}
+	return nil
+}
+
+// rosterKey returns the members of the roster as a string, so that the
+// requests of clients using the same roster end up in the same epoch, even
+// if the IDs of their rosters differ.
+func rosterKey(roster *onet.Roster) string {
+	var key string
+	for _, si := range roster.List {
+		key += uuid.UUID(si.ID).String()
+	}
+	return key
+}
+
+func newTimestampService(c *onet.Context) onet.Service {
+	s := &Service{
+		ServiceProcessor: onet.NewServiceProcessor(c),
+		Epoch:            DefaultEpoch,
+		now:              time.Now,
+		epochs:           make(map[string]*epoch),
+	}
+	if err := s.RegisterHandler(s.Stamp); err != nil {
+		log.ErrFatal(err, "Couldn't register message:")
+	}
+	s.ProtocolRegister(bftEpoch, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return bftcosi.NewBFTCoSiProtocolReason(n, s.verifyEpoch,
+			bftcosi.DefaultPolicy(len(n.Tree().List())))
+	})
+	return s
+}
@@ -0,0 +1,110 @@
+package timestamp
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+func TestStamp(t *testing.T) {
+	local := onet.NewTCPTest()
+	servers, roster, _ := local.GenTree(5, true)
+	defer local.CloseAll()
+	for _, srv := range local.GetServices(servers, timestampSID) {
+		srv.(*Service).Epoch = 500 * time.Millisecond
+	}
+
+	// all hashes sent at the same time are signed in the same epoch
+	const nbrHashes = 8
+	hashes := make([][]byte, nbrHashes)
+	replies := make(chan int, nbrHashes)
+	stamps := make([]*StampReply, nbrHashes)
+	for i := range hashes {
+		h := sha256.Sum256([]byte(fmt.Sprintf("document %d", i)))
+		hashes[i] = h[:]
+		go func(i int) {
+			// every client has its own connection to the leader
+			reply, cerr := NewClient().Stamp(roster, hashes[i])
+			log.ErrFatal(cerr)
+			stamps[i] = reply
+			replies <- i
+		}(i)
+	}
+	for range hashes {
+		select {
+		case <-replies:
+		case <-time.After(time.Minute):
+			t.Fatal("Didn't get all timestamps")
+		}
+	}
+
+	publics := roster.Publics()
+	for i, stamp := range stamps {
+		assert.Nil(t, stamp.Verify(publics, hashes[i]))
+		assert.Equal(t, stamps[0].Root, stamp.Root)
+		assert.Equal(t, stamps[0].Timestamp, stamp.Timestamp)
+		assert.NotNil(t, stamp.Verify(publics, hashes[(i+1)%nbrHashes]))
+	}
+	stamps[0].Timestamp++
+	assert.NotNil(t, stamps[0].Verify(publics, hashes[0]))
+
+	// the next epoch gets a new root
+	reply, cerr := NewClient().Stamp(roster, hashes[0])
+	log.ErrFatal(cerr)
+	assert.Nil(t, reply.Verify(publics, hashes[0]))
+	assert.NotEqual(t, stamps[1].Root, reply.Root)
+}
+
+func TestVerifyEpoch(t *testing.T) {
+	s := &Service{now: time.Now}
+	root := make([]byte, sha256.Size)
+	now := time.Now().Unix()
+	require.Nil(t, s.verifyEpoch(SignedMessage(root, now), nil))
+	require.NotNil(t, s.verifyEpoch(SignedMessage(root, now-2*maxTimestampDrift), nil))
+	require.NotNil(t, s.verifyEpoch(SignedMessage(root, now+2*maxTimestampDrift), nil))
+	require.NotNil(t, s.verifyEpoch(root, nil))
+}
+
+func TestStampRefusingMember(t *testing.T) {
+	local := onet.NewTCPTest()
+	servers, roster, _ := local.GenTree(4, true)
+	defer local.CloseAll()
+	services := local.GetServices(servers, timestampSID)
+	for _, srv := range services {
+		srv.(*Service).Epoch = 100 * time.Millisecond
+	}
+	// the clock of the first member is off, so it refuses the epochs
+	services[0].(*Service).now = func() time.Time {
+		return time.Now().Add(time.Hour)
+	}
+
+	// the second member is the leader of the epoch, so the tree of the
+	// signature has another order than the roster of the request
+	h := sha256.Sum256([]byte("document"))
+	reply := &StampReply{}
+	cerr := NewClient().SendProtobuf(roster.List[1],
+		&StampRequest{h[:], roster}, reply)
+	log.ErrFatal(cerr)
+	log.ErrFatal(reply.Verify(roster.Publics(), h[:]))
+	require.True(t, time.Now().Unix()-reply.Timestamp <= maxTimestampDrift)
+}
+
+func TestStampWrongRequest(t *testing.T) {
+	local := onet.NewTCPTest()
+	_, roster, _ := local.GenTree(2, true)
+	defer local.CloseAll()
+
+	_, cerr := NewClient().Stamp(roster, []byte("not a hash"))
+	require.NotNil(t, cerr)
+	assert.Equal(t, ErrorParameter, cerr.ErrorCode())
+}
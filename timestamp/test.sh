@@ -0,0 +1,35 @@
+#!/usr/bin/env bash
+
+DBG_TEST=1
+DBG_APP=2
+. $GOPATH/src/gopkg.in/dedis/onet.v1/app/libtest.sh
+
+main(){
+    startTest
+    buildConode
+    test Build
+    test Stamp
+    stopTest
+}
+
+testStamp(){
+    runCoBG 1 2
+    testOut "Running stamp"
+    echo "My Test Message File" > foo.txt
+    echo "My Second Test Message File" > bar.txt
+    testOK runCl stamp -g public.toml -o foo.stamp foo.txt
+    testOK runCl verify -g public.toml -s foo.stamp foo.txt
+    testFail runCl verify -g public.toml -s foo.stamp bar.txt
+    rm foo.txt bar.txt foo.stamp
+}
+
+testBuild(){
+    testOK runCl --help
+    testOK runCo 1 --help
+}
+
+runCl(){
+    dbgRun ./timestamp -d $DBG_APP $@
+}
+
+main
@@ -0,0 +1,176 @@
+// Timestamp asks a cothority to timestamp files and verifies the timestamps.
+// The hashes of all files sent to a conode during an epoch are collected in
+// a Merkle tree, whose root is collectively signed together with the time
+// of the epoch.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/dedis/cothority/timestamp/service"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/app"
+	"gopkg.in/dedis/onet.v1/crypto"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const (
+	// Version of the binary
+	Version = "1.00"
+
+	optionGroup = "group, g"
+)
+
+func main() {
+	cliApp := cli.NewApp()
+	cliApp.Name = "timestamp"
+	cliApp.Usage = "timestamp files with a cothority and verify the timestamps"
+	cliApp.Version = Version
+	groupFlag := cli.StringFlag{
+		Name:  optionGroup,
+		Value: "group.toml",
+		Usage: "Cothority group definition in `FILE.toml`",
+	}
+	cliApp.Commands = []cli.Command{
+		{
+			Name:      "stamp",
+			Aliases:   []string{"s"},
+			Usage:     "timestamp a file; the timestamp is written to STDOUT by default",
+			ArgsUsage: "file",
+			Action:    stampFile,
+			Flags: []cli.Flag{
+				groupFlag,
+				cli.StringFlag{
+					Name:  "out, o",
+					Usage: "write the timestamp to `FILE` instead of STDOUT",
+				},
+			},
+		},
+		{
+			Name:      "verify",
+			Aliases:   []string{"v"},
+			Usage:     "verify the timestamp of a file; the timestamp is read from STDIN by default",
+			ArgsUsage: "file",
+			Action:    verifyFile,
+			Flags: []cli.Flag{
+				groupFlag,
+				cli.StringFlag{
+					Name:  "stamp, s",
+					Usage: "read the timestamp from `FILE` instead of STDIN",
+				},
+			},
+		},
+	}
+	cliApp.Flags = []cli.Flag{
+		cli.IntFlag{
+			Name:  "debug, d",
+			Value: 0,
+			Usage: "debug-level: `integer`: 1 for terse, 5 for maximal",
+		},
+	}
+	cliApp.Before = func(c *cli.Context) error {
+		log.SetDebugVisible(c.GlobalInt("debug"))
+		return nil
+	}
+	log.ErrFatal(cliApp.Run(os.Args))
+}
+
+// stampFile sends the hash of the file to the first conode of the group
+// and writes the timestamp as JSON.
+func stampFile(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("Please give the file to timestamp")
+	}
+	roster, err := readGroup(c.String("group"))
+	if err != nil {
+		return err
+	}
+	hash, err := hashFile(c.Args().First())
+	if err != nil {
+		return err
+	}
+	reply, cerr := timestamp.NewClient().Stamp(roster, hash)
+	if cerr != nil {
+		return cerr
+	}
+	if err := reply.Verify(roster.Publics(), hash); err != nil {
+		return errors.New("Got an invalid timestamp: " + err.Error())
+	}
+	buf, err := json.MarshalIndent(reply, "", "\t")
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+	if out := c.String("out"); out != "" {
+		return ioutil.WriteFile(out, buf, 0644)
+	}
+	_, err = os.Stdout.Write(buf)
+	return err
+}
+
+// verifyFile checks the timestamp of the file against the group.
+func verifyFile(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("Please give the file to verify")
+	}
+	roster, err := readGroup(c.String("group"))
+	if err != nil {
+		return err
+	}
+	hash, err := hashFile(c.Args().First())
+	if err != nil {
+		return err
+	}
+	var buf []byte
+	if stamp := c.String("stamp"); stamp != "" {
+		buf, err = ioutil.ReadFile(stamp)
+	} else {
+		log.Info("Reading timestamp from standard input ...")
+		buf, err = ioutil.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return err
+	}
+	reply := &timestamp.StampReply{}
+	if err := json.Unmarshal(buf, reply); err != nil {
+		return errors.New("Couldn't decode timestamp: " + err.Error())
+	}
+	if err := reply.Verify(roster.Publics(), hash); err != nil {
+		return errors.New("Invalid timestamp: " + err.Error())
+	}
+	log.Info("Timestamp is valid:", time.Unix(reply.Timestamp, 0))
+	return nil
+}
+
+// hashFile returns the sha256-hash of the file.
+func hashFile(name string) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return crypto.HashStream(network.Suite.Hash(), f)
+}
+
+// readGroup reads the group definition of the cothority.
+func readGroup(name string) (*onet.Roster, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	roster, err := app.ReadGroupToml(f)
+	if err != nil {
+		return nil, err
+	}
+	if roster == nil || len(roster.List) == 0 {
+		return nil, errors.New("Empty or invalid group file: " + name)
+	}
+	return roster, nil
+}